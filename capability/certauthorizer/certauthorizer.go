@@ -0,0 +1,198 @@
+package certauthorizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+var ErrInvalidExpression = errors.New("invalid certificate expression")
+
+// CertAuthorizer authorizes the requests by the verified client certificate,
+// the authorizer_expression is a ; separated list of conditions which all
+// must match, a condition is key=value[,value...] and matches any value:
+//
+//	cn              common name
+//	o, ou           organization, organizational unit
+//	san_dns         dns name, *.example.com matches one label
+//	san_email       email address
+//	san_ip          ip address
+//	san_uri         uri
+//	san_uri_prefix  uri prefix (ex: spiffe://cluster.local/ns/payments/)
+//	fingerprint     hex sha256 of the certificate
+//	serial          serial number
+//
+// an empty expression accepts any verified client certificate
+type CertAuthorizer struct {
+	mValues        model.ConfigMap
+	mExpressionMap sync.Map
+}
+
+type condition struct {
+	key       string
+	valueList []string
+}
+
+func (c *CertAuthorizer) Name() string {
+	return "abesh_certauthorizer"
+}
+
+func (c *CertAuthorizer) Version() string {
+	return constant.Version
+}
+
+func (c *CertAuthorizer) Category() string {
+	return string(constant.CategoryAuthorizer)
+}
+
+func (c *CertAuthorizer) ContractId() string {
+	return "abesh:certauthorizer"
+}
+
+func (c *CertAuthorizer) GetConfigMap() model.ConfigMap {
+	return c.mValues
+}
+
+func (c *CertAuthorizer) SetConfigMap(values model.ConfigMap) error {
+	c.mValues = values
+	return nil
+}
+
+func (c *CertAuthorizer) New() iface.ICapability {
+	return &CertAuthorizer{}
+}
+
+func (c *CertAuthorizer) DescribeSecurity() map[string]interface{} {
+	return map[string]interface{}{"type": "mutualTLS"}
+}
+
+func parseExpression(expression string) ([]*condition, error) {
+	var conditionList []*condition
+
+	for _, part := range strings.Split(expression, ";") {
+		if part = strings.TrimSpace(part); len(part) == 0 {
+			continue
+		}
+
+		index := strings.Index(part, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidExpression, part)
+		}
+
+		cond := &condition{key: strings.ToLower(strings.TrimSpace(part[:index]))}
+		for _, v := range strings.Split(part[index+1:], ",") {
+			if v = strings.TrimSpace(v); len(v) != 0 {
+				cond.valueList = append(cond.valueList, v)
+			}
+		}
+
+		if _, found := attributeMap[cond.key]; !found || len(cond.valueList) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidExpression, part)
+		}
+
+		conditionList = append(conditionList, cond)
+	}
+
+	return conditionList, nil
+}
+
+// attributeMap returns the certificate values of a condition key
+var attributeMap = map[string]func(*model.ClientCertificate) []string{
+	"cn":             func(c *model.ClientCertificate) []string { return []string{c.GetCommonName()} },
+	"o":              (*model.ClientCertificate).GetOrganization,
+	"ou":             (*model.ClientCertificate).GetOrganizationalUnit,
+	"san_dns":        (*model.ClientCertificate).GetDnsNames,
+	"san_email":      (*model.ClientCertificate).GetEmailAddresses,
+	"san_ip":         (*model.ClientCertificate).GetIpAddresses,
+	"san_uri":        (*model.ClientCertificate).GetUris,
+	"san_uri_prefix": (*model.ClientCertificate).GetUris,
+	"fingerprint":    func(c *model.ClientCertificate) []string { return []string{c.GetFingerprint()} },
+	"serial":         func(c *model.ClientCertificate) []string { return []string{c.GetSerialNumber()} },
+}
+
+func matchDNS(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	name = strings.ToLower(name)
+
+	if strings.HasPrefix(pattern, "*.") {
+		index := strings.Index(name, ".")
+		return index > 0 && name[index:] == pattern[1:]
+	}
+
+	return pattern == name
+}
+
+func (cond *condition) match(certificate *model.ClientCertificate) bool {
+	for _, actual := range attributeMap[cond.key](certificate) {
+		for _, expected := range cond.valueList {
+			switch cond.key {
+			case "san_uri_prefix":
+				if strings.HasPrefix(actual, expected) {
+					return true
+				}
+			case "san_dns":
+				if matchDNS(expected, actual) {
+					return true
+				}
+			case "fingerprint":
+				if strings.EqualFold(strings.ReplaceAll(expected, ":", ""), actual) {
+					return true
+				}
+			default:
+				if actual == expected {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *CertAuthorizer) conditionList(expression string) ([]*condition, error) {
+	if v, found := c.mExpressionMap.Load(expression); found {
+		return v.([]*condition), nil
+	}
+
+	conditionList, err := parseExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mExpressionMap.Store(expression, conditionList)
+	return conditionList, nil
+}
+
+func (c *CertAuthorizer) IsAuthorized(expression string, metadata *model.Metadata) bool {
+	certificate := metadata.GetClientCertificate()
+	if certificate == nil {
+		return false
+	}
+
+	conditionList, err := c.conditionList(expression)
+	if err != nil {
+		logger.L(c.ContractId()).Error(err.Error(), zap.String("expression", expression))
+		return false
+	}
+
+	for _, cond := range conditionList {
+		if !cond.match(certificate) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&CertAuthorizer{})
+}
@@ -0,0 +1,44 @@
+package certauthorizer
+
+import (
+	"testing"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestCertAuthorizer_IsAuthorized(t *testing.T) {
+	metadata := &model.Metadata{ClientCertificate: &model.ClientCertificate{
+		CommonName:         "payments",
+		OrganizationalUnit: []string{"platform", "billing"},
+		DnsNames:           []string{"payments.svc.example.com"},
+		Uris:               []string{"spiffe://cluster.local/ns/payments/sa/api"},
+		Fingerprint:        "ab01ff",
+	}}
+
+	c := &CertAuthorizer{}
+
+	for expression, want := range map[string]bool{
+		"":                   true,
+		"cn=payments":        true,
+		"cn=orders":          false,
+		"ou=billing,finance": true,
+		"ou=finance":         false,
+		"san_uri_prefix=spiffe://cluster.local/ns/payments/": true,
+		"san_uri_prefix=spiffe://cluster.local/ns/orders/":   false,
+		"san_dns=*.svc.example.com":                          true,
+		"san_dns=*.example.com":                              false,
+		"fingerprint=AB:01:FF":                               true,
+		"cn=payments; ou=platform":                           true,
+		"cn=payments; ou=finance":                            false,
+		"unknown=1":                                          false,
+		"cn":                                                 false,
+	} {
+		if got := c.IsAuthorized(expression, metadata); got != want {
+			t.Errorf("IsAuthorized(%q) = %v, want %v", expression, got, want)
+		}
+	}
+
+	if c.IsAuthorized("", &model.Metadata{}) {
+		t.Error("authorized without a client certificate")
+	}
+}
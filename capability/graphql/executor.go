@@ -0,0 +1,590 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+// sourceKey is the argument key which carries the parent object
+// to the resolvers of non root fields
+const sourceKey = "_source"
+
+// orderedMap keeps the response keys in the requested order
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (o *orderedMap) set(key string, value interface{}) {
+	if _, found := o.values[key]; !found {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *orderedMap) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// executor resolves one operation, fields are resolved level by level
+// so that sibling resolver calls can be batched together
+type executor struct {
+	g         *GraphQL
+	ctx       context.Context
+	metadata  *model.Metadata
+	document  *ast.QueryDocument
+	variables map[string]interface{}
+
+	mu     sync.Mutex
+	errors gqlerror.List
+}
+
+func (e *executor) addError(err *gqlerror.Error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.errors = append(e.errors, err)
+}
+
+// addNullError reports a null non-null value unless an error at or below
+// the path already explains it
+func (e *executor) addNullError(field *ast.Field, path ast.Path) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, err := range e.errors {
+		if hasPrefix(err.Path, path) {
+			return
+		}
+	}
+
+	err := gqlerror.ErrorPathf(path, "cannot return null for non-nullable field %s", field.Name)
+	if field.Position != nil {
+		err.Locations = []gqlerror.Location{{Line: field.Position.Line, Column: field.Position.Column}}
+	}
+	e.errors = append(e.errors, err)
+}
+
+func hasPrefix(path ast.Path, prefix ast.Path) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// appendPath returns a new path, the paths of the siblings must not
+// share the backing array
+func appendPath(path ast.Path, element ast.PathElement) ast.Path {
+	output := make(ast.Path, len(path)+1)
+	copy(output, path)
+	output[len(path)] = element
+
+	return output
+}
+
+func fieldPaths(pathList []ast.Path, field *ast.Field) []ast.Path {
+	output := make([]ast.Path, len(pathList))
+	for i, p := range pathList {
+		output[i] = appendPath(p, ast.PathName(responseKey(field)))
+	}
+
+	return output
+}
+
+func (e *executor) execute(operation *ast.OperationDefinition) interface{} {
+	var root *ast.Definition
+	switch operation.Operation {
+	case ast.Mutation:
+		root = e.g.mSchema.Mutation
+	case ast.Subscription:
+		e.addError(gqlerror.Errorf("subscription is not supported"))
+		return nil
+	default:
+		root = e.g.mSchema.Query
+	}
+
+	if root == nil {
+		e.addError(gqlerror.Errorf("schema does not support %s", operation.Operation))
+		return nil
+	}
+
+	// mutation fields must be executed serially
+	result := e.resolveObjects(root, e.collectFields(root, operation.SelectionSet), []interface{}{nil},
+		[]ast.Path{nil}, operation.Operation == ast.Mutation)
+
+	return result[0]
+}
+
+func (e *executor) skip(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil {
+		if v, ok := d.ArgumentMap(e.variables)["if"].(bool); ok && v {
+			return true
+		}
+	}
+
+	if d := directives.ForName("include"); d != nil {
+		if v, ok := d.ArgumentMap(e.variables)["if"].(bool); ok && !v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *executor) typeConditionMatches(condition string, object *ast.Definition) bool {
+	if len(condition) == 0 || condition == object.Name {
+		return true
+	}
+
+	conditionType := e.g.mSchema.Types[condition]
+	if conditionType == nil {
+		return false
+	}
+
+	for _, t := range e.g.mSchema.GetPossibleTypes(conditionType) {
+		if t.Name == object.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectFields flattens fragments and merges fields with the same response key
+func (e *executor) collectFields(object *ast.Definition, selectionSet ast.SelectionSet) []*ast.Field {
+	fieldList := make([]*ast.Field, 0, len(selectionSet))
+	fieldMap := make(map[string]*ast.Field)
+
+	var collect func(set ast.SelectionSet)
+	collect = func(set ast.SelectionSet) {
+		for _, selection := range set {
+			switch s := selection.(type) {
+			case *ast.Field:
+				if e.skip(s.Directives) {
+					continue
+				}
+
+				key := responseKey(s)
+				if f, found := fieldMap[key]; found {
+					merged := *f
+					merged.SelectionSet = append(append(ast.SelectionSet{}, f.SelectionSet...), s.SelectionSet...)
+					fieldMap[key] = &merged
+					for i := range fieldList {
+						if fieldList[i] == f {
+							fieldList[i] = &merged
+						}
+					}
+					continue
+				}
+
+				fieldMap[key] = s
+				fieldList = append(fieldList, s)
+			case *ast.InlineFragment:
+				if e.skip(s.Directives) || !e.typeConditionMatches(s.TypeCondition, object) {
+					continue
+				}
+				collect(s.SelectionSet)
+			case *ast.FragmentSpread:
+				if e.skip(s.Directives) {
+					continue
+				}
+				fragment := e.document.Fragments.ForName(s.Name)
+				if fragment == nil || !e.typeConditionMatches(fragment.TypeCondition, object) {
+					continue
+				}
+				collect(fragment.SelectionSet)
+			}
+		}
+	}
+	collect(selectionSet)
+
+	return fieldList
+}
+
+func responseKey(field *ast.Field) string {
+	if len(field.Alias) != 0 {
+		return field.Alias
+	}
+
+	return field.Name
+}
+
+// resolveObjects resolves the same set of fields for every source object,
+// pathList holds the response path of every source, an object with a null
+// non-null field is null
+func (e *executor) resolveObjects(object *ast.Definition, fieldList []*ast.Field, sources []interface{}, pathList []ast.Path, serial bool) []interface{} {
+	resultList := make([]*orderedMap, len(sources))
+	for i := range resultList {
+		resultList[i] = newOrderedMap()
+	}
+
+	valueList := make([][]interface{}, len(fieldList))
+
+	resolveField := func(index int) {
+		field := fieldList[index]
+		paths := fieldPaths(pathList, field)
+
+		if field.Name == "__typename" {
+			values := make([]interface{}, len(sources))
+			for i := range values {
+				values[i] = object.Name
+			}
+			valueList[index] = values
+			return
+		}
+
+		if field.Definition == nil {
+			e.addError(gqlerror.ErrorPathf(paths[0], "field %s is not supported", field.Name))
+			valueList[index] = make([]interface{}, len(sources))
+			return
+		}
+
+		var values []interface{}
+		if r, found := e.g.mResolverMap[object.Name+"."+field.Name]; found {
+			values = e.resolve(r, object, field, sources, paths)
+		} else {
+			values = make([]interface{}, len(sources))
+			for i, s := range sources {
+				if m, ok := s.(map[string]interface{}); ok {
+					values[i] = m[field.Name]
+				}
+			}
+		}
+
+		valueList[index] = e.complete(field.Definition.Type, field, values, paths)
+	}
+
+	if serial {
+		for index := range fieldList {
+			resolveField(index)
+		}
+	} else {
+		wg := sync.WaitGroup{}
+		for index := range fieldList {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resolveField(i)
+			}(index)
+		}
+		wg.Wait()
+	}
+
+	output := make([]interface{}, len(resultList))
+	for i := range resultList {
+		output[i] = resultList[i]
+	}
+
+	for index, field := range fieldList {
+		nonNull := field.Definition != nil && field.Definition.Type.NonNull
+		for i := range sources {
+			value := valueList[index][i]
+			if value == nil && nonNull {
+				e.addNullError(field, appendPath(pathList[i], ast.PathName(responseKey(field))))
+				output[i] = nil
+			}
+			resultList[i].set(responseKey(field), value)
+		}
+	}
+
+	return output
+}
+
+// complete converts resolved values to the shape of the field type, a
+// list with a null non-null item is null
+func (e *executor) complete(fieldType *ast.Type, field *ast.Field, values []interface{}, pathList []ast.Path) []interface{} {
+	output := make([]interface{}, len(values))
+
+	if fieldType.Elem != nil {
+		flat := make([]interface{}, 0, len(values))
+		flatPathList := make([]ast.Path, 0, len(values))
+		lengthList := make([]int, len(values))
+
+		for i, v := range values {
+			l, ok := v.([]interface{})
+			if !ok {
+				lengthList[i] = -1
+				continue
+			}
+			lengthList[i] = len(l)
+			flat = append(flat, l...)
+			for j := range l {
+				flatPathList = append(flatPathList, appendPath(pathList[i], ast.PathIndex(j)))
+			}
+		}
+
+		completed := e.complete(fieldType.Elem, field, flat, flatPathList)
+
+		offset := 0
+		for i, l := range lengthList {
+			if l < 0 {
+				continue
+			}
+
+			items := completed[offset : offset+l]
+			output[i] = items
+			if fieldType.Elem.NonNull {
+				for j, item := range items {
+					if item == nil {
+						e.addNullError(field, flatPathList[offset+j])
+						output[i] = nil
+					}
+				}
+			}
+			offset = offset + l
+		}
+
+		return output
+	}
+
+	definition := e.g.mSchema.Types[fieldType.NamedType]
+	if definition == nil || definition.IsLeafType() {
+		return values
+	}
+
+	// group non-null objects by the concrete type
+	groupMap := make(map[string][]int)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		typeName := definition.Name
+		if definition.IsAbstractType() {
+			m, _ := v.(map[string]interface{})
+			typeName, _ = m["__typename"].(string)
+			if len(typeName) == 0 {
+				e.addError(gqlerror.ErrorPathf(pathList[i], "could not resolve the concrete type of %s", field.Name))
+				continue
+			}
+		}
+
+		groupMap[typeName] = append(groupMap[typeName], i)
+	}
+
+	for typeName, indexList := range groupMap {
+		object := e.g.mSchema.Types[typeName]
+		if object == nil || object.Kind != ast.Object {
+			for _, index := range indexList {
+				e.addError(gqlerror.ErrorPathf(pathList[index], "unknown type %s", typeName))
+			}
+			continue
+		}
+
+		sources := make([]interface{}, len(indexList))
+		paths := make([]ast.Path, len(indexList))
+		for i, index := range indexList {
+			sources[i] = values[index]
+			paths[i] = pathList[index]
+		}
+
+		resolved := e.resolveObjects(object, e.collectFields(object, field.SelectionSet), sources, paths, false)
+		for i, index := range indexList {
+			output[index] = resolved[i]
+		}
+	}
+
+	return output
+}
+
+// resolve calls the mapped service once per distinct argument set,
+// or once for all sources when batching is enabled for the field
+func (e *executor) resolve(r *resolver, object *ast.Definition, field *ast.Field, sources []interface{}, pathList []ast.Path) []interface{} {
+	values := make([]interface{}, len(sources))
+	name := object.Name + "." + field.Name
+
+	metadata := model.CloneMetadata(e.metadata)
+	metadata.Method = name
+
+	if r.authorizer != nil {
+		if !r.authorizer.IsAuthorized(r.authorizerExpression, metadata) {
+			for _, path := range pathList {
+				e.addError(&gqlerror.Error{
+					Message:    "forbidden",
+					Path:       path,
+					Extensions: map[string]interface{}{"code": "forbidden"},
+				})
+			}
+			return values
+		}
+	}
+
+	arguments := field.ArgumentMap(e.variables)
+	payloadList := make([][]byte, len(sources))
+	for i, s := range sources {
+		p := make(map[string]interface{}, len(arguments)+1)
+		for k, v := range arguments {
+			p[k] = v
+		}
+		if s != nil {
+			p[sourceKey] = s
+		}
+
+		payloadList[i], _ = json.Marshal(p)
+	}
+
+	if r.batch && len(sources) > 1 {
+		batchPayload := make([]json.RawMessage, len(payloadList))
+		for i := range payloadList {
+			batchPayload[i] = payloadList[i]
+		}
+		data, _ := json.Marshal(batchPayload)
+
+		output, err := e.call(r, metadata, data)
+		if err != nil {
+			for _, path := range pathList {
+				e.addError(toGQLError(field, path, err))
+			}
+			return values
+		}
+
+		outputList, ok := output.([]interface{})
+		if !ok || len(outputList) != len(sources) {
+			for _, path := range pathList {
+				e.addError(gqlerror.ErrorPathf(path, "batch resolver %s must return %d items", name, len(sources)))
+			}
+			return values
+		}
+
+		return outputList
+	}
+
+	// identical calls are made only once
+	type callResult struct {
+		value interface{}
+		err   error
+	}
+
+	resultMap := make(map[string]*callResult)
+	keyList := make([]string, 0, len(payloadList))
+	for _, p := range payloadList {
+		if _, found := resultMap[string(p)]; !found {
+			resultMap[string(p)] = nil
+			keyList = append(keyList, string(p))
+		}
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, key := range keyList {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			v, err := e.call(r, metadata, []byte(k))
+			mu.Lock()
+			resultMap[k] = &callResult{value: v, err: err}
+			mu.Unlock()
+		}(key)
+	}
+	wg.Wait()
+
+	for i, p := range payloadList {
+		result := resultMap[string(p)]
+		if result.err != nil {
+			e.addError(toGQLError(field, pathList[i], result.err))
+			continue
+		}
+		values[i] = result.value
+	}
+
+	return values
+}
+
+func (e *executor) call(r *resolver, metadata *model.Metadata, data []byte) (output interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	inputEvent := &model.Event{
+		Metadata: metadata,
+		TypeUrl:  "application/json",
+		Value:    data,
+	}
+
+	e.g.TransmitInputEvent(r.service.ContractId(), inputEvent)
+
+	nCtx, cancel := context.WithTimeout(e.ctx, e.g.mRequestTimeout)
+	defer cancel()
+
+	outputEvent, err := r.service.Serve(nCtx, inputEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	if outputEvent == nil || len(outputEvent.Value) == 0 {
+		return nil, nil
+	}
+
+	e.g.TransmitOutputEvent(r.service.ContractId(), outputEvent)
+
+	if err = json.Unmarshal(outputEvent.Value, &output); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+func toGQLError(field *ast.Field, path ast.Path, err error) *gqlerror.Error {
+	e := &gqlerror.Error{
+		Message: err.Error(),
+		Path:    path,
+	}
+
+	if field.Position != nil {
+		e.Locations = []gqlerror.Location{{Line: field.Position.Line, Column: field.Position.Column}}
+	}
+
+	var iError iface.IError2
+	if errors.As(err, &iError) {
+		e.Message = iError.GetMessage()
+		e.Extensions = map[string]interface{}{"code": iface.StatusCode2(iError)}
+	}
+
+	return e
+}
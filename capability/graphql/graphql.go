@@ -0,0 +1,393 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+	"github.com/mkawserm/abesh/utility"
+)
+
+var ErrSchemaFileNotDefined = errors.New("schema file not defined")
+var ErrFieldNotDefined = errors.New("field not defined")
+var ErrFieldNotFound = errors.New("field not found in the schema")
+var ErrFieldAlreadyDefined = errors.New("field already defined")
+
+type resolver struct {
+	service              iface.IService
+	authorizer           iface.IAuthorizer
+	authorizerExpression string
+	batch                bool
+	complexity           int
+}
+
+// Request is the standard GraphQL over HTTP request body
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is the standard GraphQL over HTTP response body
+type Response struct {
+	Data   interface{}   `json:"data"`
+	Errors gqlerror.List `json:"errors,omitempty"`
+}
+
+type GraphQL struct {
+	mHost       string
+	mPort       string
+	mCertFile   string
+	mKeyFile    string
+	mPath       string
+	mSchemaFile string
+
+	mValues           model.ConfigMap
+	mHttpServer       *http.Server
+	mHttpServerMux    *http.ServeMux
+	mEventTransmitter iface.IEventTransmitter
+
+	mRequestTimeout  time.Duration
+	mMaxBodySize     int64
+	mMaxDepth        int
+	mMaxComplexity   int
+	mDefaultListSize int
+
+	mSchema      *ast.Schema
+	mResolverMap map[string]*resolver
+}
+
+func (g *GraphQL) Name() string {
+	return "abesh_graphql"
+}
+
+func (g *GraphQL) Version() string {
+	return constant.Version
+}
+
+func (g *GraphQL) Category() string {
+	return string(constant.CategoryTrigger)
+}
+
+func (g *GraphQL) ContractId() string {
+	return "abesh:graphql"
+}
+
+func (g *GraphQL) GetConfigMap() model.ConfigMap {
+	return g.mValues
+}
+
+func (g *GraphQL) SetConfigMap(values model.ConfigMap) error {
+	g.mValues = values
+
+	g.mHost = values.String("host", "0.0.0.0")
+	g.mPort = values.String("port", "8082")
+
+	g.mCertFile = values.String("cert_file", "")
+	g.mKeyFile = values.String("key_file", "")
+
+	g.mPath = values.String("path", "/graphql")
+	g.mSchemaFile = values.String("schema_file", "")
+
+	g.mRequestTimeout = values.Duration("default_request_timeout", time.Second)
+	g.mMaxBodySize = values.Int64("max_body_size", 1<<20)
+
+	// zero disables the limit
+	g.mMaxDepth = values.Int("max_depth", 10)
+	g.mMaxComplexity = values.Int("max_complexity", 1000)
+
+	// the assumed size of the lists without a first, last or limit argument
+	g.mDefaultListSize = values.Int("default_list_size", 10)
+
+	return nil
+}
+
+func (g *GraphQL) SetEventTransmitter(eventTransmitter iface.IEventTransmitter) error {
+	g.mEventTransmitter = eventTransmitter
+	return nil
+}
+
+func (g *GraphQL) GetEventTransmitter() iface.IEventTransmitter {
+	return g.mEventTransmitter
+}
+
+func (g *GraphQL) New() iface.ICapability {
+	return &GraphQL{}
+}
+
+func (g *GraphQL) Setup() error {
+	if len(g.mSchemaFile) == 0 {
+		return ErrSchemaFileNotDefined
+	}
+
+	data, err := ioutil.ReadFile(g.mSchemaFile)
+	if err != nil {
+		return err
+	}
+
+	g.mSchema, err = gqlparser.LoadSchema(&ast.Source{Name: g.mSchemaFile, Input: string(data)})
+	if err != nil {
+		return err
+	}
+
+	g.mResolverMap = make(map[string]*resolver)
+	g.mHttpServer = new(http.Server)
+	g.mHttpServerMux = new(http.ServeMux)
+
+	g.mHttpServer.Handler = g.mHttpServerMux
+	g.mHttpServer.Addr = g.mHost + ":" + g.mPort
+
+	g.mHttpServerMux.HandleFunc(g.mPath, g.httpHandler)
+
+	logger.L(g.ContractId()).Info("graphql server setup complete",
+		zap.String("host", g.mHost),
+		zap.String("port", g.mPort),
+		zap.String("path", g.mPath))
+
+	return nil
+}
+
+func (g *GraphQL) Start(_ context.Context) error {
+	logger.L(g.ContractId()).Info("graphql server started at " + g.mHttpServer.Addr)
+
+	if len(g.mCertFile) != 0 && len(g.mKeyFile) != 0 {
+		if err := g.mHttpServer.ListenAndServeTLS(g.mCertFile, g.mKeyFile); err != http.ErrServerClosed {
+			return err
+		}
+	} else {
+		if err := g.mHttpServer.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *GraphQL) Stop(ctx context.Context) error {
+	if g.mHttpServer != nil {
+		return g.mHttpServer.Shutdown(ctx)
+	}
+
+	return nil
+}
+
+// AddService maps schema field (ex: Query.user) to the service
+func (g *GraphQL) AddService(
+	authorizer iface.IAuthorizer,
+	authorizerExpression string,
+	triggerValues model.ConfigMap,
+	service iface.IService) error {
+
+	logger.L(g.ContractId()).Debug("service add",
+		zap.Any("authorizer", authorizer),
+		zap.Any("expression", authorizerExpression),
+		zap.Any("triggerValues", triggerValues))
+
+	var field string
+	if field = strings.TrimSpace(triggerValues.String("field", "")); len(field) == 0 {
+		return ErrFieldNotDefined
+	}
+
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) != 2 {
+		return ErrFieldNotFound
+	}
+
+	object := g.mSchema.Types[parts[0]]
+	if object == nil || object.Kind != ast.Object || object.Fields.ForName(parts[1]) == nil {
+		return ErrFieldNotFound
+	}
+
+	if _, found := g.mResolverMap[field]; found {
+		return ErrFieldAlreadyDefined
+	}
+
+	g.mResolverMap[field] = &resolver{
+		service:              service,
+		authorizer:           authorizer,
+		authorizerExpression: authorizerExpression,
+		batch:                triggerValues.Bool("batch", false),
+		complexity:           triggerValues.Int("complexity", 1),
+	}
+
+	return nil
+}
+
+func (g *GraphQL) TransmitInputEvent(contractId string, inputEvent *model.Event) {
+	if g.GetEventTransmitter() != nil {
+		go func() {
+			err := g.GetEventTransmitter().TransmitInputEvent(contractId, inputEvent)
+			if err != nil {
+				logger.L(g.ContractId()).Error(err.Error(),
+					zap.String("version", g.Version()),
+					zap.String("name", g.Name()),
+					zap.String("contract_id", g.ContractId()))
+			}
+		}()
+	}
+}
+
+func (g *GraphQL) TransmitOutputEvent(contractId string, outputEvent *model.Event) {
+	if g.GetEventTransmitter() != nil {
+		go func() {
+			err := g.GetEventTransmitter().TransmitOutputEvent(contractId, outputEvent)
+			if err != nil {
+				logger.L(g.ContractId()).Error(err.Error(),
+					zap.String("version", g.Version()),
+					zap.String("name", g.Name()),
+					zap.String("contract_id", g.ContractId()))
+			}
+		}()
+	}
+}
+
+func (g *GraphQL) buildMetadata(request *http.Request) *model.Metadata {
+	metadata := &model.Metadata{}
+	metadata.Path = request.URL.EscapedPath()
+	metadata.Headers = make(map[string]string)
+	metadata.Query = make(map[string]string)
+	metadata.ContractIdList = append(metadata.ContractIdList, g.ContractId())
+
+	for k, v := range request.Header {
+		if len(v) > 0 {
+			metadata.SetHeaderValueList(k, v)
+		}
+	}
+
+	for k, v := range request.URL.Query() {
+		if len(v) > 0 {
+			metadata.SetQueryValueList(k, v)
+		}
+	}
+
+	return metadata
+}
+
+func (g *GraphQL) httpHandler(writer http.ResponseWriter, request *http.Request) {
+	gqlRequest := &Request{}
+
+	switch request.Method {
+	case http.MethodGet:
+		gqlRequest.Query = request.URL.Query().Get("query")
+		gqlRequest.OperationName = request.URL.Query().Get("operationName")
+		if v := request.URL.Query().Get("variables"); len(v) != 0 {
+			if err := json.Unmarshal([]byte(v), &gqlRequest.Variables); err != nil {
+				g.writeResponse(writer, http.StatusBadRequest, &Response{Errors: gqlerror.List{gqlerror.Errorf("invalid variables")}})
+				return
+			}
+		}
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, g.mMaxBodySize))
+		if err != nil {
+			writer.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err = json.Unmarshal(data, gqlRequest); err != nil {
+			g.writeResponse(writer, http.StatusBadRequest, &Response{Errors: gqlerror.List{gqlerror.Errorf("invalid request body")}})
+			return
+		}
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestId := utility.RequestIdFromHeader(request.Header)
+	writer.Header().Set(utility.RequestIdHeader, requestId)
+
+	metadata := g.buildMetadata(request)
+	metadata.UniqueId = requestId
+
+	g.writeResponse(writer, http.StatusOK, g.Execute(logger.WithRequestId(request.Context(), requestId), metadata, gqlRequest, request.Method == http.MethodGet))
+}
+
+func (g *GraphQL) writeResponse(writer http.ResponseWriter, statusCode int, response *Response) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		logger.L(g.ContractId()).Error(err.Error(),
+			zap.String("version", g.Version()),
+			zap.String("name", g.Name()),
+			zap.String("contract_id", g.ContractId()))
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	if _, err = writer.Write(data); err != nil {
+		logger.L(g.ContractId()).Error(err.Error(),
+			zap.String("version", g.Version()),
+			zap.String("name", g.Name()),
+			zap.String("contract_id", g.ContractId()))
+	}
+}
+
+// Execute validates and executes the GraphQL request,
+// readOnly rejects mutation operations
+func (g *GraphQL) Execute(ctx context.Context, metadata *model.Metadata, request *Request, readOnly bool) *Response {
+	document, errList := gqlparser.LoadQuery(g.mSchema, request.Query)
+	if len(errList) != 0 {
+		return &Response{Errors: errList}
+	}
+
+	operation := document.Operations.ForName(request.OperationName)
+	if operation == nil {
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("operation not found")}}
+	}
+
+	if readOnly && operation.Operation == ast.Mutation {
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("mutation is not allowed with GET")}}
+	}
+
+	variables, err := validator.VariableValues(g.mSchema, operation, request.Variables)
+	if err != nil {
+		if gqlError, ok := err.(*gqlerror.Error); ok {
+			return &Response{Errors: gqlerror.List{gqlError}}
+		}
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf(err.Error())}}
+	}
+
+	if g.mMaxDepth > 0 {
+		if depth := queryDepth(document, operation.SelectionSet, map[string]bool{}); depth > g.mMaxDepth {
+			return &Response{Errors: gqlerror.List{gqlerror.Errorf("query depth %d exceeds the limit %d", depth, g.mMaxDepth)}}
+		}
+	}
+
+	if g.mMaxComplexity > 0 {
+		if complexity := g.queryComplexity(document, operation.SelectionSet, variables, map[string]bool{}); complexity > g.mMaxComplexity {
+			return &Response{Errors: gqlerror.List{gqlerror.Errorf("query complexity %d exceeds the limit %d", complexity, g.mMaxComplexity)}}
+		}
+	}
+
+	e := &executor{
+		g:         g,
+		ctx:       ctx,
+		metadata:  metadata,
+		document:  document,
+		variables: variables,
+	}
+
+	data := e.execute(operation)
+
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&GraphQL{})
+}
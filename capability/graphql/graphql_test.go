@@ -0,0 +1,213 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	errors2 "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+const testSchema = `
+type Query {
+	user(id: ID!): User
+	users(first: Int): [User!]!
+	team: [User!]
+	broken: User!
+	other: String
+}
+
+type User {
+	id: ID!
+	name: String!
+	nickname: String
+	friends(first: Int): [User!]!
+}
+`
+
+type testService struct {
+	iface.IService
+	calls int32
+	fn    func(value []byte) (interface{}, error)
+}
+
+func (s *testService) ContractId() string {
+	return "test:service"
+}
+
+func (s *testService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	atomic.AddInt32(&s.calls, 1)
+
+	output, err := s.fn(event.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(output)
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "application/json", data), nil
+}
+
+func newTestGraphQL(t *testing.T, values model.ConfigMap) *GraphQL {
+	schemaFile := filepath.Join(t.TempDir(), "schema.graphql")
+	if err := os.WriteFile(schemaFile, []byte(testSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if values == nil {
+		values = model.ConfigMap{}
+	}
+	values["schema_file"] = schemaFile
+
+	g := &GraphQL{}
+	_ = g.SetConfigMap(values)
+	if err := g.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func (g *GraphQL) addTestService(t *testing.T, field string, batch bool, fn func(value []byte) (interface{}, error)) *testService {
+	s := &testService{fn: fn}
+	if err := g.AddService(nil, "", model.ConfigMap{"field": field, "batch": fmt.Sprint(batch)}, s); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func execute(g *GraphQL, query string, variables map[string]interface{}) (string, []string) {
+	response := g.Execute(context.Background(), &model.Metadata{}, &Request{Query: query, Variables: variables}, false)
+	data, _ := json.Marshal(response.Data)
+
+	var errorList []string
+	for _, err := range response.Errors {
+		path, _ := json.Marshal(err.Path)
+		errorList = append(errorList, string(path)+" "+err.Message)
+	}
+
+	return string(data), errorList
+}
+
+func sourceId(payload map[string]interface{}) string {
+	source, _ := payload[sourceKey].(map[string]interface{})
+	id, _ := source["id"].(string)
+	return id
+}
+
+func usersResolver(_ []byte) (interface{}, error) {
+	return []interface{}{
+		map[string]interface{}{"id": "1"},
+		map[string]interface{}{"id": "2"},
+		map[string]interface{}{"id": "3"},
+	}, nil
+}
+
+func TestGraphQL_Limits(t *testing.T) {
+	g := newTestGraphQL(t, model.ConfigMap{"max_depth": "3", "max_complexity": "50"})
+	g.addTestService(t, "Query.users", false, usersResolver)
+
+	if _, errorList := execute(g, `{ users { friends { friends { id } } } }`, nil); len(errorList) != 1 ||
+		!strings.Contains(errorList[0], "depth 4 exceeds") {
+		t.Errorf("depth = %v", errorList)
+	}
+
+	// the selection of a list costs once per requested item
+	if _, errorList := execute(g, `query($n: Int) { users(first: $n) { id } }`, map[string]interface{}{"n": 100}); len(errorList) != 1 ||
+		!strings.Contains(errorList[0], "complexity 101 exceeds") {
+		t.Errorf("first = %v", errorList)
+	}
+
+	// without an argument the default list size is assumed
+	if _, errorList := execute(g, `{ users { friends { id } } }`, nil); len(errorList) != 1 ||
+		!strings.Contains(errorList[0], "complexity 111 exceeds") {
+		t.Errorf("nested = %v", errorList)
+	}
+
+	// a huge list argument does not overflow the complexity
+	if _, errorList := execute(g, `{ users(first: 2147483647) { friends(first: 2147483647) { id } } }`, nil); len(errorList) != 1 ||
+		!strings.Contains(errorList[0], "exceeds") {
+		t.Errorf("overflow = %v", errorList)
+	}
+
+	if data, errorList := execute(g, `{ users(first: 3) { id } }`, nil); len(errorList) != 0 || data != `{"users":[{"id":"1"},{"id":"2"},{"id":"3"}]}` {
+		t.Errorf("allowed = %s %v", data, errorList)
+	}
+}
+
+func TestGraphQL_Batch(t *testing.T) {
+	g := newTestGraphQL(t, nil)
+	g.addTestService(t, "Query.users", false, usersResolver)
+	names := g.addTestService(t, "User.name", true, func(value []byte) (interface{}, error) {
+		var payloadList []map[string]interface{}
+		if err := json.Unmarshal(value, &payloadList); err != nil {
+			return nil, err
+		}
+
+		output := make([]interface{}, len(payloadList))
+		for i, p := range payloadList {
+			output[i] = "user-" + sourceId(p)
+		}
+		return output, nil
+	})
+
+	data, errorList := execute(g, `{ users { id name } }`, nil)
+	if len(errorList) != 0 || data != `{"users":[{"id":"1","name":"user-1"},{"id":"2","name":"user-2"},{"id":"3","name":"user-3"}]}` {
+		t.Errorf("batch = %s %v", data, errorList)
+	}
+
+	if atomic.LoadInt32(&names.calls) != 1 {
+		t.Errorf("calls = %d, want 1", names.calls)
+	}
+}
+
+func TestGraphQL_Errors(t *testing.T) {
+	g := newTestGraphQL(t, nil)
+	g.addTestService(t, "Query.users", false, usersResolver)
+	g.addTestService(t, "Query.team", false, usersResolver)
+	g.addTestService(t, "Query.broken", false, func(_ []byte) (interface{}, error) { return nil, nil })
+	g.addTestService(t, "Query.other", false, func(_ []byte) (interface{}, error) { return "ok", nil })
+
+	fail := func(value []byte) (interface{}, error) {
+		payload := map[string]interface{}{}
+		_ = json.Unmarshal(value, &payload)
+		if id := sourceId(payload); id != "2" {
+			return "user-" + id, nil
+		}
+		return nil, fmt.Errorf("load: %w", errors2.NotFound("user", "user not found", nil))
+	}
+	g.addTestService(t, "User.nickname", false, fail)
+	g.addTestService(t, "User.name", false, fail)
+
+	// a nullable field is null at its full path
+	data, errorList := execute(g, `{ users { nick: nickname } }`, nil)
+	if data != `{"users":[{"nick":"user-1"},{"nick":null},{"nick":"user-3"}]}` ||
+		len(errorList) != 1 || errorList[0] != `["users",1,"nick"] user not found` {
+		t.Errorf("nullable = %s %v", data, errorList)
+	}
+
+	// the null of a non-null field makes the nullable team null
+	data, errorList = execute(g, `{ team { name } other }`, nil)
+	if data != `{"team":null,"other":"ok"}` || len(errorList) != 1 || errorList[0] != `["team",1,"name"] user not found` {
+		t.Errorf("propagated = %s %v", data, errorList)
+	}
+
+	// and reaches the root through the non-null users
+	data, errorList = execute(g, `{ users { name } other }`, nil)
+	if data != `null` || len(errorList) != 1 {
+		t.Errorf("root = %s %v", data, errorList)
+	}
+
+	// a null without an error is reported
+	data, errorList = execute(g, `{ broken { id } }`, nil)
+	if data != `null` || len(errorList) != 1 || !strings.HasPrefix(errorList[0], `["broken"] cannot return null`) {
+		t.Errorf("null = %s %v", data, errorList)
+	}
+}
@@ -0,0 +1,119 @@
+package graphql
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// queryDepth returns the maximum nesting level of the selection set
+func queryDepth(document *ast.QueryDocument, selectionSet ast.SelectionSet, visited map[string]bool) int {
+	max := 0
+
+	for _, selection := range selectionSet {
+		depth := 0
+
+		switch s := selection.(type) {
+		case *ast.Field:
+			depth = 1 + queryDepth(document, s.SelectionSet, visited)
+		case *ast.InlineFragment:
+			depth = queryDepth(document, s.SelectionSet, visited)
+		case *ast.FragmentSpread:
+			fragment := document.Fragments.ForName(s.Name)
+			if fragment == nil || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+			depth = queryDepth(document, fragment.SelectionSet, visited)
+			delete(visited, s.Name)
+		}
+
+		if depth > max {
+			max = depth
+		}
+	}
+
+	return max
+}
+
+// maxComplexity bounds the computed complexity, so that large list
+// arguments can not overflow it
+const maxComplexity = math.MaxInt32
+
+func saturatedAdd(a, b int) int {
+	if a > maxComplexity-b {
+		return maxComplexity
+	}
+
+	return a + b
+}
+
+func saturatedMultiply(a, b int) int {
+	if a > maxComplexity/b {
+		return maxComplexity
+	}
+
+	return a * b
+}
+
+// listSize returns the first, last or limit argument of a list field,
+// the default list size without them
+func (g *GraphQL) listSize(field *ast.Field, variables map[string]interface{}) int {
+	arguments := field.ArgumentMap(variables)
+	for _, name := range []string{"first", "last", "limit"} {
+		switch v := arguments[name].(type) {
+		case int:
+			return v
+		case int64:
+			return int(v)
+		case float64:
+			return int(v)
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				return int(n)
+			}
+		}
+	}
+
+	return g.mDefaultListSize
+}
+
+// queryComplexity returns the sum of all field costs in the selection set,
+// fields mapped to a service cost as much as configured in the trigger values
+// and the selection of a list field costs once per item
+func (g *GraphQL) queryComplexity(document *ast.QueryDocument, selectionSet ast.SelectionSet, variables map[string]interface{}, visited map[string]bool) int {
+	total := 0
+
+	for _, selection := range selectionSet {
+		switch s := selection.(type) {
+		case *ast.Field:
+			cost := 1
+			if s.ObjectDefinition != nil {
+				if r, found := g.mResolverMap[s.ObjectDefinition.Name+"."+s.Name]; found {
+					cost = r.complexity
+				}
+			}
+
+			children := g.queryComplexity(document, s.SelectionSet, variables, visited)
+			if s.Definition != nil && s.Definition.Type.Elem != nil && children != 0 {
+				if size := g.listSize(s, variables); size > 1 {
+					children = saturatedMultiply(children, size)
+				}
+			}
+			total = saturatedAdd(total, saturatedAdd(cost, children))
+		case *ast.InlineFragment:
+			total = saturatedAdd(total, g.queryComplexity(document, s.SelectionSet, variables, visited))
+		case *ast.FragmentSpread:
+			fragment := document.Fragments.ForName(s.Name)
+			if fragment == nil || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+			total = saturatedAdd(total, g.queryComplexity(document, fragment.SelectionSet, variables, visited))
+			delete(visited, s.Name)
+		}
+	}
+
+	return total
+}
@@ -2,14 +2,19 @@ package httpclient
 
 import (
 	"context"
+	"crypto/tls"
+	"github.com/mkawserm/abesh/certmanager"
 	"github.com/mkawserm/abesh/constant"
 	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
 	"github.com/mkawserm/abesh/model"
 	"github.com/mkawserm/abesh/registry"
+	"github.com/mkawserm/abesh/tracing"
 	"github.com/mkawserm/abesh/utility"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -96,6 +101,35 @@ func (h *HTTPClient) Setup() error {
 		MaxResponseHeaderBytes: h.mMaxResponseHeaderBytes,
 		WriteBufferSize:        h.mWriteBufferSize,
 		ReadBufferSize:         h.mReadBufferSize,
+
+		// a custom dialer or tls config disables http2 otherwise
+		ForceAttemptHTTP2: true,
+	}
+
+	// client certificates are reloaded by the certificate manager, the
+	// default tls configuration is kept when nothing is configured
+	tlsConfig, err := certmanager.ConfigFromValues(h.mValues, "client_")
+	if err != nil {
+		return err
+	}
+
+	if len(tlsConfig.CertificateList) != 0 {
+		certManager, err := certmanager.New(tlsConfig)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = certManager.ClientTLSConfig()
+	} else if tlsConfig.Configured() {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:   tlsConfig.MinVersion,
+			CipherSuites: tlsConfig.CipherSuites,
+		}
+
+		if len(tlsConfig.RootCAFile) != 0 {
+			if transport.TLSClientConfig.RootCAs, err = certmanager.LoadCertPool(tlsConfig.RootCAFile); err != nil {
+				return err
+			}
+		}
 	}
 
 	h.mHttpClient = &http.Client{
@@ -127,7 +161,26 @@ func (h *HTTPClient) Post(ctx context.Context, metadata *model.Metadata, headers
 }
 
 func (h *HTTPClient) Do(ctx context.Context, method string, metadata *model.Metadata, headers map[string]string, url string, body io.Reader) (*http.Response, error) {
-	r, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), url, body)
+	method = strings.ToUpper(method)
+
+	if metadata != nil {
+		// continue the trace of the event when ctx does not carry a span
+		ctx = tracing.ContextWithTraceParent(ctx, metadata.TraceParent)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// forward the request id, explicit headers take precedence
+	if requestId := logger.RequestId(ctx); len(requestId) != 0 {
+		r.Header.Set(utility.RequestIdHeader, requestId)
+	}
+
+	if metadata != nil && len(metadata.UniqueId) != 0 {
+		r.Header.Set(utility.RequestIdHeader, metadata.UniqueId)
+	}
 
 	mergedHeaders := headers
 	if metadata != nil {
@@ -140,11 +193,38 @@ func (h *HTTPClient) Do(ctx context.Context, method string, metadata *model.Meta
 		}
 	}
 
+	return h.DoRequest(r)
+}
+
+// DoRequest sends a prepared request, the request id of the context is
+// forwarded unless the request already carries one
+func (h *HTTPClient) DoRequest(r *http.Request) (*http.Response, error) {
+	ctx, span := tracing.StartSpan(r.Context(), "HTTP "+r.Method, tracing.SpanKindClient)
+	defer span.End()
+
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.String())
+
+	r = r.WithContext(ctx)
+
+	if requestId := logger.RequestId(ctx); len(requestId) != 0 && len(r.Header.Get(utility.RequestIdHeader)) == 0 {
+		r.Header.Set(utility.RequestIdHeader, requestId)
+	}
+
+	tracing.Inject(ctx, r.Header)
+
+	response, err := h.mHttpClient.Do(r)
 	if err != nil {
+		span.SetError(err)
 		return nil, err
 	}
 
-	return h.mHttpClient.Do(r)
+	span.SetAttribute("http.status_code", strconv.Itoa(response.StatusCode))
+	if response.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(tracing.StatusError, response.Status)
+	}
+
+	return response, nil
 }
 
 func init() {
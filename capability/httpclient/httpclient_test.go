@@ -0,0 +1,104 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestHTTPClient_TLS(t *testing.T) {
+	h := &HTTPClient{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the default transport keeps the system roots and http2
+	transport := h.mHttpClient.Transport.(*http.Transport)
+	if transport.TLSClientConfig != nil || !transport.ForceAttemptHTTP2 {
+		t.Errorf("tls config = %v, force http2 = %v", transport.TLSClientConfig, transport.ForceAttemptHTTP2)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the server certificate of a private ca is verified with the root ca file
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	h = &HTTPClient{}
+	_ = h.SetConfigMap(model.ConfigMap{"client_root_ca_file": caFile})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := h.Get(context.Background(), nil, nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+
+	h = &HTTPClient{}
+	_ = h.SetConfigMap(model.ConfigMap{"client_root_ca_file": filepath.Join(t.TempDir(), "missing.pem")})
+	if err = h.Setup(); err == nil {
+		t.Error("Setup() error = nil for a missing root ca file")
+	}
+}
+
+func TestHTTPClient_RequestId(t *testing.T) {
+	h := &HTTPClient{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	requestIdList := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestIdList <- request.Header.Get("X-Request-ID")
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := logger.WithRequestId(context.Background(), "req-1")
+	check := func(name string, response *http.Response, err error, want string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s error = %v", name, err)
+		}
+		_ = response.Body.Close()
+
+		if id := <-requestIdList; id != want {
+			t.Errorf("%s request id = %q, want %q", name, id, want)
+		}
+	}
+
+	response, err := h.Do(ctx, http.MethodGet, nil, nil, server.URL, nil)
+	check("Do", response, err, "req-1")
+
+	// the unique id of the event takes precedence over ctx
+	response, err = h.Do(ctx, http.MethodGet, &model.Metadata{UniqueId: "req-2"}, nil, server.URL, nil)
+	check("Do with metadata", response, err, "req-2")
+
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	response, err = h.DoRequest(request)
+	check("DoRequest", response, err, "req-1")
+
+	// an explicit header is kept
+	request, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	request.Header.Set("X-Request-ID", "req-3")
+	response, err = h.DoRequest(request)
+	check("DoRequest with header", response, err, "req-3")
+}
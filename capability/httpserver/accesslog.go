@@ -0,0 +1,316 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/utility"
+)
+
+var ErrUnknownAccessLogFormat = errors.New("unknown access log format")
+var ErrUnknownAccessLogOutput = errors.New("unknown access log output")
+
+const maskedValue = "***"
+
+var defaultMaskHeaderList = []string{"Authorization", "Cookie"}
+
+const combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+type accessInfoKey struct{}
+
+// accessInfo is filled by the route handler so that the access log knows
+// the matched route and the request id
+type accessInfo struct {
+	route     string
+	requestId string
+}
+
+func withAccessInfo(ctx context.Context, info *accessInfo) context.Context {
+	return context.WithValue(ctx, accessInfoKey{}, info)
+}
+
+func accessInfoFrom(ctx context.Context) *accessInfo {
+	info, _ := ctx.Value(accessInfoKey{}).(*accessInfo)
+	return info
+}
+
+type accessLogEntry struct {
+	Time       string            `json:"time"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Path       string            `json:"path"`
+	Query      string            `json:"query,omitempty"`
+	Protocol   string            `json:"protocol"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	Duration   float64           `json:"duration_ms"`
+	RemoteAddr string            `json:"remote_addr"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Referer    string            `json:"referer,omitempty"`
+	RequestId  string            `json:"request_id,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// accessLog writes one line per request in json or apache combined format
+// to the zap logger, stdout or a rotating file
+type accessLog struct {
+	mContractId  string
+	mFormat      string
+	mWriter      io.Writer
+	mMutex       sync.Mutex
+	mSampleRate  float64
+	mExcludeList []string
+	mHeaderList  []string
+	mMaskHeaders map[string]bool
+	mMaskQuery   map[string]bool
+}
+
+func newAccessLog(contractId string, values model.ConfigMap) (*accessLog, error) {
+	a := &accessLog{
+		mContractId:  contractId,
+		mFormat:      values.String("access_log_format", "json"),
+		mSampleRate:  values.Float64("access_log_sample_rate", 1),
+		mExcludeList: trimmedList(values, "access_log_exclude_paths"),
+		mHeaderList:  trimmedList(values, "access_log_headers"),
+		mMaskHeaders: make(map[string]bool),
+		mMaskQuery:   make(map[string]bool),
+	}
+
+	if a.mFormat != "json" && a.mFormat != "combined" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccessLogFormat, a.mFormat)
+	}
+
+	maskHeaderList := defaultMaskHeaderList
+	if _, ok := values["access_log_mask_headers"]; ok {
+		maskHeaderList = trimmedList(values, "access_log_mask_headers")
+	}
+
+	for _, h := range maskHeaderList {
+		a.mMaskHeaders[strings.ToLower(h)] = true
+	}
+
+	for _, q := range trimmedList(values, "access_log_mask_query") {
+		a.mMaskQuery[q] = true
+	}
+
+	switch output := values.String("access_log_output", "zap"); output {
+	case "zap":
+	case "stdout":
+		a.mWriter = os.Stdout
+	case "file":
+		file, err := newRotatingFile(
+			values.String("access_log_file", "access.log"),
+			values.Int64("access_log_max_size", 100<<20),
+			values.Int("access_log_max_backups", 5))
+		if err != nil {
+			return nil, err
+		}
+		a.mWriter = file
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccessLogOutput, output)
+	}
+
+	return a, nil
+}
+
+// excluded reports whether the path is excluded, an entry ending with *
+// excludes every path with that prefix
+func (a *accessLog) excluded(path string) bool {
+	for _, e := range a.mExcludeList {
+		if strings.HasSuffix(e, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(e, "*")) {
+				return true
+			}
+		} else if path == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sampled reports whether the request should be logged, errors are always logged
+func (a *accessLog) sampled(status int) bool {
+	if status >= http.StatusBadRequest || a.mSampleRate >= 1 {
+		return true
+	}
+
+	return rand.Float64() < a.mSampleRate
+}
+
+func (a *accessLog) maskQuery(rawQuery string) string {
+	if len(rawQuery) == 0 || len(a.mMaskQuery) == 0 {
+		return rawQuery
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+
+	for key, valueList := range query {
+		if a.mMaskQuery[key] {
+			for i := range valueList {
+				valueList[i] = maskedValue
+			}
+		}
+	}
+
+	return strings.ReplaceAll(query.Encode(), url.QueryEscape(maskedValue), maskedValue)
+}
+
+func (a *accessLog) header(request *http.Request, key string) string {
+	value := request.Header.Get(key)
+	if len(value) != 0 && a.mMaskHeaders[strings.ToLower(key)] {
+		return maskedValue
+	}
+
+	return value
+}
+
+func (a *accessLog) entry(request *http.Request, rw *responseWriter, info *accessInfo, timerStart time.Time) *accessLogEntry {
+	remoteAddr := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+
+	e := &accessLogEntry{
+		Time:       timerStart.Format(time.RFC3339Nano),
+		Method:     request.Method,
+		Route:      routeUnmatched,
+		Path:       request.URL.EscapedPath(),
+		Query:      a.maskQuery(request.URL.RawQuery),
+		Protocol:   request.Proto,
+		Status:     rw.status(),
+		Bytes:      rw.size,
+		Duration:   float64(time.Since(timerStart).Microseconds()) / 1000,
+		RemoteAddr: remoteAddr,
+		UserAgent:  a.header(request, "User-Agent"),
+		Referer:    a.header(request, "Referer"),
+		RequestId:  request.Header.Get(utility.RequestIdHeader),
+	}
+
+	if info != nil && len(info.route) != 0 {
+		e.Route = info.route
+		e.RequestId = info.requestId
+	}
+
+	if len(a.mHeaderList) != 0 {
+		e.Headers = make(map[string]string, len(a.mHeaderList))
+		for _, key := range a.mHeaderList {
+			if value := a.header(request, key); len(value) != 0 {
+				e.Headers[key] = value
+			}
+		}
+	}
+
+	return e
+}
+
+func (a *accessLog) combined(e *accessLogEntry, timerStart time.Time) string {
+	uri := e.Path
+	if len(e.Query) != 0 {
+		uri = uri + "?" + e.Query
+	}
+
+	dash := func(s string) string {
+		if len(s) == 0 {
+			return "-"
+		}
+		return s
+	}
+
+	// the request line is quoted, so that a raw query can not break the line
+	return fmt.Sprintf("%s - - [%s] %q %d %d %q %q",
+		e.RemoteAddr,
+		timerStart.Format(combinedTimeLayout),
+		e.Method+" "+uri+" "+e.Protocol,
+		e.Status, e.Bytes,
+		dash(e.Referer), dash(e.UserAgent))
+}
+
+func (a *accessLog) log(request *http.Request, rw *responseWriter, info *accessInfo, timerStart time.Time) {
+	if a.excluded(request.URL.Path) || !a.sampled(rw.status()) {
+		return
+	}
+
+	e := a.entry(request, rw, info, timerStart)
+
+	if a.mWriter == nil {
+		if a.mFormat == "combined" {
+			logger.L(a.mContractId).Info(a.combined(e, timerStart))
+			return
+		}
+
+		logger.L(a.mContractId).Info("access",
+			zap.String("method", e.Method),
+			zap.String("route", e.Route),
+			zap.String("path", e.Path),
+			zap.String("query", e.Query),
+			zap.Int("status", e.Status),
+			zap.Int64("bytes", e.Bytes),
+			zap.Float64("duration_ms", e.Duration),
+			zap.String("remote_addr", e.RemoteAddr),
+			zap.String("user_agent", e.UserAgent),
+			zap.String("referer", e.Referer),
+			zap.String("request_id", e.RequestId),
+			zap.Any("headers", e.Headers))
+		return
+	}
+
+	var line []byte
+	if a.mFormat == "combined" {
+		line = []byte(a.combined(e, timerStart))
+	} else {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			logger.L(a.mContractId).Error(err.Error())
+			return
+		}
+	}
+
+	a.mMutex.Lock()
+	defer a.mMutex.Unlock()
+
+	if _, err := a.mWriter.Write(append(line, '\n')); err != nil {
+		logger.L(a.mContractId).Error("access log write failed", zap.Error(err))
+	}
+}
+
+// handler wraps the next handler so that every request is logged,
+// including the ones served by the fallback mux
+func (a *accessLog) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		timerStart := time.Now()
+
+		info := &accessInfo{}
+		rw := &responseWriter{ResponseWriter: writer}
+
+		next.ServeHTTP(rw, request.WithContext(withAccessInfo(request.Context(), info)))
+
+		a.log(request, rw, info, timerStart)
+	})
+}
+
+func (a *accessLog) close() error {
+	if closer, ok := a.mWriter.(io.Closer); ok && a.mWriter != os.Stdout {
+		return closer.Close()
+	}
+
+	return nil
+}
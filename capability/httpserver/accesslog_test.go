@@ -0,0 +1,188 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestAccessLog_JSON(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.Setup()
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "POST", "path": "/echo/{id}"}, &testEchoService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newAccessLog(h.ContractId(), model.ConfigMap{
+		"access_log_exclude_paths": "/health*",
+		"access_log_headers":       "Authorization,X-Tenant",
+		"access_log_mask_query":    "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	a.mWriter = buffer
+	handler := a.handler(h.mRouter)
+
+	request := httptest.NewRequest(http.MethodPost, "/echo/1?token=secret&page=2", strings.NewReader("hello"))
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("X-Tenant", "acme")
+	request.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	lineList := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lineList) != 1 {
+		t.Fatalf("lines = %d, want 1: %s", len(lineList), buffer.String())
+	}
+
+	entry := &accessLogEntry{}
+	if err := json.Unmarshal([]byte(lineList[0]), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Route != "/echo/{id}" || entry.Path != "/echo/1" || entry.Status != 200 || entry.Bytes != 5 {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	if entry.RequestId != "req-1" {
+		t.Errorf("request id = %s, want req-1", entry.RequestId)
+	}
+
+	if entry.Query != "page=2&token=***" {
+		t.Errorf("query = %s", entry.Query)
+	}
+
+	if entry.Headers["Authorization"] != maskedValue || entry.Headers["X-Tenant"] != "acme" {
+		t.Errorf("headers = %v", entry.Headers)
+	}
+}
+
+func TestAccessLog_Combined(t *testing.T) {
+	a, err := newAccessLog("test", model.ConfigMap{"access_log_format": "combined", "access_log_sample_rate": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	a.mWriter = buffer
+	handler := a.handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasPrefix(request.URL.Path, "/missing") {
+			http.NotFound(writer, request)
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+	request := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	request.Header.Set("User-Agent", "curl/7.0")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	line := strings.TrimSpace(buffer.String())
+	if strings.Contains(line, "/ok") {
+		t.Errorf("sampled out request logged: %s", line)
+	}
+
+	if !strings.Contains(line, `"GET /missing HTTP/1.1" 404 19 "-" "curl/7.0"`) {
+		t.Errorf("line = %s", line)
+	}
+
+	// the request line can not inject a log line
+	buffer.Reset()
+	request = httptest.NewRequest(http.MethodGet, "/missing", nil)
+	request.URL.Path = "/missing\n\"x"
+	request.URL.RawQuery = "a=\"b\""
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	line = strings.TrimSuffix(buffer.String(), "\n")
+	if strings.Contains(line, "\n") || !strings.Contains(line, `"GET /missing%0A%22x?a=\"b\" HTTP/1.1" 404`) {
+		t.Errorf("line = %s", line)
+	}
+}
+
+func TestAccessLog_Hijack(t *testing.T) {
+	a, err := newAccessLog("test", model.ConfigMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.mWriter = &bytes.Buffer{}
+
+	server := httptest.NewServer(a.handler(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		hijacker, ok := writer.(http.Hijacker)
+		if !ok {
+			t.Error("writer is not a http.Hijacker")
+			return
+		}
+
+		conn, buffer, err := hijacker.Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = buffer.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		_ = buffer.Flush()
+	})))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if data, _ := io.ReadAll(response.Body); string(data) != "ok" {
+		t.Errorf("body = %q", data)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	file, err := newRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "third\n" {
+		t.Errorf("current = %q", data)
+	}
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "second\n" {
+		t.Errorf("backup = %q", data)
+	}
+
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("backup 2 exists")
+	}
+}
+
+func TestNewAccessLog_Invalid(t *testing.T) {
+	if _, err := newAccessLog("test", model.ConfigMap{"access_log_format": "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	if _, err := newAccessLog("test", model.ConfigMap{"access_log_output": "syslog"}); err == nil {
+		t.Error("expected error for unknown output")
+	}
+}
@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var ErrBodyTooLarge = errors.New("request body too large")
+var ErrBodyClosed = errors.New("request body is closed")
+
+// bulkhead limits the number of requests served at the same time,
+// up to maxQueue requests wait for a free slot and the rest are shed
+type bulkhead struct {
+	slots        chan struct{}
+	waiting      int64
+	maxQueue     int64
+	queueTimeout time.Duration
+}
+
+func newBulkhead(maxConcurrency int, maxQueue int, queueTimeout time.Duration) *bulkhead {
+	return &bulkhead{
+		slots:        make(chan struct{}, maxConcurrency),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+func (b *bulkhead) acquire(ctx context.Context) bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&b.waiting, 1) > b.maxQueue {
+		atomic.AddInt64(&b.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt64(&b.waiting, -1)
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+// limitedReader fails with ErrBodyTooLarge once more than limit bytes are read,
+// zero limit disables the check, it fails with ErrBodyClosed once closed
+type limitedReader struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+	closed   int32
+}
+
+func newLimitedReader(reader io.Reader, limit int64) *limitedReader {
+	return &limitedReader{reader: reader, limit: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&l.closed) != 0 {
+		return 0, ErrBodyClosed
+	}
+
+	if l.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+
+	n, err := l.reader.Read(p)
+	read := atomic.AddInt64(&l.read, int64(n))
+
+	if l.limit > 0 && read > l.limit {
+		l.exceeded = true
+		return n - int(read-l.limit), ErrBodyTooLarge
+	}
+
+	return n, err
+}
+
+// close fails the next reads, the service may still read the body
+// after a timeout while the handler returns
+func (l *limitedReader) close() {
+	atomic.StoreInt32(&l.closed, 1)
+}
+
+// size is the number of bytes read so far
+func (l *limitedReader) size() int64 {
+	return atomic.LoadInt64(&l.read)
+}
@@ -0,0 +1,147 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestBulkhead(t *testing.T) {
+	b := newBulkhead(1, 1, 20*time.Millisecond)
+
+	if !b.acquire(context.Background()) {
+		t.Fatal("acquire() = false, want true")
+	}
+
+	// the queued request gets the slot once it is released
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		b.release()
+	}()
+
+	if !b.acquire(context.Background()) {
+		t.Fatal("queued acquire() = false, want true")
+	}
+
+	// queue timeout
+	if b.acquire(context.Background()) {
+		t.Fatal("acquire() = true, want false")
+	}
+
+	b = newBulkhead(1, 0, time.Second)
+	b.acquire(context.Background())
+
+	// no queue, shed immediately
+	if b.acquire(context.Background()) {
+		t.Fatal("acquire() = true, want false")
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	r := newLimitedReader(strings.NewReader("hello world"), 5)
+	data, err := ioutil.ReadAll(r)
+
+	if !errors.Is(err, ErrBodyTooLarge) || !r.exceeded {
+		t.Errorf("ReadAll() error = %v, want %v", err, ErrBodyTooLarge)
+	}
+
+	if len(data) > 5 {
+		t.Errorf("ReadAll() read %d bytes, want at most 5", len(data))
+	}
+
+	r = newLimitedReader(strings.NewReader("hello"), 5)
+	if data, err = ioutil.ReadAll(r); err != nil || string(data) != "hello" {
+		t.Errorf("ReadAll() = %s, %v", data, err)
+	}
+}
+
+type testStreamService struct {
+	iface.IService
+	started chan struct{}
+}
+
+func (s *testStreamService) ContractId() string {
+	return "test:stream"
+}
+
+func (s *testStreamService) ServeStream(_ context.Context, event *model.Event, body io.Reader) (*model.Event, error) {
+	s.started <- struct{}{}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain", data), nil
+}
+
+func TestHTTPServer_BulkheadTimeout(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testStreamService{started: make(chan struct{}, 2)}
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":          "POST",
+		"path":            "/upload",
+		"request_timeout": "20ms",
+		"max_concurrency": "1",
+	}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, writer := io.Pipe()
+	slow := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.mRouter.ServeHTTP(slow, httptest.NewRequest(http.MethodPost, "/upload", reader))
+		close(done)
+	}()
+
+	<-service.started
+	_, _ = writer.Write([]byte("part"))
+
+	// the handler returns while the service still reads the body
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler waits for the service")
+	}
+
+	if slow.Code != http.StatusRequestTimeout {
+		t.Errorf("slow status = %d, want %d", slow.Code, http.StatusRequestTimeout)
+	}
+
+	// the timed out service still holds the slot
+	recorder := httptest.NewRecorder()
+	h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("x")))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("concurrent status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+
+	// the next read of the service fails and the slot is released
+	_, _ = writer.Write([]byte("more"))
+	for i := 0; i < 50; i++ {
+		recorder = httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("x")))
+		if recorder.Code != http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "x" {
+		t.Errorf("released status = %d %q", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,506 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/capability/memkvstore"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/utility"
+)
+
+var ErrKVStoreNotFound = errors.New("kv store not found")
+var ErrCachePurgeNotSupported = errors.New("cache store does not support purge")
+var ErrCachePurgeTokenRequired = errors.New("cache purge token required")
+
+// cacheKeyPrefix starts every key, the path follows so that the keys can
+// be purged by a path prefix
+const cacheKeyPrefix = "httpcache:"
+
+const (
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+// cacheEntry is the stored service response, it is compressed per
+// request like a fresh response
+type cacheEntry struct {
+	StatusCode   int                 `json:"status_code"`
+	Header       map[string][]string `json:"header"`
+	Value        []byte              `json:"value"`
+	ETag         string              `json:"etag"`
+	LastModified string              `json:"last_modified"`
+	StoredAt     time.Time           `json:"stored_at"`
+}
+
+// responseCache caches the GET and HEAD responses of a route, it is
+// configured with the cache_ttl, cache_vary and
+// cache_stale_while_revalidate trigger values
+type responseCache struct {
+	contractId string
+	store      iface.IKVStore
+	ttl        time.Duration
+	stale      time.Duration
+	varyList   []string
+	host       bool
+
+	revalidating sync.Map
+	nowFunc      func() time.Time
+}
+
+func (h *HTTPServer) newResponseCache(triggerValues model.ConfigMap, host bool) (*responseCache, error) {
+	ttl := triggerValues.Duration("cache_ttl", 0)
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	store, err := h.cacheStore()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &responseCache{
+		contractId: h.ContractId(),
+		store:      store,
+		ttl:        ttl,
+		stale:      triggerValues.Duration("cache_stale_while_revalidate", 0),
+		host:       host,
+		nowFunc:    time.Now,
+	}
+
+	for _, v := range trimmedList(triggerValues, "cache_vary") {
+		c.varyList = append(c.varyList, textproto.CanonicalMIMEHeaderKey(v))
+	}
+
+	return c, nil
+}
+
+// cacheStore returns the cache_store capability
+func (h *HTTPServer) cacheStore() (iface.IKVStore, error) {
+	return h.kvStore(h.mValues.String("cache_store", ""))
+}
+
+// kvStore returns the store capability of the contract id, without one
+// the in memory store with cache_max_entries entries is shared
+func (h *HTTPServer) kvStore(contractId string) (iface.IKVStore, error) {
+	if len(contractId) != 0 {
+		var store iface.IKVStore
+		if h.mCapabilityRegistry != nil {
+			store, _ = h.mCapabilityRegistry.Capability(contractId).(iface.IKVStore)
+		}
+
+		if store == nil {
+			return nil, fmt.Errorf("%w: %s", ErrKVStoreNotFound, contractId)
+		}
+
+		return store, nil
+	}
+
+	h.mMemoryStoreMutex.Lock()
+	defer h.mMemoryStoreMutex.Unlock()
+
+	if h.mMemoryStore != nil {
+		return h.mMemoryStore, nil
+	}
+
+	store := &memkvstore.MemKVStore{}
+	if err := store.SetConfigMap(model.ConfigMap{"max_entries": h.mValues.String("cache_max_entries", "10000")}); err != nil {
+		return nil, err
+	}
+	if err := store.Setup(); err != nil {
+		return nil, err
+	}
+	h.mMemoryStore = store
+
+	return store, nil
+}
+
+// PurgeCache deletes the cached responses whose path starts with prefix
+func (h *HTTPServer) PurgeCache(ctx context.Context, prefix string) (int, error) {
+	store, err := h.cacheStore()
+	if err != nil {
+		return 0, err
+	}
+
+	deleter, ok := store.(iface.IKVStoreDeletePrefix)
+	if !ok {
+		return 0, ErrCachePurgeNotSupported
+	}
+
+	return deleter.DeletePrefix(ctx, cacheKeyPrefix+prefix)
+}
+
+// purgeHandler serves cache_purge_path, the prefix query value is purged
+// when the bearer token matches cache_purge_token
+func (h *HTTPServer) purgeHandler(token string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost && request.Method != http.MethodDelete {
+			h.s405m(request, writer, nil)
+			return
+		}
+
+		given := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			h.s401m(request, writer, nil)
+			return
+		}
+
+		count, err := h.PurgeCache(request.Context(), request.URL.Query().Get("prefix"))
+		if err != nil {
+			h.s500m(request, writer, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(fmt.Sprintf(`{"purged":%d}`, count)))
+	}
+}
+
+func (c *responseCache) cacheable(request *http.Request) bool {
+	return request.Method == http.MethodGet || request.Method == http.MethodHead
+}
+
+// credentialsHash is the hash of the Authorization and Cookie headers,
+// empty without them
+func credentialsHash(request *http.Request) string {
+	authorization := request.Header.Values("Authorization")
+	cookie := request.Header.Values("Cookie")
+	if len(authorization) == 0 && len(cookie) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(authorization, ",") + "\n" + strings.Join(cookie, "; ")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// key is built from the path, the sorted query, the method, the host of
+// the host routes, the credentials and the vary headers
+func (c *responseCache) key(request *http.Request) string {
+	var b strings.Builder
+	b.WriteString(cacheKeyPrefix)
+	b.WriteString(request.URL.EscapedPath())
+	b.WriteString("?")
+	b.WriteString(request.URL.Query().Encode())
+	b.WriteString("|method=")
+	b.WriteString(request.Method)
+
+	if c.host {
+		b.WriteString("|host=")
+		b.WriteString(requestHost(request.Host))
+	}
+
+	// a response to a request with credentials is never served to others
+	if credentials := credentialsHash(request); len(credentials) != 0 {
+		b.WriteString("|credentials=")
+		b.WriteString(credentials)
+	}
+
+	for _, v := range c.varyList {
+		b.WriteString("|")
+		b.WriteString(v)
+		b.WriteString("=")
+		b.WriteString(strings.Join(request.Header.Values(v), ","))
+	}
+
+	return b.String()
+}
+
+func (c *responseCache) load(ctx context.Context, key string) *cacheEntry {
+	var data []byte
+	if err := c.store.Get(ctx, key, &data); err != nil {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// shared is true when the response allows a shared cache to store it
+// for a request with credentials (RFC 9111 section 3.5)
+func shared(metadata *model.Metadata) bool {
+	for _, v := range metadata.HeaderValueList("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name := strings.ToLower(strings.TrimSpace(strings.SplitN(directive, "=", 2)[0]))
+			if name == "public" || name == "s-maxage" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requestOnlyHeaderMap belongs to a single request or connection and is
+// never stored with a response
+var requestOnlyHeaderMap = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Traceparent":         true,
+	"Tracestate":          true,
+	http.CanonicalHeaderKey(utility.RequestIdHeader): true,
+}
+
+// storedHeaders returns the headers set by the service, the request
+// headers echoed into the output metadata by GenerateOutputEvent are
+// left out so that a stored response never carries them to other clients
+func storedHeaders(input *model.Metadata, output *model.Metadata) map[string][]string {
+	header := make(map[string][]string)
+	for k, v := range output.GetHeaders() {
+		key := http.CanonicalHeaderKey(k)
+		if requestOnlyHeaderMap[key] {
+			continue
+		}
+
+		if _, found := output.GetHeaderValues()[k]; found {
+			continue
+		}
+
+		// the content type is always set from the type url of the output
+		if echoed, found := input.GetHeaders()[k]; found && echoed == v && key != "Content-Type" {
+			continue
+		}
+
+		header[k] = []string{v}
+	}
+
+	for k, v := range output.GetHeaderValues() {
+		if !requestOnlyHeaderMap[http.CanonicalHeaderKey(k)] {
+			header[k] = v.GetValues()
+		}
+	}
+
+	return header
+}
+
+// credentialed is true when the request carries credentials
+func credentialed(input *model.Metadata) bool {
+	return len(input.HeaderValueList("Authorization")) != 0 || len(input.HeaderValueList("Cookie")) != 0
+}
+
+// storable rejects the failed, private and cookie setting responses and
+// the responses to a request with credentials unless they are shared
+func storable(input *model.Metadata, metadata *model.Metadata) bool {
+	if metadata.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if credentialed(input) && !shared(metadata) {
+		return false
+	}
+
+	if len(metadata.HeaderValueList("Set-Cookie")) != 0 {
+		return false
+	}
+
+	for _, v := range metadata.HeaderValueList("Cache-Control") {
+		if strings.Contains(v, "no-store") || strings.Contains(v, "private") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// save stores the response of the input and sets its validators, the
+// service values are kept when present, nil is returned when the
+// response is not stored
+func (c *responseCache) save(ctx context.Context, key string, input *model.Metadata, metadata *model.Metadata, value []byte) *cacheEntry {
+	if !storable(input, metadata) {
+		return nil
+	}
+
+	now := c.nowFunc()
+
+	entry := &cacheEntry{
+		StatusCode:   int(metadata.StatusCode),
+		Value:        value,
+		ETag:         firstValue(metadata.HeaderValueList("ETag")),
+		LastModified: firstValue(metadata.HeaderValueList("Last-Modified")),
+		StoredAt:     now,
+	}
+
+	if len(entry.ETag) == 0 {
+		sum := sha256.Sum256(value)
+		entry.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+
+	if len(entry.LastModified) == 0 {
+		entry.LastModified = now.UTC().Format(http.TimeFormat)
+	}
+
+	if metadata.Headers == nil {
+		metadata.Headers = make(map[string]string)
+	}
+	metadata.Headers["ETag"] = entry.ETag
+	metadata.Headers["Last-Modified"] = entry.LastModified
+	entry.Header = storedHeaders(input, metadata)
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = c.store.Set(ctx, key, data, c.ttl+c.stale)
+	}
+
+	if err != nil {
+		logger.LC(ctx, c.contractId).Error("cache store failed", zap.String("key", key), zap.Error(err))
+	}
+
+	return entry
+}
+
+func (e *cacheEntry) metadata() *model.Metadata {
+	metadata := &model.Metadata{StatusCode: uint32(e.StatusCode)}
+	for k, v := range e.Header {
+		metadata.SetHeaderValueList(k, v)
+	}
+
+	return metadata
+}
+
+// notModified evaluates If-None-Match first and If-Modified-Since only
+// without it
+func notModified(request *http.Request, etag string, lastModified string) bool {
+	if inm := request.Header.Get("If-None-Match"); len(inm) != 0 {
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+			if v == "*" || v == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := request.Header.Get("If-Modified-Since"); len(ims) != 0 && len(lastModified) != 0 {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		modified, err := http.ParseTime(lastModified)
+		return err == nil && !modified.After(since)
+	}
+
+	return false
+}
+
+func (c *responseCache) writeCacheHeaders(writer http.ResponseWriter, status string, age time.Duration) {
+	writer.Header().Set("X-Cache", status)
+	writer.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+
+	if len(c.varyList) != 0 {
+		writer.Header().Set("Vary", strings.Join(c.varyList, ", "))
+	}
+}
+
+// writeNotModified writes the validators without the body
+func writeNotModified(writer http.ResponseWriter, metadata *model.Metadata) {
+	for _, k := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires"} {
+		if v := metadata.HeaderValueList(k); len(v) != 0 {
+			writer.Header()[k] = v
+		}
+	}
+
+	writer.WriteHeader(http.StatusNotModified)
+}
+
+// serveCached writes a fresh or a stale entry, a stale entry is
+// revalidated in the background, false is returned on a miss and for
+// the requests asking for a fresh response
+func (h *HTTPServer) serveCached(sr *serviceRoute, key string, inputEvent *model.Event, writer http.ResponseWriter, request *http.Request) bool {
+	if strings.Contains(request.Header.Get("Cache-Control"), "no-cache") {
+		return false
+	}
+
+	entry := sr.cache.load(request.Context(), key)
+	if entry == nil {
+		return false
+	}
+
+	age := sr.cache.nowFunc().Sub(entry.StoredAt)
+	status := cacheHit
+	if age >= sr.cache.ttl {
+		if age >= sr.cache.ttl+sr.cache.stale {
+			return false
+		}
+
+		status = cacheStale
+		h.revalidate(sr, key, inputEvent)
+	}
+
+	metadata := entry.metadata()
+	sr.cache.writeCacheHeaders(writer, status, age)
+	h.mResponseStatus.With(routeLabel(request), strconv.Itoa(entry.StatusCode)).Inc()
+
+	if notModified(request, entry.ETag, entry.LastModified) {
+		writeNotModified(writer, metadata)
+		return true
+	}
+
+	h.writeResponse(sr, writer, request, metadata, entry.Value)
+	return true
+}
+
+// revalidate serves the event again in the background, at most once
+// per key at a time
+func (h *HTTPServer) revalidate(sr *serviceRoute, key string, inputEvent *model.Event) {
+	if _, loaded := sr.cache.revalidating.LoadOrStore(key, true); loaded {
+		return
+	}
+
+	event := &model.Event{
+		Metadata: model.CloneMetadata(inputEvent.Metadata),
+		TypeUrl:  inputEvent.TypeUrl,
+		Value:    inputEvent.Value,
+	}
+
+	go func() {
+		defer sr.cache.revalidating.Delete(key)
+
+		ctx := logger.WithRequestId(context.Background(), event.Metadata.GetUniqueId())
+		ctx, cancel := context.WithTimeout(ctx, sr.requestTimeout)
+		defer cancel()
+
+		output, err := serveEvent(ctx, sr.service, event, nil)
+		if err != nil || output == nil || output.Metadata == nil {
+			logger.LC(ctx, h.ContractId()).Warn("cache revalidation failed", zap.String("key", key), zap.Error(err))
+			return
+		}
+
+		sr.cache.save(ctx, key, event.Metadata, output.Metadata, output.Value)
+	}()
+}
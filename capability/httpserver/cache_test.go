@@ -0,0 +1,306 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+type testCountingService struct {
+	iface.IService
+	count int32
+}
+
+func (s *testCountingService) ContractId() string {
+	return "test:counting"
+}
+
+func (s *testCountingService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	n := atomic.AddInt32(&s.count, 1)
+	lang := event.Metadata.HeaderValueList("Accept-Language")
+	value := strconv.Itoa(int(n))
+	if len(lang) != 0 {
+		value += "|" + lang[0]
+	}
+
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain", []byte(value)), nil
+}
+
+func TestHTTPServer_ResponseCache(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"cache_purge_path": "/_cache/purge", "cache_purge_token": "secret"})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testCountingService{}
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":                       "GET,POST",
+		"path":                         "/items/{id}",
+		"cache_ttl":                    "1h",
+		"cache_vary":                   "accept-language",
+		"cache_stale_while_revalidate": "1h",
+	}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target string, header map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	r := do(http.MethodGet, "/items/1?b=2&a=1", nil)
+	if r.Body.String() != "1" || r.Header().Get("X-Cache") != cacheMiss || len(r.Header().Get("Etag")) == 0 {
+		t.Fatalf("miss = %q %v", r.Body.String(), r.Header())
+	}
+	etag := r.Header().Get("Etag")
+
+	// the query order does not change the key
+	r = do(http.MethodGet, "/items/1?a=1&b=2", nil)
+	if r.Body.String() != "1" || r.Header().Get("X-Cache") != cacheHit || r.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("hit = %q %v", r.Body.String(), r.Header())
+	}
+
+	if r = do(http.MethodGet, "/items/1?a=1&b=2", map[string]string{"If-None-Match": etag}); r.Code != http.StatusNotModified || r.Body.Len() != 0 {
+		t.Errorf("revalidation = %d %q", r.Code, r.Body.String())
+	}
+
+	if r = do(http.MethodGet, "/items/1?a=1&b=2", map[string]string{"Accept-Language": "bn"}); r.Body.String() != "2|bn" {
+		t.Errorf("vary = %q", r.Body.String())
+	}
+
+	if r = do(http.MethodGet, "/items/1?a=1&b=2", map[string]string{"Cache-Control": "no-cache"}); r.Body.String() != "3" {
+		t.Errorf("no-cache = %q", r.Body.String())
+	}
+
+	if r = do(http.MethodPost, "/items/1?a=1&b=2", nil); r.Body.String() != "4" || len(r.Header().Get("X-Cache")) != 0 {
+		t.Errorf("post = %q %v", r.Body.String(), r.Header())
+	}
+
+	if r = do(http.MethodPost, "/_cache/purge?prefix=/items/", nil); r.Code != http.StatusUnauthorized {
+		t.Errorf("purge without token = %d", r.Code)
+	}
+
+	r = do(http.MethodPost, "/_cache/purge?prefix=/items/", map[string]string{"Authorization": "Bearer secret"})
+	if r.Code != http.StatusOK || r.Body.String() != `{"purged":2}` {
+		t.Errorf("purge = %d %q", r.Code, r.Body.String())
+	}
+
+	if r = do(http.MethodGet, "/items/1?a=1&b=2", nil); r.Body.String() != "5" {
+		t.Errorf("after purge = %q", r.Body.String())
+	}
+}
+
+func TestHTTPServer_ResponseCacheStale(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.Setup()
+
+	service := &testCountingService{}
+	_ = h.AddService(nil, "", model.ConfigMap{
+		"method":                       "GET",
+		"path":                         "/stale",
+		"cache_ttl":                    "20ms",
+		"cache_stale_while_revalidate": "1h",
+	}, service)
+
+	get := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stale", nil))
+		return recorder
+	}
+
+	get()
+	time.Sleep(30 * time.Millisecond)
+
+	if r := get(); r.Body.String() != "1" || r.Header().Get("X-Cache") != cacheStale {
+		t.Errorf("stale = %q %v", r.Body.String(), r.Header())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&service.count) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the revalidated entry is stored before the count is observed
+	for time.Now().Before(deadline) {
+		if r := get(); r.Body.String() == "2" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("stale entry was not revalidated")
+}
+
+func TestHTTPServer_CacheConfig(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"cache_purge_path": "/_cache/purge"})
+	if err := h.Setup(); !errors.Is(err, ErrCachePurgeTokenRequired) {
+		t.Errorf("error = %v, want %v", err, ErrCachePurgeTokenRequired)
+	}
+
+	h = &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"cache_store": "abesh:missing"})
+	_ = h.Setup()
+	err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/", "cache_ttl": "1m"}, &testCountingService{})
+	if !errors.Is(err, ErrKVStoreNotFound) {
+		t.Errorf("error = %v, want %v", err, ErrKVStoreNotFound)
+	}
+}
+
+type testCacheControlService struct {
+	iface.IService
+	count int32
+}
+
+func (s *testCacheControlService) ContractId() string {
+	return "test:cache_control"
+}
+
+func (s *testCacheControlService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	n := atomic.AddInt32(&s.count, 1)
+	output := model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain", []byte(strconv.Itoa(int(n))))
+	if event.Metadata.Path == "/public" {
+		output.Metadata.SetHeaderValueList("Cache-Control", []string{"max-age=60, Public"})
+	}
+
+	return output, nil
+}
+
+func TestHTTPServer_ResponseCacheCredentials(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testCacheControlService{}
+	for _, path := range []string{"/private", "/public"} {
+		if err := h.AddService(nil, "", model.ConfigMap{"method": "GET,HEAD", "path": path, "cache_ttl": "1h"}, service); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(method, target string, header map[string]string) string {
+		request := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder.Body.String() + "|" + recorder.Header().Get("X-Cache")
+	}
+
+	// a head miss does not answer the get requests
+	if r := do(http.MethodHead, "/private", nil); !strings.HasSuffix(r, "|MISS") {
+		t.Errorf("head = %q", r)
+	}
+	if r := do(http.MethodGet, "/private", nil); r != "2|MISS" {
+		t.Errorf("get after head = %q", r)
+	}
+
+	alice := map[string]string{"Authorization": "Bearer alice"}
+	bob := map[string]string{"Cookie": "session=bob"}
+
+	// the anonymous entry is not served to a request with credentials and
+	// the private response is not stored
+	for i, want := range []string{"3|", "4|"} {
+		if r := do(http.MethodGet, "/private", alice); r != want {
+			t.Errorf("private %d = %q, want %q", i, r, want)
+		}
+	}
+	if r := do(http.MethodGet, "/private", bob); r != "5|" {
+		t.Errorf("private cookie = %q", r)
+	}
+
+	// a public response is stored per credentials
+	if r := do(http.MethodGet, "/public", alice); r != "6|MISS" {
+		t.Errorf("public miss = %q", r)
+	}
+	if r := do(http.MethodGet, "/public", alice); r != "6|HIT" {
+		t.Errorf("public hit = %q", r)
+	}
+	if r := do(http.MethodGet, "/public", bob); r != "7|MISS" {
+		t.Errorf("public other credentials = %q", r)
+	}
+}
+
+func TestHTTPServer_ResponseCacheHeaders(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"security_frame_options": "DENY"})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/public", "cache_ttl": "1h"}, &testCacheControlService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(header map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/public", nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := do(map[string]string{
+		"Authorization":               "Bearer alice",
+		"Cookie":                      "session=alice",
+		"X-Request-ID":                "request-alice",
+		"Access-Control-Allow-Origin": "https://evil.com",
+		"X-Frame-Options":             "ALLOW",
+	})
+	if first.Header().Get("X-Cache") != cacheMiss {
+		t.Fatalf("first = %v", first.Header())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/public", nil)
+	request.Header.Set("Authorization", "Bearer alice")
+	request.Header.Set("Cookie", "session=alice")
+	entry := (&responseCache{store: h.mMemoryStore}).load(context.Background(), (&responseCache{}).key(request))
+	if entry == nil {
+		t.Fatal("entry not stored")
+	}
+	for _, k := range []string{"Authorization", "Cookie", "X-Request-Id", "Access-Control-Allow-Origin", "X-Frame-Options"} {
+		if v := entry.metadata().HeaderValueList(k); len(v) != 0 {
+			t.Errorf("stored %s = %v", k, v)
+		}
+	}
+
+	// the same credentials reach the same entry, the stored entry holds
+	// the service headers only
+	r := do(map[string]string{"Authorization": "Bearer alice", "Cookie": "session=alice", "X-Request-ID": "request-bob"})
+	if r.Header().Get("X-Cache") != cacheHit || r.Body.String() != first.Body.String() {
+		t.Fatalf("hit = %q %v", r.Body.String(), r.Header())
+	}
+
+	for k, want := range map[string]string{
+		"X-Request-ID":                "request-bob",
+		"Authorization":               "",
+		"Cookie":                      "",
+		"Access-Control-Allow-Origin": "",
+		"X-Frame-Options":             "DENY",
+		"Cache-Control":               "max-age=60, Public",
+	} {
+		if v := r.Header().Values(k); (len(want) == 0 && len(v) != 0) || (len(want) != 0 && (len(v) != 1 || v[0] != want)) {
+			t.Errorf("%s = %v, want %q", k, v, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingBrotli  = "br"
+)
+
+// compressionPolicy is built from the compression_* values
+type compressionPolicy struct {
+	encodingList    []string
+	minSize         int
+	level           int
+	contentTypeList []string
+}
+
+func newCompressionPolicy(values model.ConfigMap) *compressionPolicy {
+	if !values.Bool("compression_enabled", false) {
+		return nil
+	}
+
+	c := &compressionPolicy{
+		encodingList: trimmedList(values, "compression_encodings"),
+		minSize:      values.Int("compression_min_size", 1024),
+		level:        values.Int("compression_level", -1),
+		// an entry ending with / is a prefix (ex: text/)
+		contentTypeList: trimmedList(values, "compression_content_types"),
+	}
+
+	if len(c.encodingList) == 0 {
+		c.encodingList = []string{encodingBrotli, encodingGzip, encodingDeflate}
+	}
+
+	if len(c.contentTypeList) == 0 {
+		c.contentTypeList = []string{"text/", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+	}
+
+	return c
+}
+
+func (c *compressionPolicy) isContentTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range c.contentTypeList {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+
+		if t == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate picks the encoding from Accept-Encoding by quality,
+// server preference breaks the ties, empty when nothing is acceptable
+func (c *compressionPolicy) negotiate(acceptEncoding string) string {
+	qualityMap := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+
+		quality := 1.0
+		if k, v, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(k) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				quality = q
+			}
+		}
+
+		qualityMap[name] = quality
+	}
+
+	candidateList := make([]string, 0, len(c.encodingList))
+	for _, e := range c.encodingList {
+		q, found := qualityMap[e]
+		if !found {
+			q, found = qualityMap["*"]
+		}
+
+		if found && q > 0 {
+			qualityMap[e] = q
+			candidateList = append(candidateList, e)
+		}
+	}
+
+	sort.SliceStable(candidateList, func(i, j int) bool {
+		return qualityMap[candidateList[i]] > qualityMap[candidateList[j]]
+	})
+
+	if len(candidateList) == 0 {
+		return ""
+	}
+
+	return candidateList[0]
+}
+
+func (c *compressionPolicy) compress(encoding string, data []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	var writer io.WriteCloser
+	var err error
+
+	switch encoding {
+	case encodingGzip:
+		writer, err = gzip.NewWriterLevel(buffer, c.level)
+	case encodingDeflate:
+		writer, err = zlib.NewWriterLevel(buffer, c.level)
+	case encodingBrotli:
+		level := c.level
+		if level < 0 {
+			level = brotli.DefaultCompression
+		}
+		writer = brotli.NewWriterLevel(buffer, level)
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// apply compresses the response body when the request accepts it,
+// returns the body to be written
+func (c *compressionPolicy) apply(writer http.ResponseWriter, request *http.Request, data []byte) ([]byte, error) {
+	header := writer.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	if len(data) < c.minSize || len(header.Get("Content-Encoding")) != 0 {
+		return data, nil
+	}
+
+	if !c.isContentTypeAllowed(header.Get("Content-Type")) {
+		return data, nil
+	}
+
+	encoding := c.negotiate(request.Header.Get("Accept-Encoding"))
+	if len(encoding) == 0 {
+		return data, nil
+	}
+
+	compressed, err := c.compress(encoding, data)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Set("Content-Encoding", encoding)
+	header.Del("Content-Length")
+
+	return compressed, nil
+}
+
+// decompressReader decodes the request body according to Content-Encoding,
+// identity and empty encoding return the body as is
+func decompressReader(contentEncoding string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return body, nil
+	case encodingGzip, "x-gzip":
+		return gzip.NewReader(body)
+	case encodingDeflate:
+		return zlib.NewReader(body)
+	case encodingBrotli:
+		return brotli.NewReader(body), nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
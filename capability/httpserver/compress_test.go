@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestCompressionPolicy_Negotiate(t *testing.T) {
+	c := newCompressionPolicy(model.ConfigMap{"compression_enabled": "true"})
+
+	tests := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"gzip, deflate, br":       "br",
+		"gzip;q=1.0, br;q=0.5":    "gzip",
+		"br;q=0, *":               "gzip",
+		"identity":                "",
+		"deflate;q=0.8, gzip;q=0": "deflate",
+		"compress, x-unknown;q=1": "",
+	}
+
+	for acceptEncoding, want := range tests {
+		if got := c.negotiate(acceptEncoding); got != want {
+			t.Errorf("negotiate(%s) = %s, want %s", acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestCompressionPolicy_Apply(t *testing.T) {
+	c := newCompressionPolicy(model.ConfigMap{"compression_enabled": "true", "compression_min_size": "10"})
+	data := []byte(strings.Repeat(`{"key":"value"}`, 10))
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	output, err := c.apply(recorder, request, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %s, want gzip", recorder.Header().Get("Content-Encoding"))
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded, _ := ioutil.ReadAll(reader); !bytes.Equal(decoded, data) {
+		t.Errorf("decoded body = %s, want %s", decoded, data)
+	}
+
+	// content type not in the allowlist
+	recorder = httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "image/png")
+	if output, _ = c.apply(recorder, request, data); !bytes.Equal(output, data) {
+		t.Error("image/png response is compressed")
+	}
+}
+
+func TestDecompressReader(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	_, _ = writer.Write(bytes.Repeat([]byte("a"), 1000))
+	_ = writer.Close()
+
+	reader, err := decompressReader("gzip", buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited := newLimitedReader(reader, 100)
+	if _, err = ioutil.ReadAll(limited); err != ErrBodyTooLarge {
+		t.Errorf("ReadAll() error = %v, want %v", err, ErrBodyTooLarge)
+	}
+
+	if _, err = decompressReader("compress", buffer); err != ErrUnsupportedContentEncoding {
+		t.Errorf("decompressReader() error = %v, want %v", err, ErrUnsupportedContentEncoding)
+	}
+}
@@ -0,0 +1,233 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrCORSCredentialsWithAnyOrigin = errors.New("cors_allow_credentials can not be used with the * origin")
+
+// overlay returns the base values with the keys of the prefix list
+// replaced by the override values, used for per trigger policies
+func overlay(base model.ConfigMap, override model.ConfigMap, prefixList ...string) model.ConfigMap {
+	output := make(model.ConfigMap)
+
+	for _, values := range []model.ConfigMap{base, override} {
+		for k, v := range values {
+			for _, prefix := range prefixList {
+				if strings.HasPrefix(k, prefix) {
+					output[k] = v
+					break
+				}
+			}
+		}
+	}
+
+	return output
+}
+
+func trimmedList(values model.ConfigMap, key string) []string {
+	var output []string
+	for _, v := range values.StringList(key, ",", nil) {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			output = append(output, v)
+		}
+	}
+
+	return output
+}
+
+// corsPolicy is built from the cors_* values, allowed origins can be
+// exact, * for any origin, contain * as wildcard (https://*.example.com)
+// or be a regular expression starting with ^
+type corsPolicy struct {
+	anyOrigin        bool
+	originList       []string
+	originRegexList  []*regexp.Regexp
+	methods          string
+	headers          string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORSPolicy(values model.ConfigMap) (*corsPolicy, error) {
+	origins := trimmedList(values, "cors_allowed_origins")
+	if len(origins) == 0 {
+		return nil, nil
+	}
+
+	c := &corsPolicy{
+		methods:          strings.Join(trimmedList(values, "cors_allowed_methods"), ", "),
+		headers:          strings.Join(trimmedList(values, "cors_allowed_headers"), ", "),
+		exposedHeaders:   strings.Join(trimmedList(values, "cors_exposed_headers"), ", "),
+		allowCredentials: values.Bool("cors_allow_credentials", false),
+	}
+
+	if maxAge := values.Duration("cors_max_age", 0); maxAge > 0 {
+		c.maxAge = fmt.Sprintf("%d", int64(maxAge.Seconds()))
+	}
+
+	for _, origin := range origins {
+		// any site could make credentialed requests otherwise
+		if origin == "*" && c.allowCredentials {
+			return nil, ErrCORSCredentialsWithAnyOrigin
+		}
+
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.HasPrefix(origin, "^"):
+			regex, err := regexp.Compile(origin)
+			if err != nil {
+				return nil, err
+			}
+			c.originRegexList = append(c.originRegexList, regex)
+		case strings.Contains(origin, "*"):
+			expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(origin), `\*`, `[^/]*`) + "$"
+			c.originRegexList = append(c.originRegexList, regexp.MustCompile(expression))
+		default:
+			c.originList = append(c.originList, strings.ToLower(origin))
+		}
+	}
+
+	return c, nil
+}
+
+func (c *corsPolicy) isOriginAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	for _, o := range c.originList {
+		if o == origin {
+			return true
+		}
+	}
+
+	for _, r := range c.originRegexList {
+		if r.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowOrigin writes the origin headers, false when the origin is not allowed
+func (c *corsPolicy) allowOrigin(writer http.ResponseWriter, request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	writer.Header().Add("Vary", "Origin")
+
+	if len(origin) == 0 || !c.isOriginAllowed(origin) {
+		return false
+	}
+
+	if c.anyOrigin {
+		writer.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.allowCredentials {
+		writer.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+// writeHeaders adds the headers of a simple or actual request
+func (c *corsPolicy) writeHeaders(writer http.ResponseWriter, request *http.Request) {
+	if c.allowOrigin(writer, request) && len(c.exposedHeaders) != 0 {
+		writer.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+// preflight answers the OPTIONS preflight request, methodList is used
+// when allowed methods are not configured
+func (c *corsPolicy) preflight(writer http.ResponseWriter, request *http.Request, methodList []string) {
+	writer.Header().Add("Vary", "Access-Control-Request-Method")
+	writer.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !c.allowOrigin(writer, request) {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	methods := c.methods
+	if len(methods) == 0 {
+		methods = strings.Join(methodList, ", ")
+	}
+	writer.Header().Set("Access-Control-Allow-Methods", methods)
+
+	headers := c.headers
+	if len(headers) == 0 {
+		// reflect the requested headers
+		headers = request.Header.Get("Access-Control-Request-Headers")
+	}
+	if len(headers) != 0 {
+		writer.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+
+	if len(c.maxAge) != 0 {
+		writer.Header().Set("Access-Control-Max-Age", c.maxAge)
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func isPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		len(request.Header.Get("Origin")) != 0 &&
+		len(request.Header.Get("Access-Control-Request-Method")) != 0
+}
+
+// securityPolicy is built from the security_* values,
+// empty values are not written
+type securityPolicy struct {
+	hsts               string
+	csp                string
+	frameOptions       string
+	referrerPolicy     string
+	contentTypeOptions string
+}
+
+func newSecurityPolicy(values model.ConfigMap) *securityPolicy {
+	s := &securityPolicy{
+		hsts:               values.String("security_hsts", ""),
+		csp:                values.String("security_csp", ""),
+		frameOptions:       values.String("security_frame_options", ""),
+		referrerPolicy:     values.String("security_referrer_policy", ""),
+		contentTypeOptions: values.String("security_content_type_options", ""),
+	}
+
+	if *s == (securityPolicy{}) {
+		return nil
+	}
+
+	return s
+}
+
+func (s *securityPolicy) writeHeaders(writer http.ResponseWriter, request *http.Request) {
+	set := func(key, value string) {
+		if len(value) != 0 {
+			writer.Header().Set(key, value)
+		}
+	}
+
+	// HSTS is ignored by the browsers over plain http
+	if request.TLS != nil {
+		set("Strict-Transport-Security", s.hsts)
+	}
+
+	set("Content-Security-Policy", s.csp)
+	set("X-Frame-Options", s.frameOptions)
+	set("Referrer-Policy", s.referrerPolicy)
+	set("X-Content-Type-Options", s.contentTypeOptions)
+}
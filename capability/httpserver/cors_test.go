@@ -0,0 +1,138 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestCORSPolicy_IsOriginAllowed(t *testing.T) {
+	c, err := newCORSPolicy(model.ConfigMap{
+		"cors_allowed_origins": "https://app.example.com, https://*.example.org, ^https://[a-z]+\\.example\\.net$",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"https://app.example.com":   true,
+		"https://APP.example.com":   true,
+		"https://a.example.org":     true,
+		"https://abc.example.net":   true,
+		"https://1.example.net":     false,
+		"https://evil.com":          false,
+		"https://example.org":       false,
+		"http://app.example.com":    false,
+		"https://a.example.org/x/y": false,
+	}
+
+	for origin, want := range tests {
+		if got := c.isOriginAllowed(origin); got != want {
+			t.Errorf("isOriginAllowed(%s) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestRouter_Preflight(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"cors_allowed_origins": "https://app.example.com",
+		"cors_max_age":         "10m",
+	})
+	_ = h.Setup()
+
+	sr, err := h.newServiceRoute(nil, "", model.ConfigMap{"method": "GET,POST", "path": "/items"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.mRouter.addWithPreflight(sr.methodList, sr.path, nil, sr.preflightHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	request.Header.Set("Access-Control-Request-Method", "POST")
+	request.Header.Set("Access-Control-Request-Headers", "content-type")
+
+	recorder := httptest.NewRecorder()
+	h.mRouter.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "content-type",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if recorder.Header().Get(k) != v {
+			t.Errorf("%s = %s, want %s", k, recorder.Header().Get(k), v)
+		}
+	}
+
+	// trigger values override the global policy
+	sr, err = h.newServiceRoute(nil, "", model.ConfigMap{"method": "GET", "path": "/other", "cors_allowed_origins": "https://other.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sr.cors.isOriginAllowed("https://app.example.com") {
+		t.Error("per trigger cors_allowed_origins is not applied")
+	}
+}
+
+func TestHTTPServer_WriteHeaders(t *testing.T) {
+	h := &HTTPServer{}
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("X-Frame-Options", "DENY")
+	recorder.Header().Set("Vary", "Origin")
+
+	metadata := &model.Metadata{Headers: map[string]string{
+		"x-frame-options":             "SAMEORIGIN",
+		"Access-Control-Allow-Origin": "https://evil.com",
+		"set-cookie":                  "a=1",
+		"Set-Cookie":                  "b=2",
+	}}
+	metadata.SetHeaderValueList("Vary", []string{"Accept", "Accept-Language"})
+	h.writeHeaders(recorder, metadata)
+
+	// the policy headers of the server are kept, every service value is
+	// written otherwise
+	if v := recorder.Header().Values("X-Frame-Options"); len(v) != 1 || v[0] != "DENY" {
+		t.Errorf("X-Frame-Options = %v", v)
+	}
+
+	recorder = httptest.NewRecorder()
+	recorder.Header().Set("Access-Control-Allow-Origin", "https://app.example.com")
+	recorder.Header().Set("Vary", "Origin")
+	h.writeHeaders(recorder, metadata)
+
+	if v := recorder.Header().Values("Access-Control-Allow-Origin"); len(v) != 1 || v[0] != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %v", v)
+	}
+
+	if v := recorder.Header().Values("Set-Cookie"); len(v) != 2 {
+		t.Errorf("Set-Cookie = %v", v)
+	}
+
+	if v := recorder.Header().Values("Vary"); len(v) != 3 {
+		t.Errorf("Vary = %v", v)
+	}
+}
+
+func TestCORSPolicy_CredentialsWithAnyOrigin(t *testing.T) {
+	_, err := newCORSPolicy(model.ConfigMap{"cors_allowed_origins": "https://a.com, *", "cors_allow_credentials": "true"})
+	if !errors.Is(err, ErrCORSCredentialsWithAnyOrigin) {
+		t.Errorf("error = %v, want %v", err, ErrCORSCredentialsWithAnyOrigin)
+	}
+
+	if _, err = newCORSPolicy(model.ConfigMap{"cors_allowed_origins": "*"}); err != nil {
+		t.Errorf("any origin without credentials = %v", err)
+	}
+}
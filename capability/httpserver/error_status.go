@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/utility"
+)
+
+// defaultErrorStatusMap maps the well known error prefixes to the HTTP status
+var defaultErrorStatusMap = map[string]int{
+	abeshErrors.ErrBadRequest:         http.StatusBadRequest,
+	abeshErrors.ErrBadResponse:        http.StatusBadGateway,
+	abeshErrors.ErrForbidden:          http.StatusForbidden,
+	abeshErrors.ErrInternalService:    http.StatusInternalServerError,
+	abeshErrors.ErrNotFound:           http.StatusNotFound,
+	abeshErrors.ErrPreconditionFailed: http.StatusPreconditionFailed,
+	abeshErrors.ErrTimeout:            http.StatusRequestTimeout,
+	abeshErrors.ErrUnauthorized:       http.StatusUnauthorized,
+	abeshErrors.ErrUnknown:            http.StatusInternalServerError,
+	abeshErrors.ErrRateLimited:        http.StatusTooManyRequests,
+}
+
+// parseErrorStatusMap parses the error_status_map value
+// (ex: not_found=410;conflict=409) over the default mapping
+func parseErrorStatusMap(values model.ConfigMap) (map[string]int, error) {
+	statusMap := make(map[string]int, len(defaultErrorStatusMap))
+	for k, v := range defaultErrorStatusMap {
+		statusMap[k] = v
+	}
+
+	for prefix, value := range values.StringMap("error_status_map", nil) {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status %s for the error prefix %s", value, prefix)
+		}
+
+		statusMap[prefix] = status
+	}
+
+	return statusMap, nil
+}
+
+// errorStatus returns the status of the longest matching prefix,
+// prefix parts are separated by dot (ex: not_found.user)
+func errorStatus(statusMap map[string]int, prefix string) int {
+	for p := prefix; len(p) != 0; {
+		if status, found := statusMap[p]; found {
+			return status
+		}
+
+		index := strings.LastIndex(p, ".")
+		if index < 0 {
+			break
+		}
+		p = p[:index]
+	}
+
+	return http.StatusInternalServerError
+}
+
+// errorResponse renders the structured error returned by a service,
+// false when the error is not an iface.IError2
+func (sr *serviceRoute) errorResponse(err error, metadata *model.Metadata) (int, []byte, bool) {
+	var iError iface.IError2
+	if !errors.As(err, &iError) {
+		return 0, nil, false
+	}
+
+	lang := utility.GetLanguage(metadata.GetHeaders())
+
+	r := &model.HTTPResponseModel{
+		Code:    iface.StatusCode2(iError),
+		Message: utility.GetError2Message(iError, lang),
+		Lang:    lang,
+		Data:    make(map[string]interface{}),
+	}
+
+	if sr.debug {
+		data := map[string]interface{}{"error": err.Error()}
+
+		var abeshError *abeshErrors.Error
+		if errors.As(err, &abeshError) {
+			data["stack"] = abeshError.StackString()
+			data["params"] = abeshError.GetParams()
+		}
+
+		r.Data = data
+	}
+
+	var validationErr *validationError
+	if errors.As(err, &validationErr) {
+		r.Data = validationErr.violationList
+	}
+
+	data, errMarshal := json.Marshal(r)
+	if errMarshal != nil {
+		return 0, nil, false
+	}
+
+	return errorStatus(sr.errorStatusMap, iError.GetPrefix()), data, true
+}
@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/i18n"
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestErrorStatus(t *testing.T) {
+	statusMap, err := parseErrorStatusMap(model.ConfigMap{"error_status_map": "not_found.user=410; conflict=409"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{
+		"not_found":         http.StatusNotFound,
+		"not_found.user":    http.StatusGone,
+		"not_found.user.id": http.StatusGone,
+		"bad_request.body":  http.StatusBadRequest,
+		"rate_limited":      http.StatusTooManyRequests,
+		"conflict":          http.StatusConflict,
+		"something_else":    http.StatusInternalServerError,
+	}
+
+	for prefix, want := range tests {
+		if got := errorStatus(statusMap, prefix); got != want {
+			t.Errorf("errorStatus(%s) = %d, want %d", prefix, got, want)
+		}
+	}
+
+	if _, err = parseErrorStatusMap(model.ConfigMap{"error_status_map": "conflict=abc"}); err == nil {
+		t.Error("parseErrorStatusMap() error = nil")
+	}
+}
+
+func TestServiceRoute_ErrorResponse(t *testing.T) {
+	statusMap, _ := parseErrorStatusMap(nil)
+	sr := &serviceRoute{errorStatusMap: statusMap}
+
+	metadata := &model.Metadata{Headers: map[string]string{"Accept-Language": "bn"}}
+	serviceError := abeshErrors.NotFound("user", "user not found", map[string]string{"bn": "ব্যবহারকারী পাওয়া যায়নি"})
+
+	statusCode, data, ok := sr.errorResponse(fmt.Errorf("wrapped: %w", serviceError), metadata)
+	if !ok || statusCode != http.StatusNotFound {
+		t.Fatalf("errorResponse() = %d, %v", statusCode, ok)
+	}
+
+	r := &model.HTTPResponseModel{}
+	_ = json.Unmarshal(data, r)
+
+	if r.Code != "not_found.user_404" || r.Message != "ব্যবহারকারী পাওয়া যায়নি" || r.Lang != "bn" {
+		t.Errorf("errorResponse() = %+v", r)
+	}
+
+	if _, hasStack := r.Data.(map[string]interface{})["stack"]; hasStack {
+		t.Error("stack is included without debug")
+	}
+
+	if _, _, ok = sr.errorResponse(fmt.Errorf("plain"), metadata); ok {
+		t.Error("plain error is treated as structured error")
+	}
+
+	catalog, _ := i18n.New("en")
+	_ = catalog.Add("en", map[string]string{"not_found.item": "Item not found"})
+	_ = catalog.Add("bn", map[string]string{"not_found.item": "আইটেম পাওয়া যায়নি"})
+	i18n.SetCatalog(catalog)
+	defer i18n.SetCatalog(nil)
+
+	// the params of the base language, then the catalog and its default
+	for _, tc := range []struct {
+		lang, want string
+		err        error
+	}{
+		{"bn-BD", "ব্যবহারকারী পাওয়া যায়নি", serviceError},
+		{"bn", "আইটেম পাওয়া যায়নি", abeshErrors.NotFound("item", "item not found", nil)},
+		{"fr", "Item not found", abeshErrors.NotFound("item", "", nil)},
+	} {
+		_, data, _ = sr.errorResponse(tc.err, &model.Metadata{Headers: map[string]string{"Accept-Language": tc.lang}})
+		_ = json.Unmarshal(data, r)
+		if r.Message != tc.want {
+			t.Errorf("%s message = %q, want %q", tc.lang, r.Message, tc.want)
+		}
+	}
+}
+
+func TestHTTPServer_GetMessage(t *testing.T) {
+	catalog, _ := i18n.New("en")
+	_ = catalog.Add("en", map[string]string{"s404m": "catalog not found", "s405m": "catalog not allowed"})
+	_ = catalog.Add("bn", map[string]string{"s404m": "পাওয়া যায়নি"})
+	i18n.SetCatalog(catalog)
+	defer i18n.SetCatalog(nil)
+
+	h := &HTTPServer{}
+	values := model.ConfigMap{"s404m": "configured not found", "s404m_fr": "introuvable"}
+
+	for _, tc := range []struct {
+		key, lang, want string
+	}{
+		{"s404m", "bn", "পাওয়া যায়নি"},
+		{"s404m", "fr", "introuvable"},
+		// the default language of the catalog never overrides the values
+		{"s404m", "en", "configured not found"},
+		{"s404m", "de", "configured not found"},
+		{"s405m", "en", "catalog not allowed"},
+		{"s500m", "en", "default"},
+	} {
+		if message := h.getMessage(values, tc.key, "default", tc.lang); message != tc.want {
+			t.Errorf("%s %s = %q, want %q", tc.key, tc.lang, message, tc.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ErrInvalidHostPattern = errors.New("invalid host pattern")
+
+// hostParam is the params key of the matched host
+const hostParam = "host"
+
+// subdomainParam is the params key of the * capture
+const subdomainParam = "subdomain"
+
+// hostPattern matches the request host, a leading * label matches one or
+// more labels and a {name} label matches exactly one label
+type hostPattern struct {
+	pattern  string
+	labels   []string
+	wildcard bool
+}
+
+func parseHostPattern(pattern string) (*hostPattern, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("%w: empty host", ErrInvalidHostPattern)
+	}
+
+	hp := &hostPattern{pattern: pattern, labels: strings.Split(pattern, ".")}
+	for index, label := range hp.labels {
+		switch {
+		case label == "*":
+			if index != 0 || len(hp.labels) < 2 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidHostPattern, pattern)
+			}
+			hp.wildcard = true
+		case strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}"):
+			// the host and the * capture params are reserved
+			name := label[1 : len(label)-1]
+			if len(name) == 0 || name == hostParam || (hp.wildcard && name == subdomainParam) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidHostPattern, pattern)
+			}
+		case len(label) == 0 || strings.ContainsAny(label, "*{}"):
+			return nil, fmt.Errorf("%w: %s", ErrInvalidHostPattern, pattern)
+		}
+	}
+
+	return hp, nil
+}
+
+// exact reports whether the pattern has no captures
+func (hp *hostPattern) exact() bool {
+	return !hp.wildcard && !strings.Contains(hp.pattern, "{")
+}
+
+// paramNames returns the params keys set by the pattern
+func (hp *hostPattern) paramNames() []string {
+	nameList := []string{hostParam}
+	if hp.wildcard {
+		nameList = append(nameList, subdomainParam)
+	}
+
+	for _, label := range hp.labels {
+		if strings.HasPrefix(label, "{") {
+			nameList = append(nameList, label[1:len(label)-1])
+		}
+	}
+
+	return nameList
+}
+
+func (hp *hostPattern) match(host string) (map[string]string, bool) {
+	labels := strings.Split(host, ".")
+
+	var params map[string]string
+	patternLabels := hp.labels
+	if hp.wildcard {
+		patternLabels = hp.labels[1:]
+		if len(labels) <= len(patternLabels) {
+			return nil, false
+		}
+
+		params = map[string]string{subdomainParam: strings.Join(labels[:len(labels)-len(patternLabels)], ".")}
+		labels = labels[len(labels)-len(patternLabels):]
+	}
+
+	if len(labels) != len(patternLabels) {
+		return nil, false
+	}
+
+	for index, label := range patternLabels {
+		if strings.HasPrefix(label, "{") {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[label[1:len(label)-1]] = labels[index]
+			continue
+		}
+
+		if label != labels[index] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// requestHost returns the lower case host without the port
+func requestHost(hostPort string) string {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
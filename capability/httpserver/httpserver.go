@@ -1,48 +1,39 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
+	"github.com/mkawserm/abesh/certmanager"
 	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/i18n"
 	"github.com/mkawserm/abesh/iface"
 	"github.com/mkawserm/abesh/logger"
 	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/openapi"
 	"github.com/mkawserm/abesh/registry"
+	"github.com/mkawserm/abesh/tracing"
 	"github.com/mkawserm/abesh/utility"
 )
 
 var ErrPathNotDefined = errors.New("path not defined")
 var ErrMethodNotDefined = errors.New("method not defined")
 
-var responseStatus = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "abesh_httpserver_response_status",
-		Help: "Status of HTTP Response",
-	},
-	[]string{"path", "status"},
-)
-
-var panicCounter = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "abesh_httpserver_panic_counter",
-		Help: "Abesh HTTP Server Panic Counter",
-	},
-	[]string{"contractid"},
-)
-
 type EventResponse struct {
 	Error error
 	Event *model.Event
@@ -60,26 +51,79 @@ type HTTPServer struct {
 
 	mDefault404HandlerEnabled bool
 	mValues                   model.ConfigMap
-	mHttpServer               *http.Server
+	mListenerList             []*listener
+	mVirtualHostList          []*virtualHost
 	mHttpServerMux            *http.ServeMux
+	mRouter                   *router
 	mEventTransmitter         iface.IEventTransmitter
 
-	mRequestTimeout     time.Duration
-	mDefaultContentType string
-
+	mRequestTimeout time.Duration
+	mMaxBodySize    int64
+	mRetryAfter     string
+
+	mDecompressionEnabled bool
+	mMaxDecompressedSize  int64
+	mDebug                bool
+	mDefaultContentType   string
+	mTrustedProxyList     []*net.IPNet
+
+	mCapabilityRegistry iface.ICapabilityRegistry
+	mMemoryStoreMutex   sync.Mutex
+	mMemoryStore        iface.IKVStore
+	mCachePurgePath     string
+	mCachePurgeToken    string
+
+	mMountList           []*mountConfig
+	mStaticFSMutex       sync.RWMutex
+	mStaticFSMap         map[string]fs.FS
 	mEmbeddedStaticFSMap map[string]embed.FS
 
+	d400m string
 	d401m string
 	d403m string
 	d404m string
 	d405m string
 	d408m string
 	d409m string
+	d413m string
+	d415m string
+	d422m string
 	d499m string
 	d500m string
+	d503m string
 
 	mIsMetricsEnabled bool
 	mMetricPath       string
+
+	mMetricsHost     string
+	mMetricsPort     string
+	mMetricsServer   *http.Server
+	mDurationBuckets []float64
+	mSizeBuckets     []float64
+
+	mMetrics           iface.IMetrics
+	mResponseStatus    iface.Counter
+	mPanicCounter      iface.Counter
+	mRequestDuration   iface.Observer
+	mRequestSize       iface.Observer
+	mResponseSize      iface.Observer
+	mInFlight          iface.Gauge
+	mTimeouts          iface.Counter
+	mCancellations     iface.Counter
+	mAuthorizerDenials iface.Counter
+	mRateLimited       iface.Counter
+
+	mIsAccessLogEnabled bool
+	mAccessLog          *accessLog
+
+	mOpenAPIPath      string
+	mOpenAPIUIPath    string
+	mOpenAPIInfo      *openapi.Info
+	mOpenAPIServers   []string
+	mOpenAPIRouteList []*openapi.Route
+
+	// the authorizer expressions are documented only when enabled
+	mOpenAPIAuthorizerExpression bool
 }
 
 func (h *HTTPServer) Name() string {
@@ -125,43 +169,89 @@ func (h *HTTPServer) SetConfigMap(values model.ConfigMap) error {
 	h.mStaticDir = values.String("static_dir", "")
 	h.mStaticPath = values.String("static_path", "/static/")
 	h.mHealthPath = values.String("health_path", "")
+	h.mCachePurgePath = values.String("cache_purge_path", "")
+	h.mCachePurgeToken = values.String("cache_purge_token", "")
 
 	h.mRequestTimeout = h.mValues.Duration("default_request_timeout", time.Second)
+	h.mMaxBodySize = h.mValues.Int64("default_max_body_size", 0)
+	h.mRetryAfter = h.mValues.String("retry_after", "1")
+	h.mDecompressionEnabled = h.mValues.Bool("decompression_enabled", true)
+	h.mDebug = h.mValues.Bool("debug", false)
+	h.mMaxDecompressedSize = h.mValues.Int64("max_decompressed_size", 10<<20)
 	h.mDefault404HandlerEnabled = h.mValues.Bool("default_404_handler_enabled", true)
 	h.mDefaultContentType = values.String("default_content_type", "application/json")
 
+	h.d400m = h.buildDefaultMessage(400)
 	h.d401m = h.buildDefaultMessage(401)
 	h.d403m = h.buildDefaultMessage(403)
 	h.d404m = h.buildDefaultMessage(404)
 	h.d405m = h.buildDefaultMessage(405)
 	h.d408m = h.buildDefaultMessage(408)
 	h.d409m = h.buildDefaultMessage(409)
+	h.d413m = h.buildDefaultMessage(413)
+	h.d415m = h.buildDefaultMessage(415)
+	h.d422m = h.buildDefaultMessage(422)
 	h.d499m = h.buildDefaultMessage(499)
 	h.d500m = h.buildDefaultMessage(500)
+	h.d503m = h.buildDefaultMessage(503)
 
 	h.mIsMetricsEnabled = h.mValues.Bool("metrics_enabled", false)
 	h.mMetricPath = values.String("metric_path", "/metrics")
+	h.mMetricsHost = values.String("metrics_host", h.mHost)
+	h.mMetricsPort = values.String("metrics_port", "")
+	h.mDurationBuckets = floatList(values, "metrics_duration_buckets", defaultDurationBuckets)
+	h.mSizeBuckets = floatList(values, "metrics_size_buckets", defaultSizeBuckets)
+
+	h.mIsAccessLogEnabled = h.mValues.Bool("access_log_enabled", false)
+
+	h.mOpenAPIPath = values.String("openapi_path", "")
+	h.mOpenAPIUIPath = values.String("openapi_ui_path", "")
+	h.mOpenAPIServers = trimmedList(values, "openapi_servers")
+	h.mOpenAPIAuthorizerExpression = values.Bool("openapi_authorizer_expression_enabled", false)
+	h.mOpenAPIInfo = &openapi.Info{
+		Title:       values.String("openapi_title", constant.Name),
+		Description: values.String("openapi_description", ""),
+		Version:     values.String("openapi_version", constant.Version),
+	}
 
 	return nil
 }
 
-func (h *HTTPServer) getMessage(key, defaultValue, lang string) string {
-	data := h.mValues.String(fmt.Sprintf("%s_%s", key, lang), "")
+// getMessage looks the key up in the values for the language and its
+// parents (ex: s404m_pt-BR, s404m_pt), then in the shared i18n catalog
+// for the language, then in the values without a language and finally
+// in the default language of the catalog
+func (h *HTTPServer) getMessage(values model.ConfigMap, key, defaultValue, lang string) string {
+	for _, l := range i18n.Chain(lang) {
+		if data := values.String(fmt.Sprintf("%s_%s", key, l), ""); len(data) != 0 {
+			return data
+		}
+	}
+
+	if data, found := i18n.GetCatalog().LanguageMessage(lang, key); found {
+		return data
+	}
+
+	if data := values.String(key, ""); len(data) != 0 {
+		return data
+	}
 
-	if len(data) == 0 {
-		data = h.mValues.String(key, defaultValue)
+	if data, found := i18n.GetCatalog().DefaultMessage(key); found {
+		return data
 	}
 
-	return data
+	return defaultValue
 }
 
+// getLanguage negotiates the Accept-Language header against the
+// languages of the shared i18n catalog
 func (h *HTTPServer) getLanguage(r *http.Request) string {
-	l := r.Header.Get("Accept-Language")
-	if len(l) == 0 {
-		l = "en"
-	}
+	return i18n.GetCatalog().Language(r.Header.Get("Accept-Language"))
+}
 
-	return l
+func (h *HTTPServer) SetCapabilityRegistry(capabilityRegistry iface.ICapabilityRegistry) error {
+	h.mCapabilityRegistry = capabilityRegistry
+	return nil
 }
 
 func (h *HTTPServer) SetEventTransmitter(eventTransmitter iface.IEventTransmitter) error {
@@ -173,9 +263,57 @@ func (h *HTTPServer) GetEventTransmitter() iface.IEventTransmitter {
 	return h.mEventTransmitter
 }
 
+// AddEmbeddedStaticFS is AddEmbeddedStaticFSE which logs the error
 func (h *HTTPServer) AddEmbeddedStaticFS(pattern string, fs embed.FS) {
-	// NOTE: must be called after setup otherwise panic will occur
+	if err := h.AddEmbeddedStaticFSE(pattern, fs); err != nil {
+		logger.L(h.ContractId()).Error("embedded static fs is not added",
+			zap.String("pattern", pattern),
+			zap.Error(err))
+	}
+}
+
+// AddEmbeddedStaticFSE serves the embedded file system at the pattern with
+// the default mount options, it may be called before or after Setup, a
+// pattern which is already registered is an error
+func (h *HTTPServer) AddEmbeddedStaticFSE(pattern string, fs embed.FS) error {
+	h.mStaticFSMutex.Lock()
+	defer h.mStaticFSMutex.Unlock()
+
+	if _, found := h.mEmbeddedStaticFSMap[pattern]; found {
+		return fmt.Errorf("%w: %s", ErrMountPatternRegistered, pattern)
+	}
+
+	if h.mHttpServerMux != nil {
+		if err := h.handleEmbeddedStaticFS(pattern, fs); err != nil {
+			return err
+		}
+	}
+
+	if h.mEmbeddedStaticFSMap == nil {
+		h.mEmbeddedStaticFSMap = make(map[string]embed.FS)
+	}
 	h.mEmbeddedStaticFSMap[pattern] = fs
+
+	return nil
+}
+
+// handleEmbeddedStaticFS keeps the pattern in the file path, the files of
+// an embed.FS include their directory
+func (h *HTTPServer) handleEmbeddedStaticFS(pattern string, fsys embed.FS) error {
+	options, _ := newStaticOptions(model.ConfigMap{})
+	return h.handleMount(pattern, "", fsys, options)
+}
+
+// AddStaticFS adds a file system served by the mounts with
+// mount_<name>_fs set to name, it may be called before or after Setup
+func (h *HTTPServer) AddStaticFS(name string, fsys fs.FS) {
+	h.mStaticFSMutex.Lock()
+	defer h.mStaticFSMutex.Unlock()
+
+	if h.mStaticFSMap == nil {
+		h.mStaticFSMap = make(map[string]fs.FS)
+	}
+	h.mStaticFSMap[name] = fsys
 }
 
 func (h *HTTPServer) New() iface.ICapability {
@@ -191,15 +329,59 @@ func (h *HTTPServer) AddHandler(pattern string, handler http.Handler) {
 }
 
 func (h *HTTPServer) Setup() error {
-	h.mHttpServer = new(http.Server)
+	h.setupMetrics()
+
 	h.mHttpServerMux = new(http.ServeMux)
-	h.mEmbeddedStaticFSMap = make(map[string]embed.FS)
+
+	mountList, err := newMountConfigList(h.mValues)
+	if err != nil {
+		return err
+	}
+	h.mMountList = mountList
+
+	if h.mTrustedProxyList, err = parseTrustedProxyList(h.mValues); err != nil {
+		return err
+	}
+
+	// services are served by the router, everything else falls back to the mux
+	h.mRouter = newRouter(http.HandlerFunc(h.serveFallback), func(writer http.ResponseWriter, request *http.Request) {
+		h.s405m(request, writer, nil)
+	})
+	h.mRouter.fallbackPattern = h.fallbackPattern
+
+	virtualHostList, err := h.newVirtualHostList(h.mHttpServerMux)
+	if err != nil {
+		return err
+	}
+	h.mVirtualHostList = virtualHostList
 
 	// setup server details
-	h.mHttpServer.Handler = h.mHttpServerMux
-	h.mHttpServer.Addr = h.mHost + ":" + h.mPort
+	var handler http.Handler = h.mRouter
+	if h.mIsAccessLogEnabled {
+		accessLog, err := newAccessLog(h.ContractId(), h.mValues)
+		if err != nil {
+			return err
+		}
+		h.mAccessLog = accessLog
+		handler = accessLog.handler(h.mRouter)
+	}
+
+	listenerList, err := h.newListenerList(handler)
+	if err != nil {
+		return err
+	}
+	h.mListenerList = listenerList
 
-	if h.mDefault404HandlerEnabled {
+	if err = h.registerMountList(); err != nil {
+		return err
+	}
+
+	if err = h.registerEmbeddedStaticFSList(); err != nil {
+		return err
+	}
+
+	// a root mount serves the not found responses itself
+	if h.mDefault404HandlerEnabled && !h.hasRootMount() {
 		h.mHttpServerMux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 			h.debugMessage(request)
 
@@ -232,6 +414,28 @@ func (h *HTTPServer) Setup() error {
 		}
 	}
 
+	// register openapi document and swagger ui
+	if len(h.mOpenAPIPath) != 0 {
+		h.mHttpServerMux.Handle(h.mOpenAPIPath, openapi.DocumentHandler(h.openAPIDocument))
+
+		// the assets of the ui are served below the ui path
+		if len(h.mOpenAPIUIPath) != 0 {
+			uiHandler := openapi.UIHandler(h.mOpenAPIInfo.Title, h.mOpenAPIPath)
+			h.mHttpServerMux.Handle(h.mOpenAPIUIPath, uiHandler)
+			if !strings.HasSuffix(h.mOpenAPIUIPath, "/") {
+				h.mHttpServerMux.Handle(h.mOpenAPIUIPath+"/", uiHandler)
+			}
+		}
+	}
+
+	// register cache purge path, it is never served without a token
+	if len(h.mCachePurgePath) != 0 {
+		if len(h.mCachePurgeToken) == 0 {
+			return ErrCachePurgeTokenRequired
+		}
+		h.mHttpServerMux.HandleFunc(h.mCachePurgePath, h.purgeHandler(h.mCachePurgeToken))
+	}
+
 	// register health path
 	if len(h.mHealthPath) != 0 {
 		h.mHttpServerMux.HandleFunc(h.mHealthPath, func(writer http.ResponseWriter, _ *http.Request) {
@@ -245,27 +449,33 @@ func (h *HTTPServer) Setup() error {
 		zap.String("port", h.mPort))
 
 	if h.mIsMetricsEnabled {
-		h.AddHandler(h.mMetricPath, promhttp.Handler())
-		logger.L(h.ContractId()).Info("metrics enabled", zap.String("metric_path", h.mMetricPath))
+		h.setupMetricsListener()
 	}
 
 	return nil
 }
 
 func (h *HTTPServer) Start(_ context.Context) error {
-	logger.L(h.ContractId()).Debug("registering embedded data fs")
-	for p, d := range h.mEmbeddedStaticFSMap {
-		h.mHttpServerMux.Handle(p, http.FileServer(http.FS(d)))
+	if err := h.checkMountList(); err != nil {
+		return err
 	}
 
-	logger.L(h.ContractId()).Info("http server started at " + h.mHttpServer.Addr)
+	h.startMetricsListener()
 
-	if len(h.mCertFile) != 0 && len(h.mKeyFile) != 0 {
-		if err := h.mHttpServer.ListenAndServeTLS(h.mCertFile, h.mKeyFile); err != http.ErrServerClosed {
-			return err
-		}
-	} else {
-		if err := h.mHttpServer.ListenAndServe(); err != http.ErrServerClosed {
+	errCh := make(chan error, len(h.mListenerList))
+	for _, l := range h.mListenerList {
+		logger.L(h.ContractId()).Info("http server started at "+l.address,
+			zap.String("listener", l.name),
+			zap.String("type", l.kind))
+
+		go func(l *listener) {
+			errCh <- l.serve()
+		}(l)
+	}
+
+	// a listener failing to serve stops the trigger
+	for range h.mListenerList {
+		if err := <-errCh; err != nil {
 			return err
 		}
 	}
@@ -274,11 +484,28 @@ func (h *HTTPServer) Start(_ context.Context) error {
 }
 
 func (h *HTTPServer) Stop(ctx context.Context) error {
-	if h.mHttpServer != nil {
-		return h.mHttpServer.Shutdown(ctx)
+	if h.mMetricsServer != nil {
+		if err := h.mMetricsServer.Shutdown(ctx); err != nil {
+			logger.L(h.ContractId()).Error(err.Error())
+		}
 	}
 
-	return nil
+	if h.mAccessLog != nil {
+		defer func() {
+			if err := h.mAccessLog.close(); err != nil {
+				logger.L(h.ContractId()).Error(err.Error())
+			}
+		}()
+	}
+
+	var err error
+	for _, l := range h.mListenerList {
+		if errLocal := l.server.Shutdown(ctx); errLocal != nil && err == nil {
+			err = errLocal
+		}
+	}
+
+	return err
 }
 
 func (h *HTTPServer) TransmitInputEvent(contractId string, inputEvent *model.Event) {
@@ -312,7 +539,7 @@ func (h *HTTPServer) TransmitOutputEvent(contractId string, outputEvent *model.E
 
 func (h *HTTPServer) writeMessage(statusCode int, defaultMessage string, request *http.Request, writer http.ResponseWriter, errLocal error) {
 	if errLocal != nil {
-		logger.L(h.ContractId()).Error(errLocal.Error(),
+		logger.LC(request.Context(), h.ContractId()).Error(errLocal.Error(),
 			zap.String("version", h.Version()),
 			zap.String("name", h.Name()),
 			zap.String("contract_id", h.ContractId()))
@@ -320,53 +547,140 @@ func (h *HTTPServer) writeMessage(statusCode int, defaultMessage string, request
 
 	writer.Header().Add("Content-Type", h.mDefaultContentType)
 	writer.WriteHeader(statusCode)
-	if _, err := writer.Write([]byte(h.getMessage(fmt.Sprintf("s%dm", statusCode), defaultMessage, h.getLanguage(request)))); err != nil {
-		logger.L(h.ContractId()).Error(err.Error(),
+	if _, err := writer.Write([]byte(h.getMessage(h.messageValues(request), fmt.Sprintf("s%dm", statusCode), defaultMessage, h.getLanguage(request)))); err != nil {
+		logger.LC(request.Context(), h.ContractId()).Error(err.Error(),
 			zap.String("version", h.Version()),
 			zap.String("name", h.Name()),
 			zap.String("contract_id", h.ContractId()))
 	}
 }
 
+// writeHeaders adds the service headers, repeated header values take
+// precedence over the single valued headers of the same key and the
+// headers already set by the server (request id, CORS, security and rate
+// limit headers) are never replaced, only Vary is extended
+func (h *HTTPServer) writeHeaders(writer http.ResponseWriter, metadata *model.Metadata) {
+	preset := make(map[string]bool, len(writer.Header()))
+	for k := range writer.Header() {
+		preset[k] = k != "Vary"
+	}
+
+	add := func(k, v string) {
+		if !preset[http.CanonicalHeaderKey(k)] {
+			writer.Header().Add(k, v)
+		}
+	}
+
+	for k, v := range metadata.GetHeaders() {
+		if _, found := metadata.GetHeaderValues()[k]; found {
+			continue
+		}
+		add(k, v)
+	}
+
+	for k, v := range metadata.GetHeaderValues() {
+		for _, value := range v.GetValues() {
+			add(k, value)
+		}
+	}
+}
+
+func (h *HTTPServer) s400m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "400").Inc()
+	h.writeMessage(400, h.d400m, request, writer, errLocal)
+}
+
 func (h *HTTPServer) s401m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "401").Inc()
+	h.mResponseStatus.With(routeLabel(request), "401").Inc()
 	h.writeMessage(401, h.d401m, request, writer, errLocal)
 }
 
 func (h *HTTPServer) s403m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "403").Inc()
+	h.mResponseStatus.With(routeLabel(request), "403").Inc()
 	h.writeMessage(403, h.d403m, request, writer, errLocal)
 }
 
 func (h *HTTPServer) s404m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "404").Inc()
+	h.mResponseStatus.With(routeLabel(request), "404").Inc()
 	h.writeMessage(404, h.d404m, request, writer, errLocal)
 }
 
 func (h *HTTPServer) s405m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "405").Inc()
+	h.mResponseStatus.With(routeLabel(request), "405").Inc()
 	h.writeMessage(405, h.d405m, request, writer, errLocal)
 }
 
 func (h *HTTPServer) s408m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "408").Inc()
+	h.mResponseStatus.With(routeLabel(request), "408").Inc()
 	h.writeMessage(408, h.d408m, request, writer, errLocal)
 }
 
+func (h *HTTPServer) s409m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "409").Inc()
+	h.writeMessage(409, h.d409m, request, writer, errLocal)
+}
+
+func (h *HTTPServer) s413m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "413").Inc()
+	h.writeMessage(413, h.d413m, request, writer, errLocal)
+}
+
+func (h *HTTPServer) s415m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "415").Inc()
+	h.writeMessage(415, h.d415m, request, writer, errLocal)
+}
+
+func (h *HTTPServer) s422m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "422").Inc()
+	h.writeMessage(422, h.d422m, request, writer, errLocal)
+}
+
 func (h *HTTPServer) s499m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "499").Inc()
+	h.mResponseStatus.With(routeLabel(request), "499").Inc()
 	h.writeMessage(499, h.d499m, request, writer, errLocal)
 }
 
 func (h *HTTPServer) s500m(request *http.Request, writer http.ResponseWriter, errLocal error) {
-	responseStatus.WithLabelValues(request.URL.Path, "500").Inc()
+	h.mResponseStatus.With(routeLabel(request), "500").Inc()
 	h.writeMessage(500, h.d500m, request, writer, errLocal)
 }
 
+func (h *HTTPServer) s503m(request *http.Request, writer http.ResponseWriter, errLocal error) {
+	h.mResponseStatus.With(routeLabel(request), "503").Inc()
+	writer.Header().Set("Retry-After", h.mRetryAfter)
+	h.writeMessage(503, h.d503m, request, writer, errLocal)
+}
+
+// writeServiceError answers structured errors with the mapped status,
+// any other error is answered with the default 500 message
+func (h *HTTPServer) writeServiceError(sr *serviceRoute, request *http.Request, writer http.ResponseWriter, metadata *model.Metadata, errLocal error) {
+	statusCode, data, ok := sr.errorResponse(errLocal, metadata)
+	if !ok {
+		h.s500m(request, writer, errLocal)
+		return
+	}
+
+	logger.LC(request.Context(), h.ContractId()).Error(errLocal.Error(),
+		zap.String("version", h.Version()),
+		zap.String("name", h.Name()),
+		zap.String("contract_id", h.ContractId()))
+
+	h.mResponseStatus.With(routeLabel(request), fmt.Sprintf("%d", statusCode)).Inc()
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	if _, err := writer.Write(data); err != nil {
+		logger.LC(request.Context(), h.ContractId()).Error(err.Error(),
+			zap.String("version", h.Version()),
+			zap.String("name", h.Name()),
+			zap.String("contract_id", h.ContractId()))
+	}
+}
+
 func (h *HTTPServer) debugMessage(request *http.Request) {
-	logger.L(h.ContractId()).Debug("request local timeout in seconds", zap.Duration("timeout", h.mRequestTimeout))
-	logger.L(h.ContractId()).Debug("request started")
-	logger.L(h.ContractId()).Debug("request data",
+	logger.LC(request.Context(), h.ContractId()).Debug("request local timeout in seconds", zap.Duration("timeout", h.mRequestTimeout))
+	logger.LC(request.Context(), h.ContractId()).Debug("request started")
+	logger.LC(request.Context(), h.ContractId()).Debug("request data",
 		zap.String("path", request.URL.Path),
 		zap.String("method", request.Method),
 		zap.String("path_with_query", request.RequestURI))
@@ -383,181 +697,440 @@ func (h *HTTPServer) AddService(
 		zap.Any("expression", authorizerExpression),
 		zap.Any("triggerValues", triggerValues))
 
-	var method string
-	var path string
-	var methodList []string
+	sr, err := h.newServiceRoute(authorizer, authorizerExpression, triggerValues, service)
+	if err != nil {
+		return err
+	}
 
-	if method = triggerValues.String("method", ""); len(method) == 0 {
-		return ErrMethodNotDefined
+	if len(h.mOpenAPIPath) != 0 {
+		route := openapi.NewRoute(triggerValues, service.ContractId(), service)
+		if authorizer != nil {
+			route.SetAuthorizer(authorizer.ContractId(), authorizerExpression, authorizer)
+		}
+		h.mOpenAPIRouteList = append(h.mOpenAPIRouteList, route)
 	}
 
-	method = strings.ToUpper(strings.TrimSpace(method))
-	methodList = strings.Split(method, ",")
-	if len(methodList) > 0 {
-		sort.Strings(methodList)
+	return h.mRouter.addWithHost(sr.host, sr.methodList, sr.path, func(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+		h.serveRoute(sr, writer, request, params)
+	}, sr.preflightHandler())
+}
+
+// openAPIDocument documents every service added to the trigger
+func (h *HTTPServer) openAPIDocument() *openapi.Document {
+	d := openapi.NewDocument(h.mOpenAPIInfo, h.mOpenAPIServers...)
+	d.AuthorizerExpressionEnabled = h.mOpenAPIAuthorizerExpression
+	for _, r := range h.mOpenAPIRouteList {
+		d.AddRoute(r)
 	}
 
-	if path = triggerValues.String("path", ""); len(path) == 0 {
-		return ErrPathNotDefined
+	return d
+}
+
+func (h *HTTPServer) serveRoute(sr *serviceRoute, writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var err error
+	timerStart := time.Now()
+
+	// every log line of the request carries the request id
+	requestId := utility.RequestIdFromHeader(request.Header)
+
+	ctx, span := tracing.StartSpan(tracing.Extract(request.Context(), request.Header),
+		"HTTP "+request.Method+" "+sr.path, tracing.SpanKindServer)
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.route", sr.path)
+	span.SetAttribute("http.target", request.URL.RequestURI())
+
+	request = request.WithContext(withRoute(logger.WithRequestId(ctx, requestId), sr.path))
+
+	if info := accessInfoFrom(ctx); info != nil {
+		info.route = sr.path
+		info.requestId = requestId
 	}
 
-	path = strings.TrimSpace(path)
+	rw := &responseWriter{ResponseWriter: writer}
+	writer = rw
+	writer.Header().Set(utility.RequestIdHeader, requestId)
 
-	requestHandler := func(writer http.ResponseWriter, request *http.Request) {
-		var err error
-		timerStart := time.Now()
+	var body *limitedReader
 
-		defer func() {
-			logger.L(h.ContractId()).Debug("request completed")
-			elapsed := time.Since(timerStart)
-			logger.L(h.ContractId()).Debug("request execution time", zap.Duration("seconds", elapsed))
-		}()
+	h.mInFlight.With(sr.path, request.Method).Add(1)
+
+	defer func() {
+		h.mInFlight.With(sr.path, request.Method).Sub(1)
 
+		var requestSize int64
+		if body != nil {
+			requestSize = body.size()
+		}
+		h.observeRequest(sr, request, rw, requestSize, timerStart)
+
+		span.SetAttribute("http.status_code", strconv.Itoa(rw.status()))
+		if rw.status() >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(rw.status()))
+		}
+		span.End()
+	}()
+
+	defer func() {
+		logger.LC(request.Context(), h.ContractId()).Debug("request completed")
+		elapsed := time.Since(timerStart)
+		logger.LC(request.Context(), h.ContractId()).Debug("request execution time", zap.Duration("seconds", elapsed))
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicMsg := fmt.Sprintf("%v", r)
+			logger.LC(request.Context(), h.ContractId()).Info("recovering from panic")
+
+			// add as much information as possible
+			logger.LC(request.Context(), h.ContractId()).Error("panic data",
+				zap.String("host_name", request.URL.Hostname()),
+				zap.String("host", request.URL.Host),
+				zap.String("path", request.URL.Path),
+				zap.String("method", request.Method),
+				zap.String("uri", request.RequestURI),
+				zap.String("panic_msg", panicMsg))
+
+			go func() {
+				h.mPanicCounter.With(h.ContractId()).Inc()
+			}()
+
+			h.s500m(request, writer, nil)
+			return
+		}
+	}()
+
+	h.debugMessage(request)
+
+	sr.writePolicyHeaders(writer, request)
+
+	// the slot is held until the service returns, even after a timeout
+	var slotHandedOver bool
+	if sr.bulkhead != nil {
+		if !sr.bulkhead.acquire(request.Context()) {
+			h.s503m(request, writer, nil)
+			return
+		}
 		defer func() {
-			if r := recover(); r != nil {
-				panicMsg := fmt.Sprintf("%v", r)
-				logger.L(h.ContractId()).Info("recovering from panic")
-
-				// add as much information as possible
-				logger.L(h.ContractId()).Error("panic data",
-					zap.String("host_name", request.URL.Hostname()),
-					zap.String("host", request.URL.Host),
-					zap.String("path", request.URL.Path),
-					zap.String("method", request.Method),
-					zap.String("uri", request.RequestURI),
-					zap.String("panic_msg", panicMsg))
-
-				go func() {
-					panicCounter.WithLabelValues(h.ContractId()).Inc()
-				}()
-
-				h.s500m(request, writer, nil)
-				return
+			if !slotHandedOver {
+				sr.bulkhead.release()
 			}
 		}()
+	}
 
-		h.debugMessage(request)
+	if sr.maxBodySize > 0 && request.ContentLength > sr.maxBodySize {
+		h.s413m(request, writer, nil)
+		return
+	}
 
-		if !utility.IsIn(methodList, request.Method) {
-			h.s405m(request, writer, nil)
-			return
+	var data []byte
+
+	headers := make(map[string]string)
+
+	metadata := &model.Metadata{}
+	metadata.UniqueId = requestId
+	metadata.TraceParent = span.SpanContext().TraceParent()
+	metadata.Method = request.Method
+	metadata.Path = request.URL.EscapedPath()
+	metadata.RemoteAddr = peerAddress(request)
+	metadata.Host = request.Host
+	metadata.Scheme = "http"
+	if request.TLS != nil {
+		metadata.Scheme = "https"
+	}
+	metadata.Headers = make(map[string]string)
+	metadata.Query = make(map[string]string)
+
+	// only a verified client certificate is trusted
+	if request.TLS != nil && len(request.TLS.VerifiedChains) != 0 && len(request.TLS.VerifiedChains[0]) != 0 {
+		metadata.ClientCertificate = certmanager.ClientCertificate(request.TLS.VerifiedChains[0][0])
+	}
+	metadata.Params = params
+	metadata.ContractIdList = append(metadata.ContractIdList, h.ContractId())
+
+	for k, v := range request.Header {
+		if len(v) > 0 {
+			metadata.SetHeaderValueList(k, v)
+			headers[strings.ToLower(strings.TrimSpace(k))] = v[0]
 		}
+	}
 
-		var data []byte
+	for k, v := range request.URL.Query() {
+		if len(v) > 0 {
+			metadata.SetQueryValueList(k, v)
+		}
+	}
 
-		headers := make(map[string]string)
+	if sr.rateLimit != nil && !sr.rateLimit.afterAuthorizer && h.serveRateLimited(sr, writer, request, metadata) {
+		return
+	}
 
-		metadata := &model.Metadata{}
-		metadata.Method = request.Method
-		metadata.Path = request.URL.EscapedPath()
-		metadata.Headers = make(map[string]string)
-		metadata.Query = make(map[string]string)
-		metadata.ContractIdList = append(metadata.ContractIdList, h.ContractId())
+	if sr.authorizer != nil {
+		_, authorizeSpan := tracing.StartSpan(request.Context(), "authorize", tracing.SpanKindInternal)
+		authorized := sr.authorizer.IsAuthorized(sr.authorizerExpression, metadata)
+		authorizeSpan.SetAttribute("authorized", strconv.FormatBool(authorized))
+		authorizeSpan.End()
 
-		for k, v := range request.Header {
-			if len(v) > 0 {
-				metadata.Headers[k] = v[0]
-				headers[strings.ToLower(strings.TrimSpace(k))] = v[0]
-			}
+		if !authorized {
+			h.mAuthorizerDenials.With(sr.path, request.Method).Inc()
+			h.s403m(request, writer, nil)
+			return
 		}
+	}
 
-		for k, v := range request.URL.Query() {
-			if len(v) > 0 {
-				metadata.Query[k] = v[0]
-			}
+	// the principal and header keys are taken after the authorizer so
+	// that the credentials are verified
+	if sr.rateLimit != nil && sr.rateLimit.afterAuthorizer && h.serveRateLimited(sr, writer, request, metadata) {
+		return
+	}
+
+	if sr.validator != nil {
+		if err = sr.validator.validateRequest(request); err != nil {
+			h.writeServiceError(sr, request, writer, metadata, err)
+			return
 		}
+	}
 
-		if authorizer != nil {
-			if !authorizer.IsAuthorized(authorizerExpression, metadata) {
-				h.s403m(request, writer, nil)
+	var reader io.Reader = request.Body
+	bodyLimit := sr.maxBodySize
+
+	if contentEncoding := request.Header.Get("Content-Encoding"); sr.decompressionEnabled && len(contentEncoding) != 0 {
+		if reader, err = decompressReader(contentEncoding, request.Body); err != nil {
+			if err == ErrUnsupportedContentEncoding {
+				h.s415m(request, writer, err)
 				return
 			}
+			h.s400m(request, writer, err)
+			return
 		}
 
-		if data, err = ioutil.ReadAll(request.Body); err != nil {
-			h.s500m(request, writer, err)
-			return
+		// guard against decompression bombs, the decoded body is limited too
+		if bodyLimit <= 0 || (sr.maxDecompressedSize > 0 && sr.maxDecompressedSize < bodyLimit) {
+			bodyLimit = sr.maxDecompressedSize
 		}
 
-		inputEvent := &model.Event{
-			Metadata: metadata,
-			TypeUrl:  utility.GetValue(headers, "content-type", "application/text"),
-			Value:    data,
+		// the service receives the decoded body
+		for _, k := range []string{"Content-Encoding", "Content-Length"} {
+			delete(metadata.Headers, k)
+			delete(metadata.HeaderValues, k)
 		}
+	}
 
-		// transmit input event
-		h.TransmitInputEvent(service.ContractId(), inputEvent)
+	body = newLimitedReader(reader, bodyLimit)
+	var streamBody io.Reader = body
+	_, isStreamService := sr.service.(iface.IServeStream)
+	validateBody := sr.validator != nil && sr.validator.body != nil
+	idempotent := sr.idempotency != nil && sr.idempotency.applies(request)
 
-		nCtx, cancel := context.WithTimeout(request.Context(), h.mRequestTimeout)
-		defer cancel()
+	var idempotencyKey string
+	if idempotent {
+		if idempotencyKey, err = sr.idempotency.key(sr, request, metadata); err != nil {
+			h.s400m(request, writer, err)
+			return
+		}
+		idempotent = len(idempotencyKey) != 0
+	}
 
-		ch := make(chan EventResponse, 1)
+	var fingerprint string
 
-		func() {
-			if request.Context().Err() != nil {
-				ch <- EventResponse{
-					Event: nil,
-					Error: request.Context().Err(),
-				}
-			} else {
-				go func() {
-					event, errInner := service.Serve(nCtx, inputEvent)
-					ch <- EventResponse{Event: event, Error: errInner}
-				}()
-			}
-		}()
-
-		select {
-		case <-nCtx.Done():
-			h.s408m(request, writer, nil)
-			return
-		case r := <-ch:
-			if r.Error == context.DeadlineExceeded {
-				h.s408m(request, writer, r.Error)
+	// a validated or an idempotent body is read even for the stream services
+	if !isStreamService || validateBody || idempotent {
+		if data, err = ioutil.ReadAll(body); err != nil {
+			if body.exceeded {
+				h.s413m(request, writer, err)
 				return
 			}
+			h.s500m(request, writer, err)
+			return
+		}
 
-			if r.Error == context.Canceled {
-				h.s499m(request, writer, r.Error)
+		if validateBody {
+			if err = sr.validator.validateBody(data); err != nil {
+				h.writeServiceError(sr, request, writer, metadata, err)
 				return
 			}
+		}
 
-			if r.Error != nil {
-				h.s500m(request, writer, r.Error)
-				return
+		if idempotent {
+			fingerprint = sr.idempotency.fingerprint(request, data)
+		}
+
+		if isStreamService {
+			streamBody = bytes.NewReader(data)
+			data = nil
+		}
+	}
+
+	inputEvent := &model.Event{
+		Metadata: metadata,
+		TypeUrl:  utility.GetValue(headers, "content-type", "application/text"),
+		Value:    data,
+	}
+
+	// cached responses are served after the authorizer and the validation
+	var cacheKey string
+	if sr.cache != nil && sr.cache.cacheable(request) {
+		cacheKey = sr.cache.key(request)
+		if h.serveCached(sr, cacheKey, inputEvent, writer, request) {
+			return
+		}
+	}
+
+	if idempotent && h.serveIdempotent(sr, idempotencyKey, fingerprint, writer, request) {
+		return
+	}
+
+	// transmit input event
+	h.TransmitInputEvent(sr.service.ContractId(), inputEvent)
+
+	nCtx, cancel := context.WithTimeout(request.Context(), sr.requestTimeout)
+	defer cancel()
+
+	ch := make(chan EventResponse, 1)
+
+	func() {
+		if request.Context().Err() != nil {
+			if idempotent {
+				sr.idempotency.release(logger.WithRequestId(context.Background(), requestId), idempotencyKey)
 			}
 
-			// NOTE: PROMETHEUS RESPONSE STATISTICS
+			ch <- EventResponse{
+				Event: nil,
+				Error: request.Context().Err(),
+			}
+		} else {
+			slotHandedOver = true
 			go func() {
-				responseStatus.WithLabelValues(request.URL.Path,
-					fmt.Sprintf("%d", r.Event.Metadata.StatusCode)).Inc()
+				if sr.bulkhead != nil {
+					defer sr.bulkhead.release()
+				}
+
+				serveCtx, serveSpan := tracing.StartSpan(nCtx, "serve "+sr.service.ContractId(), tracing.SpanKindInternal)
+				defer serveSpan.End()
+
+				event, errInner := serveEvent(serveCtx, sr.service, inputEvent, streamBody)
+				serveSpan.SetError(errInner)
+
+				// the result is stored even when the request timed out meanwhile,
+				// so that a retry never serves the request again
+				if idempotent {
+					sr.idempotency.finish(logger.WithRequestId(context.Background(), requestId),
+						idempotencyKey, fingerprint, metadata, event, errInner)
+				}
+
+				ch <- EventResponse{Event: event, Error: errInner}
 			}()
+		}
+	}()
+
+	select {
+	case <-nCtx.Done():
+		// the stream service may still read the request body, which must
+		// not be read once the handler returns
+		if isStreamService && streamBody == body {
+			body.close()
+		}
 
-			// transmit output event
-			h.TransmitOutputEvent(service.ContractId(), r.Event)
+		if request.Context().Err() == context.Canceled {
+			h.mCancellations.With(sr.path, request.Method).Inc()
+			h.s499m(request, writer, nil)
+			return
+		}
 
-			// NOTE: handle success from service
-			for k, v := range r.Event.Metadata.Headers {
-				writer.Header().Add(k, v)
-			}
+		h.mTimeouts.With(sr.path, request.Method).Inc()
+		h.s408m(request, writer, nil)
+		return
+	case r := <-ch:
+		if r.Error == context.DeadlineExceeded {
+			h.mTimeouts.With(sr.path, request.Method).Inc()
+			h.s408m(request, writer, r.Error)
+			return
+		}
 
-			writer.WriteHeader(int(r.Event.Metadata.StatusCode))
+		if r.Error == context.Canceled {
+			h.mCancellations.With(sr.path, request.Method).Inc()
+			h.s499m(request, writer, r.Error)
+			return
+		}
 
-			if _, err = writer.Write(r.Event.Value); err != nil {
-				logger.L(h.ContractId()).Error(err.Error(),
-					zap.String("version", h.Version()),
-					zap.String("name", h.Name()),
-					zap.String("contract_id", h.ContractId()))
+		if r.Error != nil && body.exceeded {
+			h.s413m(request, writer, r.Error)
+			return
+		}
+
+		if r.Error != nil {
+			h.writeServiceError(sr, request, writer, metadata, r.Error)
+			return
+		}
+
+		// NOTE: PROMETHEUS RESPONSE STATISTICS
+		go func() {
+			h.mResponseStatus.With(routeLabel(request),
+				fmt.Sprintf("%d", r.Event.Metadata.StatusCode)).Inc()
+		}()
+
+		if len(r.Event.Metadata.UniqueId) == 0 {
+			r.Event.Metadata.UniqueId = requestId
+		}
+
+		if len(r.Event.Metadata.TraceParent) == 0 {
+			r.Event.Metadata.TraceParent = metadata.TraceParent
+		}
+
+		// transmit output event
+		h.TransmitOutputEvent(sr.service.ContractId(), r.Event)
+
+		if len(cacheKey) != 0 {
+			if entry := sr.cache.save(request.Context(), cacheKey, metadata, r.Event.Metadata, r.Event.Value); entry != nil {
+				sr.cache.writeCacheHeaders(writer, cacheMiss, 0)
+				if notModified(request, entry.ETag, entry.LastModified) {
+					writeNotModified(writer, r.Event.Metadata)
+					return
+				}
 			}
 		}
+
+		// NOTE: handle success from service
+		h.writeResponse(sr, writer, request, r.Event.Metadata, r.Event.Value)
 	}
+}
 
-	h.mHttpServerMux.HandleFunc(path, requestHandler)
+// serveEvent uses the stream interface when the service implements it
+func serveEvent(ctx context.Context, service iface.IService, event *model.Event, body io.Reader) (*model.Event, error) {
+	if streamService, ok := service.(iface.IServeStream); ok {
+		if body == nil {
+			body = bytes.NewReader(event.Value)
+		}
+		return streamService.ServeStream(ctx, event, body)
+	}
 
-	return nil
+	return service.Serve(ctx, event)
+}
+
+// writeResponse writes the headers and the compressed value
+func (h *HTTPServer) writeResponse(sr *serviceRoute, writer http.ResponseWriter, request *http.Request, metadata *model.Metadata, value []byte) {
+	h.writeHeaders(writer, metadata)
+
+	if sr.compression != nil {
+		if compressed, errCompress := sr.compression.apply(writer, request, value); errCompress != nil {
+			logger.LC(request.Context(), h.ContractId()).Error(errCompress.Error(),
+				zap.String("version", h.Version()),
+				zap.String("name", h.Name()),
+				zap.String("contract_id", h.ContractId()))
+		} else {
+			value = compressed
+		}
+	}
+
+	writer.WriteHeader(int(metadata.StatusCode))
+
+	if _, err := writer.Write(value); err != nil {
+		logger.LC(request.Context(), h.ContractId()).Error(err.Error(),
+			zap.String("version", h.Version()),
+			zap.String("name", h.Name()),
+			zap.String("contract_id", h.ContractId()))
+	}
 }
 
 func init() {
-	prometheus.MustRegister(panicCounter, responseStatus)
 	registry.GlobalRegistry().AddCapability(&HTTPServer{})
 }
@@ -0,0 +1,268 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrIdempotencyKeyRequired = errors.New("idempotency key required")
+var ErrIdempotencyKeyTooLong = errors.New("idempotency key too long")
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is in use")
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key is used with a different request")
+
+const idempotencyKeyPrefix = "idempotency:"
+
+// idempotencyMaxKeyLength limits the client supplied keys
+const idempotencyMaxKeyLength = 255
+
+// idempotencyReplayedHeader marks the stored responses
+const idempotencyReplayedHeader = "Idempotent-Replayed"
+
+const (
+	idempotencyProcessing = "processing"
+	idempotencyDone       = "done"
+)
+
+// idempotencyRecord is the lock while the first request is served and
+// its response afterwards
+type idempotencyRecord struct {
+	State       string              `json:"state"`
+	Fingerprint string              `json:"fingerprint"`
+	StatusCode  int                 `json:"status_code,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Value       []byte              `json:"value,omitempty"`
+}
+
+// idempotencyPolicy replays the first response of the requests with the
+// same idempotency key, it is configured with the idempotency_enabled,
+// idempotency_header, idempotency_required, idempotency_ttl,
+// idempotency_lock_ttl and idempotency_store trigger values
+type idempotencyPolicy struct {
+	contractId string
+	store      iface.IKVStore
+	header     string
+	required   bool
+	ttl        time.Duration
+	lockTTL    time.Duration
+
+	// guards the stores without SetNX within this server
+	inFlight sync.Map
+}
+
+func (h *HTTPServer) newIdempotencyPolicy(triggerValues model.ConfigMap) (*idempotencyPolicy, error) {
+	if !triggerValues.Bool("idempotency_enabled", false) {
+		return nil, nil
+	}
+
+	store, err := h.kvStore(triggerValues.String("idempotency_store", h.mValues.String("idempotency_store", "")))
+	if err != nil {
+		return nil, err
+	}
+
+	return &idempotencyPolicy{
+		contractId: h.ContractId(),
+		store:      store,
+		header:     triggerValues.String("idempotency_header", "Idempotency-Key"),
+		required:   triggerValues.Bool("idempotency_required", false),
+		ttl:        triggerValues.Duration("idempotency_ttl", 24*time.Hour),
+		lockTTL:    triggerValues.Duration("idempotency_lock_ttl", time.Minute),
+	}, nil
+}
+
+// applies is false for the safe methods
+func (p *idempotencyPolicy) applies(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	return true
+}
+
+// key returns the store key of the request, the client key is scoped
+// by the route and the principal, the verified client certificate and
+// the Authorization and Cookie headers, empty when the header is missing
+func (p *idempotencyPolicy) key(sr *serviceRoute, request *http.Request, metadata *model.Metadata) (string, error) {
+	value := request.Header.Get(p.header)
+	if len(value) == 0 {
+		if p.required {
+			return "", ErrIdempotencyKeyRequired
+		}
+		return "", nil
+	}
+
+	if len(value) > idempotencyMaxKeyLength {
+		return "", ErrIdempotencyKeyTooLong
+	}
+
+	sum := sha256.New()
+	for _, v := range []string{
+		sr.path,
+		request.Method,
+		metadata.GetClientCertificate().GetFingerprint(),
+		credentialsHash(request),
+		value,
+	} {
+		sum.Write([]byte(v))
+		sum.Write([]byte{0})
+	}
+
+	return idempotencyKeyPrefix + hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// fingerprint identifies the request a key was first used with
+func (p *idempotencyPolicy) fingerprint(request *http.Request, body []byte) string {
+	sum := sha256.New()
+	for _, v := range []string{request.Method, request.URL.EscapedPath(), request.URL.RawQuery} {
+		sum.Write([]byte(v))
+		sum.Write([]byte{0})
+	}
+	sum.Write(body)
+
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (p *idempotencyPolicy) load(ctx context.Context, key string) *idempotencyRecord {
+	var data []byte
+	if err := p.store.Get(ctx, key, &data); err != nil {
+		return nil
+	}
+
+	record := &idempotencyRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil
+	}
+
+	return record
+}
+
+// acquire stores the lock of the key, the existing record is returned
+// when the key is already used
+func (p *idempotencyPolicy) acquire(ctx context.Context, key string, fingerprint string) (*idempotencyRecord, bool, error) {
+	lock := &idempotencyRecord{State: idempotencyProcessing, Fingerprint: fingerprint}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if setter, ok := p.store.(iface.IKVStoreSetNX); ok {
+		acquired, err := setter.SetNX(ctx, key, data, p.lockTTL)
+		if err != nil || acquired {
+			return nil, acquired, err
+		}
+	} else if _, loaded := p.inFlight.LoadOrStore(key, true); !loaded {
+		if record := p.load(ctx, key); record != nil {
+			p.inFlight.Delete(key)
+			return record, false, nil
+		}
+
+		if err = p.store.Set(ctx, key, data, p.lockTTL); err != nil {
+			p.inFlight.Delete(key)
+			return nil, false, err
+		}
+
+		return nil, true, nil
+	}
+
+	// a lock released meanwhile is still reported as in use
+	if record := p.load(ctx, key); record != nil {
+		return record, false, nil
+	}
+
+	return lock, false, nil
+}
+
+// release deletes the lock so that the request can be retried
+func (p *idempotencyPolicy) release(ctx context.Context, key string) {
+	defer p.inFlight.Delete(key)
+
+	if err := p.store.Delete(ctx, key); err != nil {
+		logger.LC(ctx, p.contractId).Error("idempotency release failed", zap.Error(err))
+	}
+}
+
+// finish saves the response of the served input or releases the lock so
+// that the request can be retried, it runs once the service returns
+func (p *idempotencyPolicy) finish(ctx context.Context, key string, fingerprint string, input *model.Metadata, event *model.Event, err error) {
+	if err == nil && event != nil && event.Metadata != nil && p.save(ctx, key, fingerprint, input, event.Metadata, event.Value) {
+		return
+	}
+
+	p.release(ctx, key)
+}
+
+// save replaces the lock with the response of the input, the server
+// errors are not stored and false is returned for them
+func (p *idempotencyPolicy) save(ctx context.Context, key string, fingerprint string, input *model.Metadata, metadata *model.Metadata, value []byte) bool {
+	if metadata.StatusCode >= http.StatusInternalServerError {
+		return false
+	}
+	defer p.inFlight.Delete(key)
+
+	record := &idempotencyRecord{
+		State:       idempotencyDone,
+		Fingerprint: fingerprint,
+		StatusCode:  int(metadata.StatusCode),
+		Header:      storedHeaders(input, metadata),
+		Value:       value,
+	}
+
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = p.store.Set(ctx, key, data, p.ttl)
+	}
+
+	if err != nil {
+		logger.LC(ctx, p.contractId).Error("idempotency store failed", zap.Error(err))
+	}
+
+	return true
+}
+
+// serveIdempotent answers the requests whose key is already used, false
+// is returned when the lock is acquired and the request must be served
+func (h *HTTPServer) serveIdempotent(sr *serviceRoute, key string, fingerprint string, writer http.ResponseWriter, request *http.Request) bool {
+	record, acquired, err := sr.idempotency.acquire(request.Context(), key, fingerprint)
+	if err != nil {
+		h.s500m(request, writer, err)
+		return true
+	}
+
+	if acquired {
+		return false
+	}
+
+	if record.Fingerprint != fingerprint {
+		h.s422m(request, writer, ErrIdempotencyKeyMismatch)
+		return true
+	}
+
+	if record.State != idempotencyDone {
+		h.s409m(request, writer, ErrIdempotencyKeyInUse)
+		return true
+	}
+
+	metadata := &model.Metadata{StatusCode: uint32(record.StatusCode)}
+	for k, v := range record.Header {
+		metadata.SetHeaderValueList(k, v)
+	}
+
+	writer.Header().Set(idempotencyReplayedHeader, "true")
+	h.mResponseStatus.With(routeLabel(request), strconv.Itoa(record.StatusCode)).Inc()
+	h.writeResponse(sr, writer, request, metadata, record.Value)
+
+	return true
+}
@@ -0,0 +1,301 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/capability/memkvstore"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+type testOrderService struct {
+	iface.IService
+	count   int32
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (s *testOrderService) ContractId() string {
+	return "test:order"
+}
+
+func (s *testOrderService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	if s.started != nil {
+		s.started <- struct{}{}
+		<-s.release
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	n := atomic.AddInt32(&s.count, 1)
+	output := model.GenerateOutputEvent(event.Metadata, s.ContractId(), "Created", 201, "text/plain", []byte("order-"+strconv.Itoa(int(n))))
+	output.Metadata.SetHeaderValueList("Location", []string{"/orders/" + strconv.Itoa(int(n))})
+
+	return output, nil
+}
+
+func TestHTTPServer_Idempotency(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testOrderService{}
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":              "POST",
+		"path":                "/orders",
+		"idempotency_enabled": "true",
+	}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		if len(key) != 0 {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	r := do("k1", `{"item":1}`)
+	if r.Code != 201 || r.Body.String() != "order-1" || len(r.Header().Get(idempotencyReplayedHeader)) != 0 {
+		t.Fatalf("first = %d %q %v", r.Code, r.Body.String(), r.Header())
+	}
+
+	r = do("k1", `{"item":1}`)
+	if r.Code != 201 || r.Body.String() != "order-1" || r.Header().Get("Location") != "/orders/1" ||
+		r.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Errorf("replay = %d %q %v", r.Code, r.Body.String(), r.Header())
+	}
+
+	if r = do("k1", `{"item":2}`); r.Code != http.StatusUnprocessableEntity {
+		t.Errorf("mismatch = %d, want 422", r.Code)
+	}
+
+	if r = do(strings.Repeat("k", idempotencyMaxKeyLength+1), ""); r.Code != http.StatusBadRequest {
+		t.Errorf("long key = %d, want 400", r.Code)
+	}
+
+	// requests without a key are served every time
+	if r = do("", `{"item":1}`); r.Body.String() != "order-2" {
+		t.Errorf("without key = %q", r.Body.String())
+	}
+
+	if atomic.LoadInt32(&service.count) != 2 {
+		t.Errorf("count = %d, want 2", service.count)
+	}
+}
+
+func TestHTTPServer_IdempotencyConcurrent(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testOrderService{started: make(chan struct{}), release: make(chan struct{})}
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":               "POST",
+		"path":                 "/orders",
+		"idempotency_enabled":  "true",
+		"idempotency_required": "true",
+	}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		if len(key) != 0 {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if r := do(""); r.Code != http.StatusBadRequest {
+		t.Errorf("missing key = %d, want 400", r.Code)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do("k1") }()
+	<-service.started
+
+	if r := do("k1"); r.Code != http.StatusConflict {
+		t.Errorf("concurrent = %d, want 409", r.Code)
+	}
+
+	close(service.release)
+	if r := <-done; r.Code != 201 {
+		t.Errorf("first = %d, want 201", r.Code)
+	}
+
+	// a failed request releases the key
+	service.started = nil
+	service.err = errors.New("failed")
+	if r := do("k2"); r.Code == 201 {
+		t.Fatalf("failed = %d", r.Code)
+	}
+
+	service.err = nil
+	if r := do("k2"); r.Code != 201 || len(r.Header().Get(idempotencyReplayedHeader)) != 0 {
+		t.Errorf("retry = %d %v", r.Code, r.Header())
+	}
+}
+
+func TestIdempotencyPolicy_Key(t *testing.T) {
+	p := &idempotencyPolicy{header: "Idempotency-Key"}
+	sr := &serviceRoute{path: "/orders"}
+
+	key := func(header map[string]string, fingerprint string) string {
+		request := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		request.Header.Set("Idempotency-Key", "k1")
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+
+		metadata := &model.Metadata{}
+		if len(fingerprint) != 0 {
+			metadata.ClientCertificate = &model.ClientCertificate{Fingerprint: fingerprint}
+		}
+
+		k, err := p.key(sr, request, metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	// the same client key of different principals never collides
+	keyList := []string{
+		key(nil, ""),
+		key(nil, "cert-a"),
+		key(nil, "cert-b"),
+		key(map[string]string{"Authorization": "Bearer alice"}, ""),
+		key(map[string]string{"Cookie": "session=alice"}, ""),
+		key(map[string]string{"Cookie": "session=bob"}, ""),
+	}
+
+	seen := make(map[string]bool)
+	for i, k := range keyList {
+		if seen[k] {
+			t.Errorf("key %d collides", i)
+		}
+		seen[k] = true
+	}
+
+	if key(map[string]string{"Cookie": "session=bob"}, "") != keyList[5] {
+		t.Error("key of the same principal changed")
+	}
+}
+
+func TestHTTPServer_IdempotencyTimeout(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &testOrderService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":              "POST",
+		"path":                "/orders",
+		"request_timeout":     "20ms",
+		"idempotency_enabled": "true",
+	}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(requestId string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		request.Header.Set("Idempotency-Key", "k1")
+		request.Header.Set("Authorization", "Bearer alice")
+		request.Header.Set("X-Request-ID", requestId)
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if r := do("request-1"); r.Code != http.StatusRequestTimeout {
+		t.Fatalf("first = %d, want 408", r.Code)
+	}
+
+	if r := do("request-2"); r.Code != http.StatusConflict {
+		t.Errorf("while serving = %d, want 409", r.Code)
+	}
+
+	// the late response is stored and replayed instead of serving again
+	<-service.started
+	close(service.release)
+
+	var r *httptest.ResponseRecorder
+	for i := 0; i < 100; i++ {
+		if r = do("request-3"); r.Code != http.StatusConflict {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if r.Code != 201 || r.Body.String() != "order-1" || r.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Fatalf("replay = %d %q %v", r.Code, r.Body.String(), r.Header())
+	}
+
+	// the headers of the first request are never replayed
+	if r.Header().Get("X-Request-ID") != "request-3" || len(r.Header().Values("Authorization")) != 0 {
+		t.Errorf("replayed headers = %v", r.Header())
+	}
+
+	if atomic.LoadInt32(&service.count) != 1 {
+		t.Errorf("count = %d, want 1", service.count)
+	}
+}
+
+// testPlainStore hides SetNX of the store
+type testPlainStore struct {
+	iface.IKVStore
+}
+
+func TestIdempotencyPolicy_Finish(t *testing.T) {
+	memory := &memkvstore.MemKVStore{}
+	_ = memory.SetConfigMap(model.ConfigMap{})
+	if err := memory.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &idempotencyPolicy{store: &testPlainStore{IKVStore: memory}, ttl: time.Hour, lockTTL: time.Minute}
+	ctx := context.Background()
+
+	if _, acquired, _ := p.acquire(ctx, "k", "f"); !acquired {
+		t.Fatal("lock not acquired")
+	}
+
+	// a failed request releases the key within the server too
+	p.finish(ctx, "k", "f", &model.Metadata{}, nil, errors.New("failed"))
+	if _, acquired, _ := p.acquire(ctx, "k", "f"); !acquired {
+		t.Fatal("lock not released")
+	}
+
+	output := model.GenerateOutputEvent(&model.Metadata{}, "test", "OK", 200, "text/plain", []byte("done"))
+	p.finish(ctx, "k", "f", &model.Metadata{}, output, nil)
+	if record, acquired, _ := p.acquire(ctx, "k", "f"); acquired || record == nil || record.State != idempotencyDone {
+		t.Errorf("record = %+v %v", record, acquired)
+	}
+
+	if _, found := p.inFlight.Load("k"); found {
+		t.Error("in flight entry kept")
+	}
+}
@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/metrics"
+	"github.com/mkawserm/abesh/model"
+)
+
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var defaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// floatList parses the comma separated list, defaultValue is returned on error
+func floatList(values model.ConfigMap, key string, defaultValue []float64) []float64 {
+	list := trimmedList(values, key)
+	if len(list) == 0 {
+		return defaultValue
+	}
+
+	output := make([]float64, 0, len(list))
+	for _, v := range list {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return defaultValue
+		}
+		output = append(output, f)
+	}
+
+	return output
+}
+
+func statusClass(statusCode int) string {
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+func (h *HTTPServer) SetMetrics(metrics iface.IMetrics) error {
+	h.mMetrics = metrics
+	return nil
+}
+
+func (h *HTTPServer) setupMetrics() {
+	if h.mMetrics == nil {
+		h.mMetrics = metrics.Default().Metrics(h.ContractId())
+	}
+
+	h.mResponseStatus = h.mMetrics.Counter("response_status", "Status of HTTP Response", "path", "status")
+	h.mPanicCounter = h.mMetrics.Counter("panic_counter", "Abesh HTTP Server Panic Counter", "contractid")
+
+	h.mRequestDuration = h.mMetrics.Histogram("request_duration_seconds", "Duration of HTTP Request",
+		h.mDurationBuckets, "route", "method", "status_class")
+	h.mRequestSize = h.mMetrics.Histogram("request_size_bytes", "Size of HTTP Request Body",
+		h.mSizeBuckets, "route", "method", "status_class")
+	h.mResponseSize = h.mMetrics.Histogram("response_size_bytes", "Size of HTTP Response Body",
+		h.mSizeBuckets, "route", "method", "status_class")
+	h.mInFlight = h.mMetrics.Gauge("in_flight_requests", "Number of HTTP Requests in Flight", "route", "method")
+	h.mTimeouts = h.mMetrics.Counter("timeouts_total", "Number of Timed out HTTP Requests", "route", "method")
+	h.mCancellations = h.mMetrics.Counter("cancellations_total", "Number of HTTP Requests Cancelled by the Client", "route", "method")
+	h.mAuthorizerDenials = h.mMetrics.Counter("authorizer_denials_total", "Number of HTTP Requests Denied by the Authorizer", "route", "method")
+	h.mRateLimited = h.mMetrics.Counter("rate_limited_total", "Number of HTTP Requests Rejected by the Rate Limiter", "route", "method")
+}
+
+// observeRequest records the metrics of a served request,
+// requestSize is the number of body bytes read by the server
+func (h *HTTPServer) observeRequest(sr *serviceRoute, request *http.Request, rw *responseWriter, requestSize int64, timerStart time.Time) {
+	class := statusClass(rw.status())
+
+	h.mRequestDuration.With(sr.path, request.Method, class).Observe(time.Since(timerStart).Seconds())
+	h.mRequestSize.With(sr.path, request.Method, class).Observe(float64(requestSize))
+	h.mResponseSize.With(sr.path, request.Method, class).Observe(float64(rw.size))
+}
+
+// setupMetricsListener exposes the metrics on a separate listener when
+// metrics_port is configured, on the public listener otherwise
+func (h *HTTPServer) setupMetricsListener() {
+	if len(strings.TrimSpace(h.mMetricsPort)) == 0 {
+		h.AddHandler(h.mMetricPath, promhttp.Handler())
+		logger.L(h.ContractId()).Info("metrics enabled", zap.String("metric_path", h.mMetricPath))
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(h.mMetricPath, promhttp.Handler())
+
+	h.mMetricsServer = &http.Server{
+		Addr:    h.mMetricsHost + ":" + h.mMetricsPort,
+		Handler: mux,
+	}
+
+	logger.L(h.ContractId()).Info("metrics enabled",
+		zap.String("metric_path", h.mMetricPath),
+		zap.String("metrics_address", h.mMetricsServer.Addr))
+}
+
+func (h *HTTPServer) startMetricsListener() {
+	if h.mMetricsServer == nil {
+		return
+	}
+
+	go func() {
+		if err := h.mMetricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			logger.L(h.ContractId()).Error(err.Error(),
+				zap.String("version", h.Version()),
+				zap.String("name", h.Name()),
+				zap.String("contract_id", h.ContractId()))
+		}
+	}()
+}
@@ -0,0 +1,180 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/metrics"
+	"github.com/mkawserm/abesh/model"
+)
+
+type testEchoService struct {
+	iface.IService
+}
+
+func (s *testEchoService) ContractId() string {
+	return "test:echo"
+}
+
+func (s *testEchoService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain", event.Value), nil
+}
+
+type testRequestIdService struct {
+	iface.IService
+}
+
+func (s *testRequestIdService) ContractId() string {
+	return "test:request_id"
+}
+
+func (s *testRequestIdService) Serve(ctx context.Context, event *model.Event) (*model.Event, error) {
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain",
+		[]byte(event.Metadata.UniqueId+"|"+logger.RequestId(ctx))), nil
+}
+
+type testDenyAuthorizer struct {
+	iface.IAuthorizer
+}
+
+func (a *testDenyAuthorizer) IsAuthorized(_ string, _ *model.Metadata) bool {
+	return false
+}
+
+func TestHTTPServer_Metrics(t *testing.T) {
+	memory := metrics.NewMemory(metrics.DefaultMaxCardinality)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetMetrics(memory.Metrics(h.ContractId()))
+	_ = h.Setup()
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "POST", "path": "/echo/{id}"}, &testEchoService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddService(&testDenyAuthorizer{}, "", model.ConfigMap{"method": "GET", "path": "/private"}, &testEchoService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/echo/"+id, strings.NewReader("hello")))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/private", nil))
+
+	if v := memory.Observations("abesh_httpserver_request_duration_seconds", "/echo/{id}", "POST", "2xx"); len(v) != 2 {
+		t.Errorf("request duration observations = %d, want 2", len(v))
+	}
+
+	if v := memory.Observations("abesh_httpserver_request_size_bytes", "/echo/{id}", "POST", "2xx"); len(v) != 2 || v[0] != 5 {
+		t.Errorf("request size observations = %v", v)
+	}
+
+	if v := memory.Value("abesh_httpserver_in_flight_requests", "/echo/{id}", "POST"); v != 0 {
+		t.Errorf("in flight = %v, want 0", v)
+	}
+
+	if v := memory.Value("abesh_httpserver_authorizer_denials_total", "/private", "GET"); v != 1 {
+		t.Errorf("authorizer denials = %v, want 1", v)
+	}
+
+	if v := memory.Value("abesh_httpserver_response_status", "/private", "403"); v != 1 {
+		t.Errorf("response status = %v, want 1", v)
+	}
+}
+
+func TestHTTPServer_Cancellation(t *testing.T) {
+	memory := metrics.NewMemory(metrics.DefaultMaxCardinality)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetMetrics(memory.Metrics(h.ContractId()))
+	_ = h.Setup()
+
+	service := &testOrderService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(service.release)
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "POST", "path": "/orders"}, service); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-service.started
+		cancel()
+	}()
+
+	recorder := httptest.NewRecorder()
+	h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil).WithContext(ctx))
+
+	if recorder.Code != 499 {
+		t.Errorf("status = %d, want 499", recorder.Code)
+	}
+
+	if v := memory.Value("abesh_httpserver_cancellations_total", "/orders", "POST"); v != 1 {
+		t.Errorf("cancellations = %v, want 1", v)
+	}
+
+	if v := memory.Value("abesh_httpserver_timeouts_total", "/orders", "POST"); v != 0 {
+		t.Errorf("timeouts = %v, want 0", v)
+	}
+}
+
+func TestHTTPServer_RequestId(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/id"}, &testRequestIdService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/id", nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// the incoming id, then the trace id, is echoed and given to the service
+	for _, tc := range []struct {
+		header map[string]string
+		want   string
+	}{
+		{map[string]string{"X-Request-ID": "req-1"}, "req-1"},
+		{map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+	} {
+		r := get(tc.header)
+		if id := r.Header().Get("X-Request-ID"); id != tc.want || r.Body.String() != tc.want+"|"+tc.want {
+			t.Errorf("request id = %s %q, want %s", id, r.Body.String(), tc.want)
+		}
+	}
+
+	// a new id is generated for every request without one
+	first, second := get(nil), get(map[string]string{"X-Request-ID": "bad id"})
+	for _, r := range []*httptest.ResponseRecorder{first, second} {
+		if id := r.Header().Get("X-Request-ID"); len(id) != 32 || r.Body.String() != id+"|"+id {
+			t.Errorf("generated request id = %s %q", id, r.Body.String())
+		}
+	}
+
+	if first.Header().Get("X-Request-ID") == second.Header().Get("X-Request-ID") {
+		t.Error("generated request ids are equal")
+	}
+}
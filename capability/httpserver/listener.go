@@ -0,0 +1,279 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/mkawserm/abesh/certmanager"
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrUnknownListenerType = errors.New("unknown listener type")
+var ErrListenerCertificateRequired = errors.New("listener certificate required")
+var ErrListenerSocketPathRequired = errors.New("listener socket path required")
+var ErrListenerSocketPathNotSocket = errors.New("listener socket path is not a socket")
+var ErrListenerSocketInUse = errors.New("listener socket address already in use")
+
+const (
+	listenerHTTP     = "http"
+	listenerHTTPS    = "https"
+	listenerRedirect = "redirect"
+	listenerUnix     = "unix"
+	listenerH2C      = "h2c"
+)
+
+// listener is a single address the server is bound to, every listener
+// has its own http.Server and timeouts
+type listener struct {
+	name        string
+	kind        string
+	address     string
+	certManager *certmanager.CertManager
+	server      *http.Server
+}
+
+type listenerTimeouts struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+func parseListenerTimeouts(values model.ConfigMap, prefix string, defaults listenerTimeouts) listenerTimeouts {
+	return listenerTimeouts{
+		read:       values.Duration(prefix+"read_timeout", defaults.read),
+		readHeader: values.Duration(prefix+"read_header_timeout", defaults.readHeader),
+		write:      values.Duration(prefix+"write_timeout", defaults.write),
+		idle:       values.Duration(prefix+"idle_timeout", defaults.idle),
+	}
+}
+
+// listenerNameList returns the names of the listener_<name>_type values
+func listenerNameList(values model.ConfigMap) []string {
+	var nameList []string
+	for key := range values {
+		if strings.HasPrefix(key, "listener_") && strings.HasSuffix(key, "_type") {
+			if name := strings.TrimSuffix(strings.TrimPrefix(key, "listener_"), "_type"); len(name) != 0 {
+				nameList = append(nameList, name)
+			}
+		}
+	}
+	sort.Strings(nameList)
+
+	return nameList
+}
+
+// newListenerList builds the listeners from the listener_<name>_<key> values,
+// without listeners the host, port, cert_file and key_file values make the
+// only listener
+func (h *HTTPServer) newListenerList(handler http.Handler) ([]*listener, error) {
+	defaults := parseListenerTimeouts(h.mValues, "", listenerTimeouts{
+		read:       30 * time.Second,
+		readHeader: 10 * time.Second,
+		write:      60 * time.Second,
+		idle:       120 * time.Second,
+	})
+
+	nameList := listenerNameList(h.mValues)
+	if len(nameList) == 0 {
+		l := &listener{
+			name:    "default",
+			kind:    listenerHTTP,
+			address: h.mHost + ":" + h.mPort,
+			server:  newServer(h.mHost+":"+h.mPort, handler, defaults),
+		}
+
+		if len(h.mCertFile) != 0 && len(h.mKeyFile) != 0 {
+			l.kind = listenerHTTPS
+			if err := l.setupTLS(h.mValues); err != nil {
+				return nil, err
+			}
+		}
+
+		return []*listener{l}, nil
+	}
+
+	// redirect listeners point to the first https listener by default
+	var httpsPort string
+	for _, name := range nameList {
+		prefix := "listener_" + name + "_"
+		if h.mValues.String(prefix+"type", "") == listenerHTTPS {
+			httpsPort = h.mValues.String(prefix+"port", "443")
+			break
+		}
+	}
+
+	listenerList := make([]*listener, 0, len(nameList))
+	for _, name := range nameList {
+		prefix := "listener_" + name + "_"
+
+		l := &listener{
+			name:    name,
+			kind:    strings.ToLower(h.mValues.String(prefix+"type", "")),
+			address: h.mValues.String(prefix+"host", h.mHost) + ":" + h.mValues.String(prefix+"port", h.mPort),
+		}
+		timeouts := parseListenerTimeouts(h.mValues, prefix, defaults)
+		listenerHandler := handler
+
+		switch l.kind {
+		case listenerHTTP:
+		case listenerHTTPS:
+		case listenerRedirect:
+			listenerHandler = redirectHandler(h.mValues.String(prefix+"redirect_port", httpsPort))
+		case listenerUnix:
+			if l.address = h.mValues.String(prefix+"path", ""); len(l.address) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrListenerSocketPathRequired, name)
+			}
+		case listenerH2C:
+			listenerHandler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: timeouts.idle})
+		default:
+			return nil, fmt.Errorf("%w: %s (%s)", ErrUnknownListenerType, l.kind, name)
+		}
+
+		l.server = newServer(l.address, listenerHandler, timeouts)
+
+		if l.kind == listenerHTTPS {
+			if err := l.setupTLS(prefixedValues(h.mValues, prefix)); err != nil {
+				if errors.Is(err, certmanager.ErrNoCertificate) {
+					return nil, fmt.Errorf("%w: %s", ErrListenerCertificateRequired, name)
+				}
+				return nil, err
+			}
+		}
+
+		listenerList = append(listenerList, l)
+	}
+
+	return listenerList, nil
+}
+
+// prefixedValues overlays the values with the prefix, without the prefix,
+// over the server values
+func prefixedValues(values model.ConfigMap, prefix string) model.ConfigMap {
+	output := make(model.ConfigMap, len(values))
+	for k, v := range values {
+		output[k] = v
+	}
+
+	for k, v := range values {
+		if strings.HasPrefix(k, prefix) {
+			output[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+
+	return output
+}
+
+// setupTLS serves the certificates through a certificate manager, the
+// files are reloaded without a restart
+func (l *listener) setupTLS(values model.ConfigMap) error {
+	config, err := certmanager.ConfigFromValues(values, "")
+	if err != nil {
+		return err
+	}
+
+	if l.certManager, err = certmanager.New(config); err != nil {
+		return err
+	}
+
+	l.server.TLSConfig = l.certManager.ServerTLSConfig()
+	return nil
+}
+
+func newServer(address string, handler http.Handler, timeouts listenerTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadTimeout:       timeouts.read,
+		ReadHeaderTimeout: timeouts.readHeader,
+		WriteTimeout:      timeouts.write,
+		IdleTimeout:       timeouts.idle,
+	}
+}
+
+// redirectHandler redirects to https on the given port, the standard
+// port is left out of the location
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if len(port) != 0 && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func (l *listener) listen() (net.Listener, error) {
+	if l.kind == listenerUnix {
+		// a stale socket file from a previous run blocks the bind, any
+		// other file at the path is never removed
+		if fi, err := os.Lstat(l.address); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrListenerSocketPathNotSocket, l.address)
+			}
+			if err = removeStaleSocket(l.address); err != nil {
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", l.address)
+	}
+
+	return net.Listen("tcp", l.address)
+}
+
+// removeStaleSocket removes the socket when nothing accepts connections
+// on it, the socket of a running process is never taken over
+func removeStaleSocket(path string) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%w: %s", ErrListenerSocketInUse, path)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.ENOENT) {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// serve blocks until the listener is shut down, nil is returned then
+func (l *listener) serve() error {
+	ln, err := l.listen()
+	if err != nil {
+		return err
+	}
+
+	if l.kind == listenerHTTPS {
+		err = l.server.ServeTLS(ln, "", "")
+	} else {
+		err = l.server.Serve(ln)
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,279 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+func writeTestCertificate(t *testing.T, dir string, dnsName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, dnsName+".pem")
+	keyFile := filepath.Join(dir, dnsName+".key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestHTTPServer_ListenerList(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "localhost")
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"read_timeout":                        "5s",
+		"listener_public_type":                "https",
+		"listener_public_port":                "8443",
+		"listener_public_cert_file":           certFile,
+		"listener_public_key_file":            keyFile,
+		"listener_public_tls_min_version":     "1.3",
+		"listener_public_read_header_timeout": "2s",
+		"listener_plain_type":                 "redirect",
+		"listener_plain_port":                 "8080",
+		"listener_mesh_type":                  "h2c",
+		"listener_mesh_port":                  "9090",
+	})
+
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.mListenerList) != 3 {
+		t.Fatalf("listeners = %d, want 3", len(h.mListenerList))
+	}
+
+	listenerMap := make(map[string]*listener)
+	for _, l := range h.mListenerList {
+		listenerMap[l.name] = l
+	}
+
+	public := listenerMap["public"]
+	if public.kind != listenerHTTPS || public.address != "0.0.0.0:8443" || public.certManager == nil {
+		t.Errorf("public = %+v", public)
+	}
+
+	if public.server.TLSConfig == nil || public.server.TLSConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("public tls config = %+v", public.server.TLSConfig)
+	}
+
+	if public.server.ReadTimeout != 5*time.Second || public.server.ReadHeaderTimeout != 2*time.Second || public.server.IdleTimeout != 120*time.Second {
+		t.Errorf("public timeouts = %v %v %v", public.server.ReadTimeout, public.server.ReadHeaderTimeout, public.server.IdleTimeout)
+	}
+
+	recorder := httptest.NewRecorder()
+	listenerMap["plain"].server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com:8080/a?b=1", nil))
+	if recorder.Code != http.StatusPermanentRedirect || recorder.Header().Get("Location") != "https://example.com:8443/a?b=1" {
+		t.Errorf("redirect = %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	invalid := &HTTPServer{}
+	_ = invalid.SetConfigMap(model.ConfigMap{"listener_a_type": "https"})
+	if err := invalid.Setup(); !errors.Is(err, ErrListenerCertificateRequired) {
+		t.Errorf("err = %v, want %v", err, ErrListenerCertificateRequired)
+	}
+}
+
+func TestHTTPServer_UnixListener(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "abesh.sock")
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"health_path":         "/health",
+		"listener_sock_type":  "unix",
+		"listener_sock_path":  socketPath,
+		"listener_h2c_type":   "h2c",
+		"listener_h2c_host":   "127.0.0.1",
+		"listener_h2c_port":   "0",
+		"listener_plain_type": "http",
+		"listener_plain_host": "127.0.0.1",
+		"listener_plain_port": "0",
+	})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- h.Start(context.Background()) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+
+	var response *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if response, err = client.Get("http://unix/health"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	if err = h.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = <-done; err != nil {
+		t.Errorf("start = %v", err)
+	}
+}
+
+func TestListener_UnixStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// a regular file at the socket path is kept
+	filePath := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(filePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &listener{kind: listenerUnix, address: filePath}
+	if _, err := l.listen(); !errors.Is(err, ErrListenerSocketPathNotSocket) {
+		t.Errorf("listen() error = %v, want %v", err, ErrListenerSocketPathNotSocket)
+	}
+
+	if data, _ := os.ReadFile(filePath); string(data) != "data" {
+		t.Errorf("file = %q", data)
+	}
+
+	// a stale socket is replaced
+	socketPath := filepath.Join(dir, "abesh.sock")
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	l = &listener{kind: listenerUnix, address: socketPath}
+	ln, err := l.listen()
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+
+	// the socket of a live listener is kept
+	if _, err = (&listener{kind: listenerUnix, address: socketPath}).listen(); !errors.Is(err, ErrListenerSocketInUse) {
+		t.Errorf("listen() error = %v, want %v", err, ErrListenerSocketInUse)
+	}
+
+	if conn, err := net.Dial("unix", socketPath); err != nil {
+		t.Errorf("live listener lost its socket: %v", err)
+	} else {
+		_ = conn.Close()
+	}
+	_ = ln.Close()
+}
+
+type testCertService struct {
+	iface.IService
+}
+
+func (s *testCertService) ContractId() string {
+	return "test:cert"
+}
+
+func (s *testCertService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain",
+		[]byte(event.Metadata.GetClientCertificate().GetDnsNames()[0])), nil
+}
+
+func TestHTTPServer_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestCertificate(t, dir, "localhost")
+	clientCert, clientKey := writeTestCertificate(t, dir, "client.local")
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"cert_file":      serverCert,
+		"key_file":       serverKey,
+		"client_ca_file": clientCert,
+	})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/whoami"}, &testCertService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(h.mRouter)
+	server.TLS = h.mListenerList[0].server.TLSConfig
+	server.StartTLS()
+	defer server.Close()
+
+	certificate, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{certificate},
+	}}}
+
+	response, err := client.Get(server.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "client.local" {
+		t.Errorf("response = %d %s", response.StatusCode, body)
+	}
+
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if _, err = anonymous.Get(server.URL + "/whoami"); err == nil {
+		t.Error("request without client certificate succeeded")
+	}
+}
@@ -0,0 +1,252 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrRateLimiterNotFound = errors.New("rate limiter not found")
+var ErrUnknownRateLimitKey = errors.New("unknown rate limit key")
+var ErrInvalidTrustedProxy = errors.New("invalid trusted proxy")
+
+// rateLimitKeyFunc returns a part of the rate limit key
+type rateLimitKeyFunc func(sr *serviceRoute, request *http.Request, metadata *model.Metadata) string
+
+// rateLimitPolicy takes a request from the rate_limiter capability, the
+// rate_limit_key trigger value is a comma separated list of:
+//
+//	ip             client address (default)
+//	header:<name>  header value, the client address without the header
+//	principal      verified client certificate or Authorization header
+//	route          route pattern
+//
+// the header value and the Authorization header are used on the routes
+// with an authorizer only, anyone can send a new value otherwise, the
+// routes sharing a limiter share the limits unless route is a part of
+// the key, the keys with only ip and route are taken before the
+// authorizer so that the denied requests are limited too
+type rateLimitPolicy struct {
+	limiter         iface.IRateLimiter
+	keyFuncList     []rateLimitKeyFunc
+	afterAuthorizer bool
+}
+
+func (h *HTTPServer) newRateLimitPolicy(triggerValues model.ConfigMap) (*rateLimitPolicy, error) {
+	contractId := triggerValues.String("rate_limiter", "")
+	if len(contractId) == 0 {
+		return nil, nil
+	}
+
+	p := &rateLimitPolicy{}
+	if h.mCapabilityRegistry != nil {
+		p.limiter, _ = h.mCapabilityRegistry.Capability(contractId).(iface.IRateLimiter)
+	}
+
+	if p.limiter == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRateLimiterNotFound, contractId)
+	}
+
+	for _, v := range triggerValues.StringList("rate_limit_key", ",", []string{"ip"}) {
+		name := strings.TrimSpace(v)
+		keyFunc, err := h.newRateLimitKeyFunc(name)
+		if err != nil {
+			return nil, err
+		}
+		p.keyFuncList = append(p.keyFuncList, keyFunc)
+		p.afterAuthorizer = p.afterAuthorizer || (name != "ip" && name != "route")
+	}
+
+	return p, nil
+}
+
+func (h *HTTPServer) newRateLimitKeyFunc(name string) (rateLimitKeyFunc, error) {
+	switch {
+	case name == "ip":
+		return func(_ *serviceRoute, request *http.Request, _ *model.Metadata) string {
+			return "ip=" + h.clientAddress(request)
+		}, nil
+	case name == "principal":
+		return h.principalKey, nil
+	case name == "route":
+		return func(sr *serviceRoute, _ *http.Request, _ *model.Metadata) string {
+			return "route=" + sr.host + sr.path
+		}, nil
+	case strings.HasPrefix(name, "header:") && len(name) > len("header:"):
+		header := name[len("header:"):]
+		return func(sr *serviceRoute, request *http.Request, _ *model.Metadata) string {
+			if v := request.Header.Get(header); len(v) != 0 && sr.authorizer != nil {
+				return "header=" + v
+			}
+			return "ip=" + h.clientAddress(request)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownRateLimitKey, name)
+}
+
+// principalKey uses the verified client certificate fingerprint or the
+// hash of the authorized credentials, the client address without them
+func (h *HTTPServer) principalKey(sr *serviceRoute, request *http.Request, metadata *model.Metadata) string {
+	if fingerprint := metadata.GetClientCertificate().GetFingerprint(); len(fingerprint) != 0 {
+		return "cert=" + fingerprint
+	}
+
+	if authorization := request.Header.Get("Authorization"); len(authorization) != 0 && sr.authorizer != nil {
+		sum := sha256.Sum256([]byte(authorization))
+		return "auth=" + hex.EncodeToString(sum[:16])
+	}
+
+	return "ip=" + h.clientAddress(request)
+}
+
+// parseTrustedProxyList parses the trusted_proxies value, a comma
+// separated list of addresses and networks (ex: 10.0.0.0/8, ::1)
+func parseTrustedProxyList(values model.ConfigMap) ([]*net.IPNet, error) {
+	var networkList []*net.IPNet
+	for _, v := range trimmedList(values, "trusted_proxies") {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidTrustedProxy, v)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networkList = append(networkList, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTrustedProxy, v)
+		}
+		networkList = append(networkList, network)
+	}
+
+	return networkList, nil
+}
+
+func (h *HTTPServer) trustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range h.mTrustedProxyList {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerAddress is the address of the connection without the port
+func peerAddress(request *http.Request) string {
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+
+	return request.RemoteAddr
+}
+
+// clientAddress is the peer address, the X-Forwarded-For addresses
+// appended by the trusted proxies are walked from the right to the
+// first untrusted one
+func (h *HTTPServer) clientAddress(request *http.Request) string {
+	address := peerAddress(request)
+	if !h.trustedProxy(address) {
+		return address
+	}
+
+	var forwardedList []string
+	for _, v := range request.Header.Values("X-Forwarded-For") {
+		forwardedList = append(forwardedList, strings.Split(v, ",")...)
+	}
+
+	for i := len(forwardedList) - 1; i >= 0; i-- {
+		forwarded := strings.TrimSpace(forwardedList[i])
+		if net.ParseIP(forwarded) == nil {
+			break
+		}
+
+		address = forwarded
+		if !h.trustedProxy(address) {
+			break
+		}
+	}
+
+	return address
+}
+
+func (p *rateLimitPolicy) key(sr *serviceRoute, request *http.Request, metadata *model.Metadata) string {
+	partList := make([]string, 0, len(p.keyFuncList))
+	for _, keyFunc := range p.keyFuncList {
+		partList = append(partList, keyFunc(sr, request, metadata))
+	}
+
+	return strings.Join(partList, "|")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// writeRateLimitHeaders writes the RateLimit header fields, Retry-After
+// is at least a second
+func writeRateLimitHeaders(writer http.ResponseWriter, l *model.RateLimit) {
+	writer.Header().Set("RateLimit-Limit", strconv.Itoa(l.Limit))
+	writer.Header().Set("RateLimit-Remaining", strconv.Itoa(l.Remaining))
+	writer.Header().Set("RateLimit-Reset", seconds(l.Reset))
+	if l.Burst > l.Limit {
+		writer.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s;burst=%d", l.Limit, seconds(l.Window), l.Burst))
+	} else {
+		writer.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", l.Limit, seconds(l.Window)))
+	}
+
+	if !l.Allowed {
+		retryAfter := seconds(l.RetryAfter)
+		if retryAfter == "0" {
+			retryAfter = "1"
+		}
+		writer.Header().Set("Retry-After", retryAfter)
+	}
+}
+
+// serveRateLimited answers the limited requests, the requests pass
+// when the limiter fails
+func (h *HTTPServer) serveRateLimited(sr *serviceRoute, writer http.ResponseWriter, request *http.Request, metadata *model.Metadata) bool {
+	l, err := sr.rateLimit.limiter.Take(request.Context(), sr.rateLimit.key(sr, request, metadata))
+	if err != nil {
+		logger.LC(request.Context(), h.ContractId()).Error("rate limiter failed", zap.Error(err))
+		return false
+	}
+
+	writeRateLimitHeaders(writer, l)
+	if l.Allowed {
+		return false
+	}
+
+	h.mRateLimited.With(sr.path, request.Method).Inc()
+	h.writeServiceError(sr, request, writer, metadata,
+		abeshErrors.RateLimited("", "too many requests", nil))
+
+	return true
+}
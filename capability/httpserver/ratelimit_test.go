@@ -0,0 +1,226 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mkawserm/abesh/capability/ratelimiter"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+type testAllowAuthorizer struct {
+	iface.IAuthorizer
+}
+
+func (a *testAllowAuthorizer) IsAuthorized(_ string, _ *model.Metadata) bool {
+	return true
+}
+
+func TestHTTPServer_RateLimit(t *testing.T) {
+	limiter := &ratelimiter.RateLimiter{}
+	_ = limiter.SetConfigMap(model.ConfigMap{"limit": "2", "period": "1m"})
+	if err := limiter.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	capabilityRegistry := registry.NewCapabilityRegistry()
+	capabilityRegistry.RegisterCapability("limiter", limiter)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetCapabilityRegistry(capabilityRegistry)
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/items", "/public"} {
+		var authorizer iface.IAuthorizer
+		if path == "/items" {
+			authorizer = &testAllowAuthorizer{}
+		}
+
+		if err := h.AddService(authorizer, "", model.ConfigMap{
+			"method":         "GET",
+			"path":           path,
+			"rate_limiter":   "limiter",
+			"rate_limit_key": "route,header:X-Api-Key",
+		}, &testCountingService{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-Api-Key", key)
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+	do := func(key string) *httptest.ResponseRecorder {
+		return get("/items", key)
+	}
+
+	r := do("a")
+	if r.Code != http.StatusOK || r.Header().Get("RateLimit-Limit") != "2" ||
+		r.Header().Get("RateLimit-Remaining") != "1" || r.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("first = %d %v", r.Code, r.Header())
+	}
+
+	do("a")
+	r = do("a")
+	if r.Code != http.StatusTooManyRequests || r.Header().Get("Retry-After") != "30" || r.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("limited = %d %v", r.Code, r.Header())
+	}
+
+	response := &model.HTTPResponseModel{}
+	if err := json.Unmarshal(r.Body.Bytes(), response); err != nil || len(response.Message) == 0 {
+		t.Errorf("response = %q %v", r.Body.String(), err)
+	}
+
+	// every api key has its own limit
+	if r = do("b"); r.Code != http.StatusOK {
+		t.Errorf("other key = %d", r.Code)
+	}
+
+	// without an authorizer the api keys share the limit of the address
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if r = get("/public", string(rune('c'+i))); r.Code != want {
+			t.Errorf("public %d = %d, want %d", i, r.Code, want)
+		}
+	}
+}
+
+func TestHTTPServer_RateLimitBeforeAuthorizer(t *testing.T) {
+	limiter := &ratelimiter.RateLimiter{}
+	_ = limiter.SetConfigMap(model.ConfigMap{"limit": "1", "period": "1m"})
+	if err := limiter.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	capabilityRegistry := registry.NewCapabilityRegistry()
+	capabilityRegistry.RegisterCapability("limiter", limiter)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetCapabilityRegistry(capabilityRegistry)
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, key := range map[string]string{"/ip": "ip,route", "/principal": "principal,route"} {
+		if err := h.AddService(&testDenyAuthorizer{}, "", model.ConfigMap{
+			"method":         "GET",
+			"path":           path,
+			"rate_limiter":   "limiter",
+			"rate_limit_key": key,
+		}, &testCountingService{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// the denied requests take from the address limit
+	for i, want := range []int{http.StatusForbidden, http.StatusTooManyRequests} {
+		if code := get("/ip"); code != want {
+			t.Errorf("ip %d = %d, want %d", i, code, want)
+		}
+	}
+
+	// the principal limit is taken after the authorizer only
+	for i := 0; i < 2; i++ {
+		if code := get("/principal"); code != http.StatusForbidden {
+			t.Errorf("principal %d = %d, want %d", i, code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestHTTPServer_RateLimitBurst(t *testing.T) {
+	limiter := &ratelimiter.RateLimiter{}
+	_ = limiter.SetConfigMap(model.ConfigMap{"limit": "2", "period": "1m", "burst": "5"})
+	if err := limiter.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	capabilityRegistry := registry.NewCapabilityRegistry()
+	capabilityRegistry.RegisterCapability("limiter", limiter)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetCapabilityRegistry(capabilityRegistry)
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/items", "rate_limiter": "limiter"}, &testCountingService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.mRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if recorder.Header().Get("RateLimit-Limit") != "2" || recorder.Header().Get("RateLimit-Policy") != "2;w=60;burst=5" {
+		t.Errorf("headers = %v", recorder.Header())
+	}
+}
+
+func TestHTTPServer_ClientAddress(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"trusted_proxies": "10.0.0.0/8, 192.168.1.1"})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"203.0.113.1:1234", "198.51.100.1", "203.0.113.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		// a spoofed address left of an untrusted one is never used
+		{"10.0.0.1:1234", "1.1.1.1, 198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"192.168.1.1:1234", "garbage, 10.0.0.2", "10.0.0.2"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = tc.remoteAddr
+		if len(tc.forwardedFor) != 0 {
+			request.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+
+		if address := h.clientAddress(request); address != tc.want {
+			t.Errorf("%s %q = %s, want %s", tc.remoteAddr, tc.forwardedFor, address, tc.want)
+		}
+	}
+
+	h = &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"trusted_proxies": "10.0.0.0/33"})
+	if err := h.Setup(); !errors.Is(err, ErrInvalidTrustedProxy) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidTrustedProxy)
+	}
+}
+
+func TestHTTPServer_RateLimitConfig(t *testing.T) {
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.SetCapabilityRegistry(registry.NewCapabilityRegistry())
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/a", "rate_limiter": "missing"}, &testCountingService{})
+	if !errors.Is(err, ErrRateLimiterNotFound) {
+		t.Errorf("error = %v, want %v", err, ErrRateLimiterNotFound)
+	}
+
+	if _, err = h.newRateLimitKeyFunc("cookie"); !errors.Is(err, ErrUnknownRateLimitKey) {
+		t.Errorf("error = %v, want %v", err, ErrUnknownRateLimitKey)
+	}
+}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a size based rotating log file, path.1 is the
+// most recent backup and the backups above maxBackups are removed
+type rotatingFile struct {
+	mMutex      sync.Mutex
+	mPath       string
+	mMaxSize    int64
+	mMaxBackups int
+	mFile       *os.File
+	mSize       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{mPath: path, mMaxSize: maxSize, mMaxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.mPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	r.mFile = file
+	r.mSize = info.Size()
+
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.mFile.Close(); err != nil {
+		return err
+	}
+
+	if r.mMaxBackups <= 0 {
+		if err := os.Remove(r.mPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.mPath, r.mMaxBackups))
+	for index := r.mMaxBackups - 1; index >= 1; index-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.mPath, index), fmt.Sprintf("%s.%d", r.mPath, index+1))
+	}
+
+	if err := os.Rename(r.mPath, r.mPath+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(data []byte) (int, error) {
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
+
+	if r.mMaxSize > 0 && r.mSize > 0 && r.mSize+int64(len(data)) > r.mMaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.mFile.Write(data)
+	r.mSize += int64(n)
+
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
+
+	return r.mFile.Close()
+}
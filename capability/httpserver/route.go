@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+// serviceRoute keeps the per trigger configuration of a service
+type serviceRoute struct {
+	methodList []string
+	path       string
+	host       string
+
+	service              iface.IService
+	authorizer           iface.IAuthorizer
+	authorizerExpression string
+
+	requestTimeout time.Duration
+	maxBodySize    int64
+	bulkhead       *bulkhead
+
+	cors        *corsPolicy
+	security    *securityPolicy
+	compression *compressionPolicy
+
+	decompressionEnabled bool
+	maxDecompressedSize  int64
+
+	errorStatusMap map[string]int
+	debug          bool
+
+	validator   *requestValidator
+	cache       *responseCache
+	idempotency *idempotencyPolicy
+	rateLimit   *rateLimitPolicy
+}
+
+func (h *HTTPServer) newServiceRoute(
+	authorizer iface.IAuthorizer,
+	authorizerExpression string,
+	triggerValues model.ConfigMap,
+	service iface.IService) (*serviceRoute, error) {
+
+	var method string
+	var path string
+	var methodList []string
+
+	if method = triggerValues.String("method", ""); len(method) == 0 {
+		return nil, ErrMethodNotDefined
+	}
+
+	method = strings.ToUpper(strings.TrimSpace(method))
+	methodList = strings.Split(method, ",")
+	for index := range methodList {
+		methodList[index] = strings.TrimSpace(methodList[index])
+	}
+
+	if len(methodList) > 0 {
+		sort.Strings(methodList)
+	}
+
+	if path = triggerValues.String("path", ""); len(path) == 0 {
+		return nil, ErrPathNotDefined
+	}
+
+	path = strings.TrimSpace(path)
+
+	sr := &serviceRoute{
+		methodList:           methodList,
+		path:                 path,
+		host:                 strings.TrimSpace(triggerValues.String("host", "")),
+		service:              service,
+		authorizer:           authorizer,
+		authorizerExpression: authorizerExpression,
+		requestTimeout:       triggerValues.Duration("request_timeout", h.mRequestTimeout),
+		maxBodySize:          triggerValues.Int64("max_body_size", h.mMaxBodySize),
+	}
+
+	policyValues := overlay(h.mValues, triggerValues, "cors_", "security_", "compression_")
+
+	var err error
+	if sr.cors, err = newCORSPolicy(policyValues); err != nil {
+		return nil, err
+	}
+	sr.security = newSecurityPolicy(policyValues)
+	sr.compression = newCompressionPolicy(policyValues)
+
+	sr.decompressionEnabled = triggerValues.Bool("decompression_enabled", h.mDecompressionEnabled)
+	sr.maxDecompressedSize = triggerValues.Int64("max_decompressed_size", h.mMaxDecompressedSize)
+
+	errorValues := overlay(h.mValues, triggerValues, "error_status_map")
+	if sr.errorStatusMap, err = parseErrorStatusMap(errorValues); err != nil {
+		return nil, err
+	}
+	sr.debug = triggerValues.Bool("debug", h.mDebug)
+
+	if sr.validator, err = newRequestValidator(triggerValues); err != nil {
+		return nil, err
+	}
+
+	if sr.cache, err = h.newResponseCache(triggerValues, len(sr.host) != 0); err != nil {
+		return nil, err
+	}
+
+	if sr.idempotency, err = h.newIdempotencyPolicy(triggerValues); err != nil {
+		return nil, err
+	}
+
+	if sr.rateLimit, err = h.newRateLimitPolicy(triggerValues); err != nil {
+		return nil, err
+	}
+
+	// zero max_concurrency disables the bulkhead
+	if maxConcurrency := triggerValues.Int("max_concurrency", 0); maxConcurrency > 0 {
+		sr.bulkhead = newBulkhead(maxConcurrency,
+			triggerValues.Int("max_queue", 0),
+			triggerValues.Duration("queue_timeout", sr.requestTimeout))
+	}
+
+	return sr, nil
+}
+
+// preflightHandler is nil when CORS is not enabled for the route
+func (sr *serviceRoute) preflightHandler() routeHandlerFunc {
+	if sr.cors == nil {
+		return nil
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request, _ map[string]string) {
+		sr.cors.preflight(writer, request, sr.methodList)
+	}
+}
+
+// writePolicyHeaders writes the CORS and security headers, these are
+// written before serving so that error responses carry them too
+func (sr *serviceRoute) writePolicyHeaders(writer http.ResponseWriter, request *http.Request) {
+	if sr.cors != nil {
+		sr.cors.writeHeaders(writer, request)
+	}
+
+	if sr.security != nil {
+		sr.security.writeHeaders(writer, request)
+	}
+}
+
+// routeUnmatched is the metric label of the requests without route
+const routeUnmatched = "unmatched"
+
+type routeKey struct{}
+
+func withRoute(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, routeKey{}, pattern)
+}
+
+// routeLabel returns the route pattern instead of the raw path,
+// so that the metric label cardinality stays bounded
+func routeLabel(request *http.Request) string {
+	if pattern, ok := request.Context().Value(routeKey{}).(string); ok {
+		return pattern
+	}
+
+	return routeUnmatched
+}
@@ -0,0 +1,461 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidPattern = errors.New("invalid path pattern")
+var ErrRouteConflict = errors.New("route conflict")
+
+const (
+	segmentWildcard = iota
+	segmentParam
+	segmentRegex
+	segmentLiteral
+)
+
+// routeHandlerFunc receives the parameters captured from the path
+type routeHandlerFunc func(writer http.ResponseWriter, request *http.Request, params map[string]string)
+
+type segment struct {
+	kind  int
+	value string // literal text or parameter name
+	regex *regexp.Regexp
+}
+
+type route struct {
+	pattern    string
+	methodList []string
+	segments   []segment
+	handler    routeHandlerFunc
+	preflight  routeHandlerFunc
+	host       *hostPattern
+}
+
+// hostRank ranks the exact hosts over the patterns and the patterns over
+// the routes without a host
+func (r *route) hostRank() int {
+	switch {
+	case r.host == nil:
+		return 0
+	case r.host.exact():
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (r *route) hostPattern() string {
+	if r.host == nil {
+		return ""
+	}
+
+	return r.host.pattern
+}
+
+// parsePattern supports literal segments, {name}, {name:regex},
+// trailing {name...} or * wildcard and a trailing slash subtree
+// like http.ServeMux
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+
+	for index, part := range parts {
+		last := index == len(parts)-1
+
+		switch {
+		case len(part) == 0 && last:
+			// trailing slash, subtree match without capture
+			segments = append(segments, segment{kind: segmentWildcard})
+		case part == "*":
+			if !last {
+				return nil, fmt.Errorf("%w: wildcard must be the last segment in %s", ErrInvalidPattern, pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			inner := part[1 : len(part)-1]
+
+			if strings.HasSuffix(inner, "...") {
+				if !last {
+					return nil, fmt.Errorf("%w: wildcard must be the last segment in %s", ErrInvalidPattern, pattern)
+				}
+				name := strings.TrimSuffix(inner, "...")
+				if len(name) == 0 {
+					return nil, fmt.Errorf("%w: empty parameter name in %s", ErrInvalidPattern, pattern)
+				}
+				segments = append(segments, segment{kind: segmentWildcard, value: name})
+				continue
+			}
+
+			name, expression, hasRegex := strings.Cut(inner, ":")
+			if len(name) == 0 {
+				return nil, fmt.Errorf("%w: empty parameter name in %s", ErrInvalidPattern, pattern)
+			}
+
+			if !hasRegex {
+				segments = append(segments, segment{kind: segmentParam, value: name})
+				continue
+			}
+
+			regex, err := regexp.Compile("^(?:" + expression + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, err.Error())
+			}
+			segments = append(segments, segment{kind: segmentRegex, value: name, regex: regex})
+		case len(part) == 0 || strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, pattern)
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+
+	return segments, nil
+}
+
+// shape is equal for the routes which match exactly the same paths
+func shape(segments []segment) string {
+	b := strings.Builder{}
+	for _, s := range segments {
+		b.WriteString("/")
+		switch s.kind {
+		case segmentLiteral:
+			b.WriteString("l:" + s.value)
+		case segmentRegex:
+			b.WriteString("r:" + s.regex.String())
+		case segmentParam:
+			b.WriteString("p")
+		case segmentWildcard:
+			b.WriteString("*")
+		}
+	}
+
+	return b.String()
+}
+
+func (r *route) match(parts []string) (map[string]string, bool) {
+	params := make(map[string]string)
+
+	for index, s := range r.segments {
+		if s.kind == segmentWildcard {
+			if len(s.value) != 0 {
+				params[s.value] = strings.Join(parts[index:], "/")
+			}
+			return params, len(parts) > index || len(s.value) != 0
+		}
+
+		if index >= len(parts) {
+			return nil, false
+		}
+
+		part := parts[index]
+		switch s.kind {
+		case segmentLiteral:
+			if part != s.value {
+				return nil, false
+			}
+		case segmentRegex:
+			if len(part) == 0 || !s.regex.MatchString(part) {
+				return nil, false
+			}
+			params[s.value] = part
+		case segmentParam:
+			if len(part) == 0 {
+				return nil, false
+			}
+			params[s.value] = part
+		}
+	}
+
+	return params, len(parts) == len(r.segments)
+}
+
+// literalPrefix returns the path up to the first captured segment,
+// the whole path for routes without captures
+func (r *route) literalPrefix() (string, bool) {
+	b := strings.Builder{}
+	for _, s := range r.segments {
+		b.WriteString("/")
+		if s.kind != segmentLiteral {
+			return b.String(), false
+		}
+		b.WriteString(s.value)
+	}
+
+	return b.String(), true
+}
+
+// shadowedBy reports whether the fallback registration of the request is
+// more specific than the route, an exact fallback pattern shadows every
+// route with captures and a subtree pattern the ones with a shorter
+// literal prefix
+func (r *route) shadowedBy(fallbackPattern string) bool {
+	// the host of a mux pattern is already matched
+	if index := strings.Index(fallbackPattern, "/"); index > 0 {
+		fallbackPattern = fallbackPattern[index:]
+	}
+
+	if len(fallbackPattern) == 0 || fallbackPattern == "/" {
+		return false
+	}
+
+	prefix, literal := r.literalPrefix()
+	if literal {
+		return false
+	}
+
+	if !strings.HasSuffix(fallbackPattern, "/") {
+		return true
+	}
+
+	return len(fallbackPattern) > len(prefix)
+}
+
+// moreSpecific reports whether route a should be preferred over route b
+func moreSpecific(a, b *route) bool {
+	for index := 0; index < len(a.segments) && index < len(b.segments); index++ {
+		if a.segments[index].kind != b.segments[index].kind {
+			return a.segments[index].kind > b.segments[index].kind
+		}
+	}
+
+	return len(a.segments) > len(b.segments)
+}
+
+func (r *route) allows(method string) bool {
+	for _, m := range r.methodList {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+type router struct {
+	routes                  []*route
+	fallback                http.Handler
+	methodNotAllowedHandler http.HandlerFunc
+
+	// fallbackPattern returns the pattern the fallback would serve the
+	// request with, an empty pattern when it is not known
+	fallbackPattern func(request *http.Request) string
+}
+
+func newRouter(fallback http.Handler, methodNotAllowedHandler http.HandlerFunc) *router {
+	return &router{
+		fallback:                fallback,
+		methodNotAllowedHandler: methodNotAllowedHandler,
+	}
+}
+
+// add registers the handler, the same path shape can be registered
+// several times as long as the methods do not overlap
+func (rt *router) add(methodList []string, pattern string, handler routeHandlerFunc) error {
+	return rt.addWithPreflight(methodList, pattern, handler, nil)
+}
+
+// addWithPreflight registers the handler along with the CORS preflight handler,
+// preflight is matched against the requested method instead of OPTIONS
+func (rt *router) addWithPreflight(methodList []string, pattern string, handler routeHandlerFunc, preflight routeHandlerFunc) error {
+	return rt.addWithHost("", methodList, pattern, handler, preflight)
+}
+
+// addWithHost registers the handler for the requests of the matching host,
+// an empty host matches every host
+func (rt *router) addWithHost(host string, methodList []string, pattern string, handler routeHandlerFunc, preflight routeHandlerFunc) error {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	newRoute := &route{
+		pattern:    pattern,
+		methodList: methodList,
+		segments:   segments,
+		handler:    handler,
+		preflight:  preflight,
+	}
+
+	if len(host) != 0 {
+		if newRoute.host, err = parseHostPattern(host); err != nil {
+			return err
+		}
+
+		// the host params never replace the path params
+		for _, name := range newRoute.host.paramNames() {
+			for _, s := range segments {
+				if s.kind != segmentLiteral && s.value == name {
+					return fmt.Errorf("%w: {%s} of %s is set by the host %s", ErrInvalidPattern, name, pattern, host)
+				}
+			}
+		}
+	}
+
+	newShape := shape(segments)
+	for _, r := range rt.routes {
+		if shape(r.segments) != newShape || r.hostPattern() != newRoute.hostPattern() {
+			continue
+		}
+
+		for _, m := range methodList {
+			if r.allows(m) {
+				return fmt.Errorf("%w: %s %s is already registered as %s", ErrRouteConflict, m, pattern, r.pattern)
+			}
+		}
+	}
+
+	rt.routes = append(rt.routes, newRoute)
+
+	return nil
+}
+
+func splitPath(escapedPath string) []string {
+	parts := strings.Split(strings.TrimPrefix(escapedPath, "/"), "/")
+	for index, p := range parts {
+		if v, err := url.PathUnescape(p); err == nil {
+			parts[index] = v
+		}
+	}
+
+	return parts
+}
+
+// lookup returns the most specific route for the path and method,
+// allowedMethodList is filled when only the method did not match
+func (rt *router) lookup(method string, escapedPath string) (*route, map[string]string, []string) {
+	return rt.lookupHost(method, "", escapedPath, "")
+}
+
+// lookupHost prefers the routes of the host, the host captures are
+// added to the params, the routes shadowed by the fallback pattern
+// are skipped
+func (rt *router) lookupHost(method string, host string, escapedPath string, fallbackPattern string) (*route, map[string]string, []string) {
+	parts := splitPath(escapedPath)
+
+	var best *route
+	var bestParams map[string]string
+	var bestHostParams map[string]string
+	var allowedMethodList []string
+
+	for _, r := range rt.routes {
+		var hostParams map[string]string
+		if r.host != nil {
+			var ok bool
+			if hostParams, ok = r.host.match(host); !ok {
+				continue
+			}
+		}
+
+		params, ok := r.match(parts)
+		if !ok || r.shadowedBy(fallbackPattern) {
+			continue
+		}
+
+		if !r.allows(method) {
+			allowedMethodList = append(allowedMethodList, r.methodList...)
+			continue
+		}
+
+		if best == nil || r.hostRank() > best.hostRank() || (r.hostRank() == best.hostRank() && moreSpecific(r, best)) {
+			best = r
+			bestParams = params
+			bestHostParams = hostParams
+		}
+	}
+
+	if best != nil {
+		if best.host != nil {
+			bestParams[hostParam] = host
+			for k, v := range bestHostParams {
+				bestParams[k] = v
+			}
+		}
+
+		return best, bestParams, nil
+	}
+
+	sort.Strings(allowedMethodList)
+	return nil, nil, allowedMethodList
+}
+
+func (rt *router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// redirect to the clean path like http.ServeMux
+	if request.Method != http.MethodConnect {
+		if p := cleanPath(request.URL.Path); p != request.URL.Path {
+			u := &url.URL{Path: p, RawQuery: request.URL.RawQuery}
+			http.Redirect(writer, request, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+
+	host := requestHost(request.Host)
+
+	fallbackPattern := ""
+	if rt.fallbackPattern != nil {
+		fallbackPattern = rt.fallbackPattern(request)
+	}
+
+	// answer the preflight before the method filtering rejects OPTIONS
+	if isPreflight(request) {
+		r, params, _ := rt.lookupHost(request.Header.Get("Access-Control-Request-Method"), host, request.URL.EscapedPath(), fallbackPattern)
+		if r != nil && r.preflight != nil {
+			r.preflight(writer, request, params)
+			return
+		}
+	}
+
+	r, params, allowedMethodList := rt.lookupHost(request.Method, host, request.URL.EscapedPath(), fallbackPattern)
+	if r != nil {
+		r.handler(writer, request, params)
+		return
+	}
+
+	if len(allowedMethodList) != 0 {
+		writer.Header().Set("Allow", strings.Join(uniqueSorted(allowedMethodList), ", "))
+		rt.methodNotAllowedHandler(writer, request)
+		return
+	}
+
+	rt.fallback.ServeHTTP(writer, request)
+}
+
+// cleanPath returns the canonical path like http.ServeMux, the trailing
+// slash is kept
+func cleanPath(p string) string {
+	if len(p) == 0 {
+		return "/"
+	}
+
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+
+	return np
+}
+
+func uniqueSorted(sortedList []string) []string {
+	output := make([]string, 0, len(sortedList))
+	for index, v := range sortedList {
+		if index == 0 || sortedList[index-1] != v {
+			output = append(output, v)
+		}
+	}
+
+	return output
+}
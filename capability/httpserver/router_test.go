@@ -0,0 +1,179 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestRouter(t *testing.T, routes map[string][]string) *router {
+	rt := newRouter(http.NotFoundHandler(), func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	for pattern, methodList := range routes {
+		p := pattern
+		if err := rt.add(methodList, p, func(writer http.ResponseWriter, _ *http.Request, _ map[string]string) {
+			writer.Header().Set("X-Pattern", p)
+		}); err != nil {
+			t.Fatalf("add(%s) error = %v", p, err)
+		}
+	}
+
+	return rt
+}
+
+func TestRouter_Lookup(t *testing.T) {
+	rt := newTestRouter(t, map[string][]string{
+		"/users/{id}":            {"GET"},
+		"/users/{id:[0-9]+}":     {"DELETE"},
+		"/users/me":              {"GET"},
+		"/files/{path...}":       {"GET"},
+		"/static/":               {"GET"},
+		"/orders/{id}/items/{n}": {"GET", "POST"},
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		pattern string
+		params  map[string]string
+	}{
+		{"GET", "/users/me", "/users/me", map[string]string{}},
+		{"GET", "/users/42", "/users/{id}", map[string]string{"id": "42"}},
+		{"DELETE", "/users/42", "/users/{id:[0-9]+}", map[string]string{"id": "42"}},
+		{"GET", "/files/a/b%2Fc.txt", "/files/{path...}", map[string]string{"path": "a/b/c.txt"}},
+		{"GET", "/static/css/main.css", "/static/", map[string]string{}},
+		{"POST", "/orders/7/items/2", "/orders/{id}/items/{n}", map[string]string{"id": "7", "n": "2"}},
+	}
+
+	for _, tt := range tests {
+		r, params, _ := rt.lookup(tt.method, tt.path)
+		if r == nil {
+			t.Errorf("lookup(%s %s) found no route", tt.method, tt.path)
+			continue
+		}
+
+		if r.pattern != tt.pattern {
+			t.Errorf("lookup(%s %s) = %s, want %s", tt.method, tt.path, r.pattern, tt.pattern)
+		}
+
+		for k, v := range tt.params {
+			if params[k] != v {
+				t.Errorf("lookup(%s %s) param %s = %s, want %s", tt.method, tt.path, k, params[k], v)
+			}
+		}
+	}
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	rt := newTestRouter(t, map[string][]string{
+		"/users/{id}":        {"GET"},
+		"/users/{id:[0-9]+}": {"DELETE"},
+	})
+
+	recorder := httptest.NewRecorder()
+	rt.ServeHTTP(recorder, httptest.NewRequest("PUT", "/users/1", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+
+	if recorder.Header().Get("Allow") != "DELETE, GET" {
+		t.Errorf("Allow = %s, want %s", recorder.Header().Get("Allow"), "DELETE, GET")
+	}
+
+	recorder = httptest.NewRecorder()
+	rt.ServeHTTP(recorder, httptest.NewRequest("GET", "/unknown", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouter_Conflict(t *testing.T) {
+	rt := newTestRouter(t, map[string][]string{
+		"/users/{id}": {"GET", "POST"},
+	})
+
+	err := rt.add([]string{"POST"}, "/users/{name}", nil)
+	if !errors.Is(err, ErrRouteConflict) {
+		t.Errorf("add() error = %v, want %v", err, ErrRouteConflict)
+	}
+
+	if err = rt.add([]string{"PUT"}, "/users/{name}", nil); err != nil {
+		t.Errorf("add() error = %v, want nil", err)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	for _, pattern := range []string{"users", "/a/{}", "/a/{x...}/b", "/a/*/b", "/a/{id:[}", "/a//b"} {
+		if _, err := parsePattern(pattern); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("parsePattern(%s) error = %v, want %v", pattern, err, ErrInvalidPattern)
+		}
+	}
+}
+
+func TestRouter_Fallback(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/metrics", "/static/", "/api/", "/"} {
+		p := pattern
+		mux.HandleFunc(p, func(writer http.ResponseWriter, _ *http.Request) {
+			writer.Header().Set("X-Pattern", "mux "+p)
+		})
+	}
+
+	rt := newTestRouter(t, map[string][]string{
+		"/{path...}":         {"GET"},
+		"/api/{id}":          {"GET"},
+		"/static/a.css":      {"GET"},
+		"/metrics/{rest...}": {"GET"},
+	})
+	rt.fallback = mux
+	rt.fallbackPattern = func(request *http.Request) string {
+		_, pattern := mux.Handler(request)
+		return pattern
+	}
+
+	for path, pattern := range map[string]string{
+		"/metrics":       "mux /metrics",
+		"/static/b.css":  "mux /static/",
+		"/static/a.css":  "/static/a.css",
+		"/api/7":         "/api/{id}",
+		"/api/7/items":   "mux /api/",
+		"/users/1":       "/{path...}",
+		"/":              "/{path...}",
+		"/metrics/extra": "/metrics/{rest...}",
+	} {
+		recorder := httptest.NewRecorder()
+		rt.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+
+		if recorder.Header().Get("X-Pattern") != pattern {
+			t.Errorf("%s served by %s, want %s", path, recorder.Header().Get("X-Pattern"), pattern)
+		}
+	}
+}
+
+func TestRouter_CleanPath(t *testing.T) {
+	rt := newTestRouter(t, map[string][]string{
+		"/users/{id}": {"GET"},
+	})
+
+	for path, location := range map[string]string{
+		"/users//1":        "/users/1",
+		"/a/../users/1?x=": "/users/1?x=",
+		"/users/./1/":      "/users/1/",
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.URL.Path, request.URL.RawQuery, _ = strings.Cut(path, "?")
+
+		recorder := httptest.NewRecorder()
+		rt.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != location {
+			t.Errorf("%s = %d %s, want %s", path, recorder.Code, recorder.Header().Get("Location"), location)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrMountPathNotDefined = errors.New("mount path not defined")
+var ErrMountSourceNotDefined = errors.New("mount dir or fs not defined")
+var ErrMountFSNotFound = errors.New("mount fs not found")
+var ErrMountDirNotDirectory = errors.New("mount dir is not a directory")
+var ErrMountPatternRegistered = errors.New("mount pattern already registered")
+var ErrInvalidCacheControlRule = errors.New("invalid cache control rule")
+
+// precompressedList is in the order of preference
+var precompressedList = []struct {
+	encoding  string
+	extension string
+}{
+	{encoding: "br", extension: ".br"},
+	{encoding: "gzip", extension: ".gz"},
+}
+
+type cacheControlRule struct {
+	pattern string
+	value   string
+}
+
+// staticOptions are read from the mount values:
+//
+//	index            index file of the directories (default index.html)
+//	spa              serve the root index file for the missing paths
+//	                 without an extension (default false)
+//	listing          list the directories without an index (default false)
+//	etag             send the etag of the files (default true)
+//	precompressed    serve the .br and .gz sidecar files (default true)
+//	cache_control    glob rules, the first match wins
+//	                 (ex: *.html=no-cache;assets/*=public, max-age=31536000)
+type staticOptions struct {
+	index         string
+	spa           bool
+	listing       bool
+	etag          bool
+	precompressed bool
+	cacheRules    []cacheControlRule
+}
+
+func newStaticOptions(values model.ConfigMap) (*staticOptions, error) {
+	o := &staticOptions{
+		index:         values.String("index", "index.html"),
+		spa:           values.Bool("spa", false),
+		listing:       values.Bool("listing", false),
+		etag:          values.Bool("etag", true),
+		precompressed: values.Bool("precompressed", true),
+	}
+
+	// rules are kept in order, so StringMap is not used
+	for _, rule := range strings.Split(values.String("cache_control", ""), ";") {
+		if rule = strings.TrimSpace(rule); len(rule) == 0 {
+			continue
+		}
+
+		index := strings.Index(rule, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCacheControlRule, rule)
+		}
+
+		pattern := strings.TrimPrefix(strings.TrimSpace(rule[:index]), "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCacheControlRule, rule)
+		}
+
+		o.cacheRules = append(o.cacheRules, cacheControlRule{pattern: pattern, value: strings.TrimSpace(rule[index+1:])})
+	}
+
+	return o, nil
+}
+
+// cacheControl matches the patterns without a slash against the file
+// name and the others against the path relative to the mount
+func (o *staticOptions) cacheControl(name string) string {
+	for _, rule := range o.cacheRules {
+		target := name
+		if !strings.Contains(rule.pattern, "/") {
+			target = path.Base(name)
+		}
+
+		if ok, _ := path.Match(rule.pattern, target); ok {
+			return rule.value
+		}
+	}
+
+	return ""
+}
+
+// staticMount serves a file system under a path prefix
+type staticMount struct {
+	prefix   string
+	fsys     fs.FS
+	options  *staticOptions
+	notFound http.HandlerFunc
+
+	etagCache sync.Map
+}
+
+func newStaticMount(prefix string, fsys fs.FS, options *staticOptions, notFound http.HandlerFunc) *staticMount {
+	if notFound == nil {
+		notFound = http.NotFound
+	}
+
+	return &staticMount{prefix: prefix, fsys: fsys, options: options, notFound: notFound}
+}
+
+func (m *staticMount) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(request.URL.Path, m.prefix)), "/")
+	if len(name) == 0 {
+		name = "."
+	}
+
+	info, err := fs.Stat(m.fsys, name)
+	if err != nil {
+		m.serveMissing(writer, request, name)
+		return
+	}
+
+	if !info.IsDir() {
+		m.serveFile(writer, request, name, info)
+		return
+	}
+
+	// relative links of the index need the trailing slash
+	if !strings.HasSuffix(request.URL.Path, "/") {
+		target := request.URL.Path + "/"
+		if len(request.URL.RawQuery) != 0 {
+			target += "?" + request.URL.RawQuery
+		}
+		http.Redirect(writer, request, target, http.StatusMovedPermanently)
+		return
+	}
+
+	index := path.Join(name, m.options.index)
+	if indexInfo, err := fs.Stat(m.fsys, index); err == nil && !indexInfo.IsDir() {
+		m.serveFile(writer, request, index, indexInfo)
+		return
+	}
+
+	if m.options.listing {
+		m.serveListing(writer, request, name)
+		return
+	}
+
+	m.notFound(writer, request)
+}
+
+// serveMissing falls back to the root index file for the application
+// routes, the missing assets stay not found
+func (m *staticMount) serveMissing(writer http.ResponseWriter, request *http.Request, name string) {
+	if m.options.spa && len(path.Ext(name)) == 0 {
+		if info, err := fs.Stat(m.fsys, m.options.index); err == nil && !info.IsDir() {
+			m.serveFile(writer, request, m.options.index, info)
+			return
+		}
+	}
+
+	m.notFound(writer, request)
+}
+
+func acceptsEncoding(request *http.Request, encoding string) bool {
+	for _, v := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		part := strings.Split(strings.TrimSpace(v), ";")
+		if strings.EqualFold(strings.TrimSpace(part[0]), encoding) {
+			return len(part) < 2 || strings.TrimSpace(part[1]) != "q=0"
+		}
+	}
+
+	return false
+}
+
+func (m *staticMount) serveFile(writer http.ResponseWriter, request *http.Request, name string, info fs.FileInfo) {
+	header := writer.Header()
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); len(contentType) != 0 {
+		header.Set("Content-Type", contentType)
+	}
+
+	if cacheControl := m.options.cacheControl(name); len(cacheControl) != 0 {
+		header.Set("Cache-Control", cacheControl)
+	}
+
+	served := name
+	if m.options.precompressed {
+		header.Add("Vary", "Accept-Encoding")
+
+		for _, p := range precompressedList {
+			if !acceptsEncoding(request, p.encoding) {
+				continue
+			}
+
+			if sidecarInfo, err := fs.Stat(m.fsys, name+p.extension); err == nil && !sidecarInfo.IsDir() {
+				header.Set("Content-Encoding", p.encoding)
+				served, info = name+p.extension, sidecarInfo
+				break
+			}
+		}
+	}
+
+	if served != name && len(header.Get("Content-Type")) == 0 {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+
+	file, err := m.fsys.Open(served)
+	if err != nil {
+		m.notFound(writer, request)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if m.options.etag {
+		if tag := m.etag(served, info, content); len(tag) != 0 {
+			header.Set("Etag", tag)
+		}
+	}
+
+	// the content type is always set for a sidecar, so it is never sniffed
+	http.ServeContent(writer, request, name, info.ModTime(), content)
+}
+
+// etag is built from the modification time and size, the files of the
+// embedded file systems have no modification time so their content is
+// hashed once instead
+func (m *staticMount) etag(name string, info fs.FileInfo, content io.ReadSeeker) string {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	}
+
+	if tag, ok := m.etagCache.Load(name); ok {
+		return tag.(string)
+	}
+
+	hash := fnv.New64a()
+	if _, err := io.Copy(hash, content); err != nil {
+		return ""
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+
+	tag := fmt.Sprintf(`"%x-%x"`, hash.Sum64(), info.Size())
+	m.etagCache.Store(name, tag)
+
+	return tag
+}
+
+func (m *staticMount) serveListing(writer http.ResponseWriter, request *http.Request, name string) {
+	entryList, err := fs.ReadDir(m.fsys, name)
+	if err != nil {
+		m.notFound(writer, request)
+		return
+	}
+
+	sort.Slice(entryList, func(i, j int) bool {
+		return entryList[i].Name() < entryList[j].Name()
+	})
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<pre>\n")
+	for _, entry := range entryList {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+
+		link := url.URL{Path: entryName}
+		b.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(entryName)))
+	}
+	b.WriteString("</pre>\n")
+
+	if request.Method != http.MethodHead {
+		_, _ = writer.Write([]byte(b.String()))
+	}
+}
+
+func mountNameList(values model.ConfigMap) []string {
+	var nameList []string
+	for key := range values {
+		if strings.HasPrefix(key, "mount_") && strings.HasSuffix(key, "_path") {
+			if name := strings.TrimSuffix(strings.TrimPrefix(key, "mount_"), "_path"); len(name) != 0 {
+				nameList = append(nameList, name)
+			}
+		}
+	}
+	sort.Strings(nameList)
+
+	return nameList
+}
+
+// mountConfig is a mount_<name>_<key> entry, dir is a directory and fs
+// the name of a file system added with AddStaticFS
+type mountConfig struct {
+	name    string
+	path    string
+	dir     string
+	fs      string
+	options *staticOptions
+}
+
+func newMountConfigList(values model.ConfigMap) ([]*mountConfig, error) {
+	var mountList []*mountConfig
+	for _, name := range mountNameList(values) {
+		prefix := "mount_" + name + "_"
+
+		c := &mountConfig{
+			name: name,
+			path: strings.TrimSpace(values.String(prefix+"path", "")),
+			dir:  values.String(prefix+"dir", ""),
+			fs:   values.String(prefix+"fs", ""),
+		}
+
+		if len(c.path) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMountPathNotDefined, name)
+		}
+
+		// a mount serves a subtree, /app is redirected to /app/
+		if !strings.HasSuffix(c.path, "/") {
+			c.path += "/"
+		}
+
+		if len(c.dir) == 0 && len(c.fs) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMountSourceNotDefined, name)
+		}
+
+		var err error
+		if c.options, err = newStaticOptions(prefixedValues(values, prefix)); err != nil {
+			return nil, err
+		}
+
+		mountList = append(mountList, c)
+	}
+
+	return mountList, nil
+}
+
+// handleMount registers the mount, the prefix is stripped from the
+// request path before the file system lookup
+func (h *HTTPServer) handleMount(pattern string, prefix string, fsys fs.FS, options *staticOptions) error {
+	// the mux panics on a second registration of the pattern
+	if h.isRegistered(pattern) {
+		return fmt.Errorf("%w: %s", ErrMountPatternRegistered, pattern)
+	}
+
+	h.mHttpServerMux.Handle(pattern, newStaticMount(prefix, fsys, options, func(writer http.ResponseWriter, request *http.Request) {
+		h.s404m(request, writer, nil)
+	}))
+
+	return nil
+}
+
+// isRegistered is true when the mux has a handler for exactly the pattern
+func (h *HTTPServer) isRegistered(pattern string) bool {
+	request := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: pattern}}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		request.Host, request.URL.Path = pattern[:i], pattern[i:]
+	}
+
+	_, registered := h.mHttpServerMux.Handler(request)
+	return registered == pattern
+}
+
+// namedFS resolves the file system on every open, so that AddStaticFS
+// may be called before or after Setup
+type namedFS struct {
+	server *HTTPServer
+	name   string
+}
+
+func (n *namedFS) Open(name string) (fs.File, error) {
+	fsys, found := n.server.staticFS(n.name)
+	if !found {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fsys.Open(name)
+}
+
+func (h *HTTPServer) staticFS(name string) (fs.FS, bool) {
+	h.mStaticFSMutex.RLock()
+	defer h.mStaticFSMutex.RUnlock()
+
+	fsys, found := h.mStaticFSMap[name]
+	return fsys, found
+}
+
+// registerMountList registers the configured mounts
+func (h *HTTPServer) registerMountList() error {
+	for _, c := range h.mMountList {
+		var fsys fs.FS
+		if len(c.fs) != 0 {
+			fsys = &namedFS{server: h, name: c.fs}
+		} else {
+			if fi, err := os.Stat(c.dir); err != nil || !fi.IsDir() {
+				return fmt.Errorf("%w: %s", ErrMountDirNotDirectory, c.dir)
+			}
+			fsys = os.DirFS(c.dir)
+		}
+
+		if err := h.handleMount(c.path, strings.TrimSuffix(c.path, "/"), fsys, c.options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerEmbeddedStaticFSList registers the file systems added before Setup
+func (h *HTTPServer) registerEmbeddedStaticFSList() error {
+	h.mStaticFSMutex.RLock()
+	defer h.mStaticFSMutex.RUnlock()
+
+	for pattern, fsys := range h.mEmbeddedStaticFSMap {
+		if err := h.handleEmbeddedStaticFS(pattern, fsys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkMountList reports the mounts whose file system was never added
+func (h *HTTPServer) checkMountList() error {
+	for _, c := range h.mMountList {
+		if len(c.fs) == 0 {
+			continue
+		}
+
+		if _, found := h.staticFS(c.fs); !found {
+			return fmt.Errorf("%w: %s", ErrMountFSNotFound, c.fs)
+		}
+	}
+
+	return nil
+}
+
+// hasRootMount is true when a mount serves every path
+func (h *HTTPServer) hasRootMount() bool {
+	for _, c := range h.mMountList {
+		if c.path == "/" {
+			return true
+		}
+	}
+
+	h.mStaticFSMutex.RLock()
+	defer h.mStaticFSMutex.RUnlock()
+
+	_, found := h.mEmbeddedStaticFSMap["/"]
+	return found
+}
@@ -0,0 +1,180 @@
+package httpserver
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestHTTPServer_StaticMount(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("a"), 0644)
+
+	h := &HTTPServer{}
+	// the file system is added before Setup
+	h.AddStaticFS("site", fstest.MapFS{
+		"index.html":         {Data: []byte("<html>app</html>")},
+		"assets/app.js":      {Data: []byte("console.log(1)")},
+		"assets/app.js.gz":   {Data: []byte("gzipped")},
+		"assets/empty/.keep": {Data: []byte("")},
+	})
+	if err := h.AddEmbeddedStaticFSE("/embedded/", embed.FS{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = h.SetConfigMap(model.ConfigMap{
+		"mount_app_path":          "/app",
+		"mount_app_fs":            "site",
+		"mount_app_spa":           "true",
+		"mount_app_cache_control": "*.html=no-cache;assets/*=public, max-age=31536000",
+		"mount_files_path":        "/files/",
+		"mount_files_dir":         dir,
+		"mount_files_listing":     "true",
+	})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.checkMountList(); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	r := get("/app/", nil)
+	if r.Code != http.StatusOK || r.Body.String() != "<html>app</html>" || r.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("index = %d %q %q", r.Code, r.Body.String(), r.Header().Get("Cache-Control"))
+	}
+
+	if r = get("/app", nil); r.Code != http.StatusMovedPermanently || r.Header().Get("Location") != "/app/" {
+		t.Errorf("redirect = %d %q", r.Code, r.Header().Get("Location"))
+	}
+
+	if r = get("/app/users/1", nil); r.Code != http.StatusOK || r.Body.String() != "<html>app</html>" {
+		t.Errorf("spa fallback = %d %q", r.Code, r.Body.String())
+	}
+
+	if r = get("/app/assets/missing.js", nil); r.Code != http.StatusNotFound {
+		t.Errorf("missing asset = %d, want 404", r.Code)
+	}
+
+	if r = get("/app/assets/empty/", nil); r.Code != http.StatusNotFound {
+		t.Errorf("listing = %d, want 404", r.Code)
+	}
+
+	r = get("/app/assets/app.js", map[string]string{"Accept-Encoding": "br, gzip"})
+	if r.Body.String() != "gzipped" || r.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.Contains(r.Header().Get("Content-Type"), "javascript") ||
+		r.Header().Get("Cache-Control") != "public, max-age=31536000" {
+		t.Errorf("precompressed = %q %v", r.Body.String(), r.Header())
+	}
+
+	r = get("/app/assets/app.js", nil)
+	etag := r.Header().Get("Etag")
+	if r.Body.String() != "console.log(1)" || len(etag) == 0 {
+		t.Errorf("plain = %q etag %q", r.Body.String(), etag)
+	}
+
+	if r = get("/app/assets/app.js", map[string]string{"If-None-Match": etag}); r.Code != http.StatusNotModified {
+		t.Errorf("if none match = %d, want 304", r.Code)
+	}
+
+	if r = get("/files/docs/", nil); r.Code != http.StatusOK || !strings.Contains(r.Body.String(), `<a href="a.txt">a.txt</a>`) {
+		t.Errorf("listing = %d %q", r.Code, r.Body.String())
+	}
+
+	if err := h.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPServer_StaticMountConfig(t *testing.T) {
+	if _, err := newMountConfigList(model.ConfigMap{"mount_a_path": "/a/"}); !errors.Is(err, ErrMountSourceNotDefined) {
+		t.Errorf("error = %v, want %v", err, ErrMountSourceNotDefined)
+	}
+
+	if _, err := newMountConfigList(model.ConfigMap{"mount_a_path": "/a/", "mount_a_dir": ".", "mount_a_cache_control": "[=x"}); !errors.Is(err, ErrInvalidCacheControlRule) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidCacheControlRule)
+	}
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{"mount_a_path": "/a/", "mount_a_fs": "missing"})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.checkMountList(); !errors.Is(err, ErrMountFSNotFound) {
+		t.Errorf("error = %v, want %v", err, ErrMountFSNotFound)
+	}
+}
+
+func TestHTTPServer_StaticMountRegistered(t *testing.T) {
+	h := &HTTPServer{}
+	if err := h.AddEmbeddedStaticFSE("/embedded/", embed.FS{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddEmbeddedStaticFSE("/embedded/", embed.FS{}); !errors.Is(err, ErrMountPatternRegistered) {
+		t.Errorf("duplicate before setup = %v, want %v", err, ErrMountPatternRegistered)
+	}
+
+	_ = h.SetConfigMap(model.ConfigMap{"mount_a_path": "/a", "mount_a_dir": t.TempDir()})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mount path is a subtree and the root serves the not found responses
+	for _, pattern := range []string{"/a/", "/", "/embedded/"} {
+		if err := h.AddEmbeddedStaticFSE(pattern, embed.FS{}); !errors.Is(err, ErrMountPatternRegistered) {
+			t.Errorf("%s after setup = %v, want %v", pattern, err, ErrMountPatternRegistered)
+		}
+	}
+
+	if err := h.AddEmbeddedStaticFSE("/b/", embed.FS{}); err != nil {
+		t.Errorf("after setup = %v", err)
+	}
+
+	// the error of the old signature is logged only
+	var adder iface.IAddEmbeddedStaticFS = h
+	adder.AddEmbeddedStaticFS("/b/", embed.FS{})
+	adder.AddEmbeddedStaticFS("/c/", embed.FS{})
+	if _, found := h.mEmbeddedStaticFSMap["/c/"]; !found {
+		t.Error("AddEmbeddedStaticFS() is not applied")
+	}
+
+	if _, ok := adder.(iface.IAddEmbeddedStaticFSE); !ok {
+		t.Error("HTTPServer is not an iface.IAddEmbeddedStaticFSE")
+	}
+
+	h = &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"mount_a_path": "/a",
+		"mount_a_fs":   "a",
+		"mount_b_path": "/a/",
+		"mount_b_fs":   "b",
+	})
+	if err := h.Setup(); !errors.Is(err, ErrMountPatternRegistered) {
+		t.Errorf("duplicate mount = %v, want %v", err, ErrMountPatternRegistered)
+	}
+}
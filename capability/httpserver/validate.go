@@ -0,0 +1,262 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/model"
+)
+
+const (
+	locationBody   = "body"
+	locationQuery  = "query"
+	locationHeader = "header"
+)
+
+// schemaCache keeps the compiled schemas by file path or inline source
+var schemaCache = struct {
+	sync.Mutex
+	schemaMap map[string]*jsonschema.Schema
+}{schemaMap: make(map[string]*jsonschema.Schema)}
+
+// compileSchema compiles a schema file path or an inline json schema
+// (starting with {), compiled schemas are cached
+func compileSchema(source string) (*jsonschema.Schema, error) {
+	source = strings.TrimSpace(source)
+
+	schemaCache.Lock()
+	defer schemaCache.Unlock()
+
+	if schema, found := schemaCache.schemaMap[source]; found {
+		return schema, nil
+	}
+
+	var schema *jsonschema.Schema
+	var err error
+	if strings.HasPrefix(source, "{") {
+		sum := sha256.Sum256([]byte(source))
+		schema, err = jsonschema.CompileString("inline://"+hex.EncodeToString(sum[:])+".json", source)
+	} else {
+		schema, err = jsonschema.Compile(source)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	schemaCache.schemaMap[source] = schema
+	return schema, nil
+}
+
+// violation is a single field that failed the validation
+type violation struct {
+	Location string `json:"location"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+// validationError is a bad_request.validation error carrying the violations,
+// they are written to the data of the response
+type validationError struct {
+	cause         *abeshErrors.Error
+	violationList []*violation
+}
+
+func newValidationError(violationList []*violation) *validationError {
+	return &validationError{
+		cause:         abeshErrors.BadRequest("validation", "request validation failed", nil),
+		violationList: violationList,
+	}
+}
+
+func (e *validationError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.cause
+}
+
+// requestValidator validates the body, the query and the headers against
+// the body_schema, query_schema and header_schema trigger values
+type requestValidator struct {
+	body   *jsonschema.Schema
+	query  *jsonschema.Schema
+	header *jsonschema.Schema
+}
+
+func newRequestValidator(triggerValues model.ConfigMap) (*requestValidator, error) {
+	v := &requestValidator{}
+
+	for key, target := range map[string]**jsonschema.Schema{
+		"body_schema":   &v.body,
+		"query_schema":  &v.query,
+		"header_schema": &v.header,
+	} {
+		source := triggerValues.String(key, "")
+		if len(source) == 0 {
+			continue
+		}
+
+		schema, err := compileSchema(source)
+		if err != nil {
+			return nil, err
+		}
+		*target = schema
+	}
+
+	if v.body == nil && v.query == nil && v.header == nil {
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+// validateRequest validates the query and the headers, header names are lower case
+func (v *requestValidator) validateRequest(request *http.Request) error {
+	var violationList []*violation
+
+	if v.query != nil {
+		violationList = append(violationList, validate(v.query, locationQuery, valueObject(v.query, request.URL.Query(), false))...)
+	}
+
+	if v.header != nil {
+		violationList = append(violationList, validate(v.header, locationHeader, valueObject(v.header, url.Values(request.Header), true))...)
+	}
+
+	if len(violationList) != 0 {
+		return newValidationError(violationList)
+	}
+
+	return nil
+}
+
+// validateBody validates the json body, an empty body is validated as null
+func (v *requestValidator) validateBody(data []byte) error {
+	if v.body == nil {
+		return nil
+	}
+
+	var value interface{}
+	if len(bytes.TrimSpace(data)) != 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return newValidationError([]*violation{{Location: locationBody, Field: "", Message: "invalid json: " + err.Error()}})
+		}
+	}
+
+	if violationList := validate(v.body, locationBody, value); len(violationList) != 0 {
+		return newValidationError(violationList)
+	}
+
+	return nil
+}
+
+func validate(schema *jsonschema.Schema, location string, value interface{}) []*violation {
+	err := schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return []*violation{{Location: location, Message: err.Error()}}
+	}
+
+	var violationList []*violation
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			violationList = append(violationList, &violation{
+				Location: location,
+				Field:    strings.ReplaceAll(strings.TrimPrefix(e.InstanceLocation, "/"), "/", "."),
+				Message:  e.Message,
+			})
+			return
+		}
+
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(validationErr)
+
+	return violationList
+}
+
+// valueObject turns query or header values into a json object, values are
+// converted to the type of the property and repeated values are kept only
+// for array properties
+func valueObject(schema *jsonschema.Schema, values url.Values, lowerCase bool) map[string]interface{} {
+	object := make(map[string]interface{}, len(values))
+
+	for key, valueList := range values {
+		if len(valueList) == 0 {
+			continue
+		}
+
+		if lowerCase {
+			key = strings.ToLower(key)
+		}
+
+		property := schema.Properties[key]
+		if hasType(property, "array") {
+			items, _ := property.Items.(*jsonschema.Schema)
+			if items == nil {
+				items = property.Items2020
+			}
+
+			array := make([]interface{}, 0, len(valueList))
+			for _, value := range valueList {
+				array = append(array, coerce(items, value))
+			}
+			object[key] = array
+			continue
+		}
+
+		object[key] = coerce(property, valueList[0])
+	}
+
+	return object
+}
+
+func hasType(schema *jsonschema.Schema, typeName string) bool {
+	if schema == nil {
+		return false
+	}
+
+	for _, t := range schema.Types {
+		if t == typeName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func coerce(schema *jsonschema.Schema, value string) interface{} {
+	switch {
+	case hasType(schema, "integer") || hasType(schema, "number"):
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case hasType(schema, "boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestHTTPServer_Validation(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(schemaFile, []byte(`{
+		"type": "object",
+		"required": ["name"],
+		"properties": {"name": {"type": "string", "minLength": 2}, "age": {"type": "integer"}}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{})
+	_ = h.Setup()
+
+	if err := h.AddService(nil, "", model.ConfigMap{
+		"method":        "POST",
+		"path":          "/user",
+		"body_schema":   schemaFile,
+		"query_schema":  `{"type": "object", "properties": {"page": {"type": "integer", "minimum": 1}, "tag": {"type": "array", "maxItems": 2}}}`,
+		"header_schema": `{"type": "object", "required": ["x-tenant"]}`,
+	}, &testEchoService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCaseList := []struct {
+		name      string
+		target    string
+		body      string
+		tenant    string
+		status    int
+		fieldList []string
+	}{
+		{name: "valid", target: "/user?page=2&tag=a&tag=b", body: `{"name": "abesh", "age": 3}`, tenant: "x", status: http.StatusOK},
+		{name: "body", target: "/user", body: `{"name": "a", "age": "3"}`, tenant: "x", status: http.StatusBadRequest, fieldList: []string{"age", "name"}},
+		{name: "invalid json", target: "/user", body: `{`, tenant: "x", status: http.StatusBadRequest, fieldList: []string{""}},
+		{name: "query", target: "/user?page=0&tag=a&tag=b&tag=c", body: `{"name": "abesh"}`, tenant: "x", status: http.StatusBadRequest, fieldList: []string{"page", "tag"}},
+		{name: "header", target: "/user", body: `{"name": "abesh"}`, status: http.StatusBadRequest, fieldList: []string{""}},
+	}
+
+	for _, tc := range testCaseList {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.body))
+			if len(tc.tenant) != 0 {
+				request.Header.Set("X-Tenant", tc.tenant)
+			}
+
+			recorder := httptest.NewRecorder()
+			h.mRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tc.status, recorder.Body.String())
+			}
+
+			if tc.status == http.StatusOK {
+				return
+			}
+
+			response := &struct {
+				Code string       `json:"code"`
+				Data []*violation `json:"data"`
+			}{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Data) != len(tc.fieldList) {
+				t.Fatalf("violations = %s", recorder.Body.String())
+			}
+
+			fieldMap := make(map[string]bool)
+			for _, v := range response.Data {
+				fieldMap[v.Field] = true
+			}
+			for _, f := range tc.fieldList {
+				if !fieldMap[f] {
+					t.Errorf("field %q not reported: %s", f, recorder.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCompileSchema_Cache(t *testing.T) {
+	a, err := compileSchema(`{"type": "string"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := compileSchema(` {"type": "string"}`)
+	if a != b {
+		t.Error("compiled schema is not cached")
+	}
+
+	if _, err = compileSchema(`{"type": 1}`); err == nil {
+		t.Error("expected error for invalid schema")
+	}
+}
@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+)
+
+// virtualHost is configured with the vhost_<name>_<key> values, host is
+// required and static_dir, static_path and the status messages
+// (ex: vhost_<name>_s404m_en) override the server values for the host
+type virtualHost struct {
+	name    string
+	host    *hostPattern
+	values  model.ConfigMap
+	handler http.Handler
+}
+
+func (h *HTTPServer) newVirtualHostList(fallback http.Handler) ([]*virtualHost, error) {
+	var nameList []string
+	for key := range h.mValues {
+		if strings.HasPrefix(key, "vhost_") && strings.HasSuffix(key, "_host") {
+			if name := strings.TrimSuffix(strings.TrimPrefix(key, "vhost_"), "_host"); len(name) != 0 {
+				nameList = append(nameList, name)
+			}
+		}
+	}
+	sort.Strings(nameList)
+
+	virtualHostList := make([]*virtualHost, 0, len(nameList))
+	for _, name := range nameList {
+		prefix := "vhost_" + name + "_"
+
+		host, err := parseHostPattern(h.mValues.String(prefix+"host", ""))
+		if err != nil {
+			return nil, err
+		}
+
+		v := &virtualHost{
+			name:    name,
+			host:    host,
+			values:  prefixedValues(h.mValues, prefix),
+			handler: fallback,
+		}
+
+		if staticDir := h.mValues.String(prefix+"static_dir", ""); len(staticDir) != 0 {
+			if fi, e := os.Stat(staticDir); e != nil || !fi.IsDir() {
+				logger.L(h.ContractId()).Error("provided static_dir of the virtual host is not directory",
+					zap.String("vhost", name))
+			} else {
+				staticPath := v.values.String("static_path", "/static/")
+				mux := http.NewServeMux()
+				mux.Handle(staticPath, http.StripPrefix(staticPath, http.FileServer(http.Dir(staticDir))))
+				mux.Handle("/", fallback)
+				v.handler = mux
+			}
+		}
+
+		virtualHostList = append(virtualHostList, v)
+	}
+
+	// exact hosts are matched first
+	sort.SliceStable(virtualHostList, func(i, j int) bool {
+		return virtualHostList[i].host.exact() && !virtualHostList[j].host.exact()
+	})
+
+	return virtualHostList, nil
+}
+
+func (h *HTTPServer) virtualHost(request *http.Request) *virtualHost {
+	if len(h.mVirtualHostList) == 0 {
+		return nil
+	}
+
+	host := requestHost(request.Host)
+	for _, v := range h.mVirtualHostList {
+		if _, ok := v.host.match(host); ok {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// messageValues returns the values of the virtual host of the request
+func (h *HTTPServer) messageValues(request *http.Request) model.ConfigMap {
+	if v := h.virtualHost(request); v != nil {
+		return v.values
+	}
+
+	return h.mValues
+}
+
+// serveFallback serves the requests without a matching route through the
+// virtual host static dir or the server mux
+func (h *HTTPServer) serveFallback(writer http.ResponseWriter, request *http.Request) {
+	if v := h.virtualHost(request); v != nil {
+		v.handler.ServeHTTP(writer, request)
+		return
+	}
+
+	h.mHttpServerMux.ServeHTTP(writer, request)
+}
+
+// fallbackPattern returns the mux pattern serveFallback would use
+func (h *HTTPServer) fallbackPattern(request *http.Request) string {
+	if v := h.virtualHost(request); v != nil {
+		if mux, ok := v.handler.(*http.ServeMux); ok {
+			if _, pattern := mux.Handler(request); pattern != "/" {
+				return pattern
+			}
+		}
+	}
+
+	_, pattern := h.mHttpServerMux.Handler(request)
+	return pattern
+}
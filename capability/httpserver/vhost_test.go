@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+type testParamsService struct {
+	iface.IService
+	name string
+}
+
+func (s *testParamsService) ContractId() string {
+	return "test:params"
+}
+
+func (s *testParamsService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	p := event.Metadata.Params
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "text/plain",
+		[]byte(s.name+"|"+p["host"]+"|"+p["subdomain"]+"|"+p["tenant"]+"|"+p["id"])), nil
+}
+
+func TestParseHostPattern(t *testing.T) {
+	for _, pattern := range []string{"", "*", "a.*.com", "a..com", "{}.example.com", "{host}.example.com", "*.{subdomain}.com"} {
+		if _, err := parseHostPattern(pattern); err == nil {
+			t.Errorf("parseHostPattern(%q) expected error", pattern)
+		}
+	}
+
+	hp, _ := parseHostPattern("*.Example.com")
+	if params, ok := hp.match("a.b.example.com"); !ok || params[subdomainParam] != "a.b" {
+		t.Errorf("match = %v %v", params, ok)
+	}
+
+	if _, ok := hp.match("example.com"); ok {
+		t.Error("*.example.com matched example.com")
+	}
+}
+
+func TestRouter_HostParamConflict(t *testing.T) {
+	rt := newRouter(http.NotFoundHandler(), nil)
+
+	for host, pattern := range map[string]string{
+		"example.com":          "/items/{host}",
+		"*.example.com":        "/items/{subdomain}",
+		"{tenant}.example.com": "/items/{tenant:[a-z]+}",
+	} {
+		if err := rt.addWithHost(host, []string{"GET"}, pattern, nil, nil); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("addWithHost(%s, %s) error = %v, want %v", host, pattern, err, ErrInvalidPattern)
+		}
+	}
+
+	// the path params are kept on the routes without a host
+	if err := rt.add([]string{"GET"}, "/items/{host}", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, params, _ := rt.lookupHost("GET", "example.com", "/items/a", ""); params["host"] != "a" {
+		t.Errorf("params = %v", params)
+	}
+}
+
+func TestHTTPServer_VirtualHost(t *testing.T) {
+	staticDir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(staticDir, "index.txt"), []byte("api static"), 0644)
+
+	h := &HTTPServer{}
+	_ = h.SetConfigMap(model.ConfigMap{
+		"vhost_api_host":       "api.example.com",
+		"vhost_api_static_dir": staticDir,
+		"vhost_api_s404m":      "api not found",
+		"s404m":                "not found",
+	})
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct {
+		host    string
+		service string
+	}{
+		{host: "api.example.com", service: "api"},
+		{host: "*.example.com", service: "wildcard"},
+		{host: "{tenant}.example.org", service: "tenant"},
+		{host: "", service: "any"},
+	} {
+		if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/item/{id}", "host": r.host}, &testParamsService{name: r.service}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := h.AddService(nil, "", model.ConfigMap{"method": "GET", "path": "/item/{id}", "host": "API.example.com"}, &testParamsService{}); err == nil {
+		t.Error("expected route conflict for the same host")
+	}
+
+	for host, want := range map[string]string{
+		"api.example.com:8080": "api|api.example.com|||1",
+		"shop.example.com":     "wildcard|shop.example.com|shop||1",
+		"acme.example.org":     "tenant|acme.example.org||acme|1",
+		"localhost":            "any||||1",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/item/1", nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+
+		if recorder.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", host, recorder.Body.String(), want)
+		}
+	}
+
+	for _, tc := range []struct {
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{host: "api.example.com", path: "/static/index.txt", status: http.StatusOK, body: "api static"},
+		{host: "api.example.com", path: "/missing", status: http.StatusNotFound, body: "api not found"},
+		{host: "www.example.net", path: "/static/index.txt", status: http.StatusNotFound, body: "not found"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		request.Host = tc.host
+		recorder := httptest.NewRecorder()
+		h.mRouter.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.status || recorder.Body.String() != tc.body {
+			t.Errorf("%s%s = %d %q, want %d %q", tc.host, tc.path, recorder.Code, recorder.Body.String(), tc.status, tc.body)
+		}
+	}
+}
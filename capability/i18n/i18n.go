@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/constant"
+	abeshI18n "github.com/mkawserm/abesh/i18n"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+var ErrDirNotDefined = errors.New("dir not defined")
+
+// I18n loads the message catalogs of dir and shares them with the
+// httpserver default messages and the utility json events
+type I18n struct {
+	mValues model.ConfigMap
+
+	mDir             string
+	mDefaultLanguage string
+}
+
+func (i *I18n) Name() string {
+	return "abesh_i18n"
+}
+
+func (i *I18n) Version() string {
+	return constant.Version
+}
+
+func (i *I18n) Category() string {
+	return string(constant.CategoryGeneral)
+}
+
+func (i *I18n) ContractId() string {
+	return "abesh:i18n"
+}
+
+func (i *I18n) GetConfigMap() model.ConfigMap {
+	return i.mValues
+}
+
+func (i *I18n) SetConfigMap(values model.ConfigMap) error {
+	i.mValues = values
+
+	i.mDir = values.String("dir", "")
+	i.mDefaultLanguage = values.String("default_language", abeshI18n.DefaultLanguage)
+
+	return nil
+}
+
+func (i *I18n) New() iface.ICapability {
+	return &I18n{}
+}
+
+func (i *I18n) Setup() error {
+	if len(i.mDir) == 0 {
+		return ErrDirNotDefined
+	}
+
+	catalog, err := abeshI18n.LoadDir(i.mDir, i.mDefaultLanguage)
+	if err != nil {
+		return err
+	}
+	abeshI18n.SetCatalog(catalog)
+
+	logger.L(i.ContractId()).Info("i18n setup complete",
+		zap.String("dir", i.mDir),
+		zap.Strings("languages", catalog.Languages()))
+
+	return nil
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&I18n{})
+}
@@ -0,0 +1,463 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+
+	"github.com/mkawserm/abesh/constant"
+	errors2 "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+	"github.com/mkawserm/abesh/utility"
+)
+
+var ErrMethodNotDefined = errors.New("method not defined")
+var ErrMethodAlreadyDefined = errors.New("method already defined")
+
+var errForbidden = errors2.Forbidden("", "forbidden", nil)
+
+type method struct {
+	service              iface.IService
+	authorizer           iface.IAuthorizer
+	authorizerExpression string
+}
+
+type JSONRPC struct {
+	mHost          string
+	mPort          string
+	mCertFile      string
+	mKeyFile       string
+	mPath          string
+	mWebSocketPath string
+
+	mValues           model.ConfigMap
+	mHttpServer       *http.Server
+	mHttpServerMux    *http.ServeMux
+	mEventTransmitter iface.IEventTransmitter
+
+	mRequestTimeout       time.Duration
+	mMaxBodySize          int64
+	mMaxBatchSize         int
+	mWebSocketIdleTimeout time.Duration
+
+	mMethodMap map[string]*method
+}
+
+func (j *JSONRPC) Name() string {
+	return "abesh_jsonrpc"
+}
+
+func (j *JSONRPC) Version() string {
+	return constant.Version
+}
+
+func (j *JSONRPC) Category() string {
+	return string(constant.CategoryTrigger)
+}
+
+func (j *JSONRPC) ContractId() string {
+	return "abesh:jsonrpc"
+}
+
+func (j *JSONRPC) GetConfigMap() model.ConfigMap {
+	return j.mValues
+}
+
+func (j *JSONRPC) SetConfigMap(values model.ConfigMap) error {
+	j.mValues = values
+
+	j.mHost = values.String("host", "0.0.0.0")
+	j.mPort = values.String("port", "8081")
+
+	j.mCertFile = values.String("cert_file", "")
+	j.mKeyFile = values.String("key_file", "")
+
+	j.mPath = values.String("path", "/rpc")
+	j.mWebSocketPath = values.String("websocket_path", "")
+
+	j.mRequestTimeout = values.Duration("default_request_timeout", time.Second)
+	j.mMaxBodySize = values.Int64("max_body_size", 1<<20)
+	j.mMaxBatchSize = values.Int("max_batch_size", 100)
+	j.mWebSocketIdleTimeout = values.Duration("websocket_idle_timeout", time.Minute)
+
+	return nil
+}
+
+func (j *JSONRPC) SetEventTransmitter(eventTransmitter iface.IEventTransmitter) error {
+	j.mEventTransmitter = eventTransmitter
+	return nil
+}
+
+func (j *JSONRPC) GetEventTransmitter() iface.IEventTransmitter {
+	return j.mEventTransmitter
+}
+
+func (j *JSONRPC) New() iface.ICapability {
+	return &JSONRPC{}
+}
+
+func (j *JSONRPC) Setup() error {
+	j.mHttpServer = new(http.Server)
+	j.mHttpServerMux = new(http.ServeMux)
+	j.mMethodMap = make(map[string]*method)
+
+	j.mHttpServer.Handler = j.mHttpServerMux
+	j.mHttpServer.Addr = j.mHost + ":" + j.mPort
+
+	j.mHttpServerMux.HandleFunc(j.mPath, j.httpHandler)
+
+	if len(j.mWebSocketPath) != 0 {
+		j.mHttpServerMux.Handle(j.mWebSocketPath, websocket.Handler(j.webSocketHandler))
+		logger.L(j.ContractId()).Info("websocket enabled", zap.String("websocket_path", j.mWebSocketPath))
+	}
+
+	logger.L(j.ContractId()).Info("jsonrpc server setup complete",
+		zap.String("host", j.mHost),
+		zap.String("port", j.mPort),
+		zap.String("path", j.mPath))
+
+	return nil
+}
+
+func (j *JSONRPC) Start(_ context.Context) error {
+	logger.L(j.ContractId()).Info("jsonrpc server started at " + j.mHttpServer.Addr)
+
+	if len(j.mCertFile) != 0 && len(j.mKeyFile) != 0 {
+		if err := j.mHttpServer.ListenAndServeTLS(j.mCertFile, j.mKeyFile); err != http.ErrServerClosed {
+			return err
+		}
+	} else {
+		if err := j.mHttpServer.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *JSONRPC) Stop(ctx context.Context) error {
+	if j.mHttpServer != nil {
+		return j.mHttpServer.Shutdown(ctx)
+	}
+
+	return nil
+}
+
+func (j *JSONRPC) AddService(
+	authorizer iface.IAuthorizer,
+	authorizerExpression string,
+	triggerValues model.ConfigMap,
+	service iface.IService) error {
+
+	logger.L(j.ContractId()).Debug("service add",
+		zap.Any("authorizer", authorizer),
+		zap.Any("expression", authorizerExpression),
+		zap.Any("triggerValues", triggerValues))
+
+	var name string
+	if name = strings.TrimSpace(triggerValues.String("method", "")); len(name) == 0 {
+		return ErrMethodNotDefined
+	}
+
+	if _, found := j.mMethodMap[name]; found {
+		return ErrMethodAlreadyDefined
+	}
+
+	j.mMethodMap[name] = &method{
+		service:              service,
+		authorizer:           authorizer,
+		authorizerExpression: authorizerExpression,
+	}
+
+	return nil
+}
+
+// AddAuthorizer replaces the authorizer of an already added method,
+// it allows the rpcs section of the manifest to target this trigger
+func (j *JSONRPC) AddAuthorizer(authorizer iface.IAuthorizer, authorizerExpression string, name string) error {
+	m, found := j.mMethodMap[name]
+	if !found {
+		return ErrMethodNotDefined
+	}
+
+	m.authorizer = authorizer
+	m.authorizerExpression = authorizerExpression
+
+	return nil
+}
+
+func (j *JSONRPC) TransmitInputEvent(contractId string, inputEvent *model.Event) {
+	if j.GetEventTransmitter() != nil {
+		go func() {
+			err := j.GetEventTransmitter().TransmitInputEvent(contractId, inputEvent)
+			if err != nil {
+				logger.L(j.ContractId()).Error(err.Error(),
+					zap.String("version", j.Version()),
+					zap.String("name", j.Name()),
+					zap.String("contract_id", j.ContractId()))
+			}
+		}()
+	}
+}
+
+func (j *JSONRPC) TransmitOutputEvent(contractId string, outputEvent *model.Event) {
+	if j.GetEventTransmitter() != nil {
+		go func() {
+			err := j.GetEventTransmitter().TransmitOutputEvent(contractId, outputEvent)
+			if err != nil {
+				logger.L(j.ContractId()).Error(err.Error(),
+					zap.String("version", j.Version()),
+					zap.String("name", j.Name()),
+					zap.String("contract_id", j.ContractId()))
+			}
+		}()
+	}
+}
+
+func (j *JSONRPC) buildMetadata(request *http.Request) *model.Metadata {
+	metadata := &model.Metadata{}
+	metadata.Path = request.URL.EscapedPath()
+	metadata.Headers = make(map[string]string)
+	metadata.Query = make(map[string]string)
+	metadata.ContractIdList = append(metadata.ContractIdList, j.ContractId())
+
+	for k, v := range request.Header {
+		if len(v) > 0 {
+			metadata.SetHeaderValueList(k, v)
+		}
+	}
+
+	for k, v := range request.URL.Query() {
+		if len(v) > 0 {
+			metadata.SetQueryValueList(k, v)
+		}
+	}
+
+	return metadata
+}
+
+func (j *JSONRPC) httpHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, j.mMaxBodySize))
+	if err != nil {
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	requestId := utility.RequestIdFromHeader(request.Header)
+	writer.Header().Set(utility.RequestIdHeader, requestId)
+
+	output := j.handle(logger.WithRequestId(request.Context(), requestId), j.buildMetadata(request), data)
+	if output == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if _, err = writer.Write(output); err != nil {
+		logger.L(j.ContractId()).Error(err.Error(),
+			zap.String("version", j.Version()),
+			zap.String("name", j.Name()),
+			zap.String("contract_id", j.ContractId()))
+	}
+}
+
+// webSocketHandler limits every message to max_body_size like the http
+// requests, the connection is closed when no message is received within
+// websocket_idle_timeout or a response is not sent within
+// default_request_timeout
+func (j *JSONRPC) webSocketHandler(conn *websocket.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	conn.MaxPayloadBytes = int(j.mMaxBodySize)
+	metadata := j.buildMetadata(conn.Request())
+
+	for {
+		var data []byte
+		_ = conn.SetReadDeadline(time.Now().Add(j.mWebSocketIdleTimeout))
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			logger.L(j.ContractId()).Debug("websocket closed", zap.Error(err))
+			return
+		}
+
+		// every message is a request of its own
+		output := j.handle(logger.WithRequestId(conn.Request().Context(), utility.NewRequestId()), metadata, data)
+		if output == nil {
+			continue
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(j.mRequestTimeout))
+		if err := websocket.Message.Send(conn, string(output)); err != nil {
+			logger.L(j.ContractId()).Error(err.Error(),
+				zap.String("version", j.Version()),
+				zap.String("name", j.Name()),
+				zap.String("contract_id", j.ContractId()))
+			return
+		}
+	}
+}
+
+// handle processes single or batch request payload and returns
+// the encoded response, nil means nothing should be sent back
+func (j *JSONRPC) handle(ctx context.Context, metadata *model.Metadata, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+
+	if len(data) != 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return j.encode(newErrorResponse(nil, newError(CodeParseError, "parse error")))
+		}
+
+		if len(batch) == 0 || len(batch) > j.mMaxBatchSize {
+			return j.encode(newErrorResponse(nil, newError(CodeInvalidRequest, "invalid request")))
+		}
+
+		responseList := make([]*Response, len(batch))
+		wg := sync.WaitGroup{}
+		for index := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responseList[i] = j.call(ctx, metadata, batch[i])
+			}(index)
+		}
+		wg.Wait()
+
+		output := make([]*Response, 0, len(responseList))
+		for _, r := range responseList {
+			if r != nil {
+				output = append(output, r)
+			}
+		}
+
+		if len(output) == 0 {
+			return nil
+		}
+
+		return j.encode(output)
+	}
+
+	if !json.Valid(data) {
+		return j.encode(newErrorResponse(nil, newError(CodeParseError, "parse error")))
+	}
+
+	if r := j.call(ctx, metadata, data); r != nil {
+		return j.encode(r)
+	}
+
+	return nil
+}
+
+func (j *JSONRPC) encode(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.L(j.ContractId()).Error(err.Error(),
+			zap.String("version", j.Version()),
+			zap.String("name", j.Name()),
+			zap.String("contract_id", j.ContractId()))
+		return nil
+	}
+
+	return data
+}
+
+// call executes one request object, notifications always return nil
+func (j *JSONRPC) call(ctx context.Context, baseMetadata *model.Metadata, data json.RawMessage) (response *Response) {
+	request := &Request{}
+	if err := json.Unmarshal(data, request); err != nil || request.JSONRPC != Version || len(request.Method) == 0 {
+		return newErrorResponse(nil, newError(CodeInvalidRequest, "invalid request"))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.LC(ctx, j.ContractId()).Error("panic data",
+				zap.String("method", request.Method),
+				zap.String("panic_msg", fmt.Sprintf("%v", r)))
+
+			response = newErrorResponse(request.Id, newError(CodeInternalError, "internal error"))
+		}
+
+		if request.IsNotification() {
+			response = nil
+		}
+	}()
+
+	m, found := j.mMethodMap[request.Method]
+	if !found {
+		return newErrorResponse(request.Id, newError(CodeMethodNotFound, "method not found"))
+	}
+
+	if len(request.Params) != 0 && request.Params[0] != '{' && request.Params[0] != '[' {
+		return newErrorResponse(request.Id, newError(CodeInvalidParams, "invalid params"))
+	}
+
+	metadata := model.CloneMetadata(baseMetadata)
+	metadata.Method = request.Method
+	metadata.UniqueId = logger.RequestId(ctx)
+
+	if m.authorizer != nil {
+		if !m.authorizer.IsAuthorized(m.authorizerExpression, metadata) {
+			return newErrorResponse(request.Id, ToError(errForbidden))
+		}
+	}
+
+	inputEvent := &model.Event{
+		Metadata: metadata,
+		TypeUrl:  "application/json",
+		Value:    request.Params,
+	}
+
+	j.TransmitInputEvent(m.service.ContractId(), inputEvent)
+
+	nCtx, cancel := context.WithTimeout(ctx, j.mRequestTimeout)
+	defer cancel()
+
+	outputEvent, err := m.service.Serve(nCtx, inputEvent)
+	if err != nil {
+		return newErrorResponse(request.Id, ToError(err))
+	}
+
+	response = &Response{JSONRPC: Version, Result: json.RawMessage("null"), Id: request.Id}
+	if outputEvent == nil {
+		return response
+	}
+
+	j.TransmitOutputEvent(m.service.ContractId(), outputEvent)
+
+	if len(outputEvent.Value) == 0 {
+		return response
+	}
+
+	if json.Valid(outputEvent.Value) {
+		response.Result = outputEvent.Value
+	} else {
+		response.Result, _ = json.Marshal(string(outputEvent.Value))
+	}
+
+	return response
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&JSONRPC{})
+}
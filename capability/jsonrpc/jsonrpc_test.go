@@ -0,0 +1,221 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	errors2 "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+type testService struct {
+	iface.IService
+	count int32
+	err   error
+}
+
+func (s *testService) ContractId() string {
+	return "test:service"
+}
+
+func (s *testService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	atomic.AddInt32(&s.count, 1)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return model.GenerateOutputEvent(event.Metadata, s.ContractId(), "OK", 200, "application/json", event.Value), nil
+}
+
+func newTestJSONRPC(t *testing.T, serviceMap map[string]iface.IService) *JSONRPC {
+	j := &JSONRPC{}
+	_ = j.SetConfigMap(model.ConfigMap{"max_batch_size": "3"})
+	if err := j.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, service := range serviceMap {
+		if err := j.AddService(nil, "", model.ConfigMap{"method": name}, service); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return j
+}
+
+func post(j *JSONRPC, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	j.httpHandler(recorder, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	return recorder
+}
+
+func TestJSONRPC_Call(t *testing.T) {
+	echo := &testService{}
+	j := newTestJSONRPC(t, map[string]iface.IService{"echo": echo})
+
+	r := post(j, `{"jsonrpc":"2.0","method":"echo","params":{"a":1},"id":7}`)
+	if r.Code != http.StatusOK || r.Body.String() != `{"jsonrpc":"2.0","result":{"a":1},"id":7}` {
+		t.Errorf("call = %d %s", r.Code, r.Body.String())
+	}
+
+	// notifications are served without a response
+	if r = post(j, `{"jsonrpc":"2.0","method":"echo","params":[1]}`); r.Code != http.StatusNoContent || r.Body.Len() != 0 {
+		t.Errorf("notification = %d %s", r.Code, r.Body.String())
+	}
+
+	if atomic.LoadInt32(&echo.count) != 2 {
+		t.Errorf("count = %d, want 2", echo.count)
+	}
+
+	for body, code := range map[string]int{
+		`{"jsonrpc":"2.0","method":"echo"`:                      CodeParseError,
+		`{"jsonrpc":"1.0","method":"echo","id":1}`:              CodeInvalidRequest,
+		`{"jsonrpc":"2.0","method":"missing","id":1}`:           CodeMethodNotFound,
+		`{"jsonrpc":"2.0","method":"echo","params":"x","id":1}`: CodeInvalidParams,
+		`[]`: CodeInvalidRequest,
+		`[{"jsonrpc":"2.0","method":"echo","id":1},1,2,3]`: CodeInvalidRequest,
+	} {
+		response := &Response{}
+		if err := json.Unmarshal(post(j, body).Body.Bytes(), response); err != nil || response.Error == nil || response.Error.Code != int64(code) {
+			t.Errorf("%s = %+v, want %d", body, response.Error, code)
+		}
+	}
+}
+
+func TestJSONRPC_Batch(t *testing.T) {
+	j := newTestJSONRPC(t, map[string]iface.IService{"echo": &testService{}})
+
+	r := post(j, `[
+		{"jsonrpc":"2.0","method":"echo","params":[1],"id":1},
+		{"jsonrpc":"2.0","method":"echo","params":[2]},
+		{"jsonrpc":"2.0","method":"missing","id":3}
+	]`)
+
+	var responseList []*Response
+	if err := json.Unmarshal(r.Body.Bytes(), &responseList); err != nil {
+		t.Fatal(err)
+	}
+
+	// the notification has no response, the order is kept
+	if len(responseList) != 2 || string(responseList[0].Result) != "[1]" ||
+		responseList[1].Error == nil || responseList[1].Error.Code != CodeMethodNotFound || string(responseList[1].Id) != "3" {
+		t.Errorf("batch = %s", r.Body.String())
+	}
+
+	if r = post(j, `[{"jsonrpc":"2.0","method":"echo"}]`); r.Code != http.StatusNoContent {
+		t.Errorf("notification batch = %d %s", r.Code, r.Body.String())
+	}
+}
+
+func TestJSONRPC_WebSocket(t *testing.T) {
+	j := newTestJSONRPC(t, map[string]iface.IService{"echo": &testService{}})
+
+	server := httptest.NewServer(websocket.Handler(j.webSocketHandler))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for _, message := range []string{
+		`{"jsonrpc":"2.0","method":"echo","params":[0]}`,
+		`{"jsonrpc":"2.0","method":"echo","params":[1],"id":"a"}`,
+	} {
+		if err = websocket.Message.Send(conn, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the request is answered
+	var output string
+	if err = websocket.Message.Receive(conn, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	if output != `{"jsonrpc":"2.0","result":[1],"id":"a"}` {
+		t.Errorf("response = %s", output)
+	}
+}
+
+func TestJSONRPC_WebSocketLimits(t *testing.T) {
+	j := newTestJSONRPC(t, map[string]iface.IService{"echo": &testService{}})
+	j.mMaxBodySize = 64
+	j.mWebSocketIdleTimeout = 50 * time.Millisecond
+
+	server := httptest.NewServer(websocket.Handler(j.webSocketHandler))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// a message larger than max_body_size closes the connection
+	conn := dial()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := websocket.Message.Send(conn, `{"jsonrpc":"2.0","method":"echo","params":["`+strings.Repeat("a", 64)+`"],"id":1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	var output string
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := websocket.Message.Receive(conn, &output); err == nil {
+		t.Errorf("large message response = %s", output)
+	}
+
+	// an idle connection is closed
+	idle := dial()
+	defer func() {
+		_ = idle.Close()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	_ = idle.SetReadDeadline(time.Now().Add(time.Second))
+	if err := websocket.Message.Receive(idle, &output); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("idle connection error = %v", err)
+	}
+}
+
+func TestToError(t *testing.T) {
+	wrapped := fmt.Errorf("find: %w", errors2.NotFound("user", "missing", map[string]string{"id": "1"}))
+
+	e := ToError(wrapped)
+	if e.Code != 404 || e.Message != "not_found.user" || e.Data.(map[string]string)["id"] != "1" {
+		t.Errorf("wrapped = %+v", e)
+	}
+
+	if e = ToError(fmt.Errorf("serve: %w", context.DeadlineExceeded)); e.Code != 408 {
+		t.Errorf("deadline = %+v", e)
+	}
+
+	if e = ToError(fmt.Errorf("plain")); e.Code != CodeInternalError {
+		t.Errorf("plain = %+v", e)
+	}
+
+	j := newTestJSONRPC(t, map[string]iface.IService{"fail": &testService{err: wrapped}})
+	response := &Response{}
+	_ = json.Unmarshal(post(j, `{"jsonrpc":"2.0","method":"fail","id":1}`).Body.Bytes(), response)
+	if response.Error == nil || response.Error.Code != 404 {
+		t.Errorf("service error = %+v", response.Error)
+	}
+}
@@ -0,0 +1,82 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	errors2 "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+)
+
+const Version = "2.0"
+
+// JSON-RPC 2.0 pre-defined error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Request is a JSON-RPC 2.0 request object, a request without id is a notification
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Id      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the client expects no response
+func (r *Request) IsNotification() bool {
+	return r.Id == nil
+}
+
+// Response is a JSON-RPC 2.0 response object
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC 2.0 error object
+type Error struct {
+	Code    int64       `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func newError(code int64, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func newErrorResponse(id json.RawMessage, err *Error) *Response {
+	return &Response{JSONRPC: Version, Error: err, Id: id}
+}
+
+// ToError converts service error to JSON-RPC error object
+// errors.Error and iface.IError2 values keep their code, prefix and params,
+// wrapped ones too
+func ToError(err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errors2.Timeout("", "request timeout", nil)
+	}
+
+	var iError iface.IError2
+	if errors.As(err, &iError) {
+		e := &Error{
+			Code:    int64(iError.GetCode()),
+			Message: iError.GetPrefix(),
+		}
+
+		if len(iError.GetParams()) != 0 {
+			e.Data = iError.GetParams()
+		}
+
+		return e
+	}
+
+	return newError(CodeInternalError, "internal error")
+}
@@ -0,0 +1,201 @@
+package memkvstore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+type item struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func (i *item) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// MemKVStore keeps the values in memory, []byte values are stored as is
+// and everything else as json, a zero ttl never expires
+type MemKVStore struct {
+	mValues     model.ConfigMap
+	mMaxEntries int
+
+	mMutex   sync.RWMutex
+	mItemMap map[string]*item
+	mNowFunc func() time.Time
+}
+
+func (m *MemKVStore) Name() string {
+	return "abesh_memkvstore"
+}
+
+func (m *MemKVStore) Version() string {
+	return constant.Version
+}
+
+func (m *MemKVStore) Category() string {
+	return string(constant.CategoryKVStore)
+}
+
+func (m *MemKVStore) ContractId() string {
+	return "abesh:memkvstore"
+}
+
+func (m *MemKVStore) GetConfigMap() model.ConfigMap {
+	return m.mValues
+}
+
+func (m *MemKVStore) SetConfigMap(values model.ConfigMap) error {
+	m.mValues = values
+	m.mMaxEntries = values.Int("max_entries", 10000)
+
+	return nil
+}
+
+func (m *MemKVStore) Setup() error {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	m.mItemMap = make(map[string]*item)
+	if m.mNowFunc == nil {
+		m.mNowFunc = time.Now
+	}
+
+	return nil
+}
+
+func (m *MemKVStore) New() iface.ICapability {
+	return &MemKVStore{}
+}
+
+func (m *MemKVStore) Get(_ context.Context, key string, value interface{}) error {
+	m.mMutex.RLock()
+	i, found := m.mItemMap[key]
+	m.mMutex.RUnlock()
+
+	if !found || i.expired(m.mNowFunc()) {
+		return iface.ErrKeyNotfound
+	}
+
+	if v, ok := value.(*[]byte); ok {
+		*v = append([]byte{}, i.data...)
+		return nil
+	}
+
+	return json.Unmarshal(i.data, value)
+}
+
+func (m *MemKVStore) Set(_ context.Context, key string, value interface{}, ttl time.Duration) error {
+	i, err := m.newItem(value, ttl)
+	if err != nil {
+		return err
+	}
+
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	m.put(key, i)
+
+	return nil
+}
+
+func (m *MemKVStore) SetNX(_ context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	i, err := m.newItem(value, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	if current, found := m.mItemMap[key]; found && !current.expired(m.mNowFunc()) {
+		return false, nil
+	}
+	m.put(key, i)
+
+	return true, nil
+}
+
+func (m *MemKVStore) newItem(value interface{}, ttl time.Duration) (*item, error) {
+	var data []byte
+	if v, ok := value.([]byte); ok {
+		data = append([]byte{}, v...)
+	} else {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	i := &item{data: data}
+	if ttl > 0 {
+		i.expiresAt = m.mNowFunc().Add(ttl)
+	}
+
+	return i, nil
+}
+
+// put must be called with the write lock held
+func (m *MemKVStore) put(key string, i *item) {
+	if _, found := m.mItemMap[key]; !found && m.mMaxEntries > 0 && len(m.mItemMap) >= m.mMaxEntries {
+		m.evict(m.mNowFunc())
+	}
+	m.mItemMap[key] = i
+}
+
+// evict removes the expired items, when none expired the item closest
+// to its expiry is removed
+func (m *MemKVStore) evict(now time.Time) {
+	var candidate string
+	var candidateItem *item
+
+	for k, i := range m.mItemMap {
+		if i.expired(now) {
+			delete(m.mItemMap, k)
+			continue
+		}
+
+		if candidateItem == nil || (!i.expiresAt.IsZero() && (candidateItem.expiresAt.IsZero() || i.expiresAt.Before(candidateItem.expiresAt))) {
+			candidate, candidateItem = k, i
+		}
+	}
+
+	if len(m.mItemMap) >= m.mMaxEntries && candidateItem != nil {
+		delete(m.mItemMap, candidate)
+	}
+}
+
+func (m *MemKVStore) Delete(_ context.Context, key string) error {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	delete(m.mItemMap, key)
+	return nil
+}
+
+func (m *MemKVStore) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	count := 0
+	for k := range m.mItemMap {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.mItemMap, k)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&MemKVStore{})
+}
@@ -0,0 +1,78 @@
+package memkvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+)
+
+func TestMemKVStore(t *testing.T) {
+	now := time.Now()
+	m := &MemKVStore{mNowFunc: func() time.Time { return now }}
+	_ = m.SetConfigMap(model.ConfigMap{"max_entries": "2"})
+	_ = m.Setup()
+
+	ctx := context.Background()
+	_ = m.Set(ctx, "a:1", []byte("one"), time.Minute)
+	_ = m.Set(ctx, "a:2", map[string]int{"n": 2}, 0)
+
+	var data []byte
+	if err := m.Get(ctx, "a:1", &data); err != nil || string(data) != "one" {
+		t.Errorf("get = %q %v", data, err)
+	}
+
+	var value map[string]int
+	if err := m.Get(ctx, "a:2", &value); err != nil || value["n"] != 2 {
+		t.Errorf("get = %v %v", value, err)
+	}
+
+	// the full store evicts the entry closest to its expiry
+	_ = m.Set(ctx, "b:1", []byte("three"), time.Hour)
+	if err := m.Get(ctx, "a:1", &data); err != iface.ErrKeyNotfound {
+		t.Errorf("evicted error = %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := m.Get(ctx, "b:1", &data); err != iface.ErrKeyNotfound {
+		t.Errorf("expired error = %v", err)
+	}
+
+	if count, _ := m.DeletePrefix(ctx, "a:"); count != 1 {
+		t.Errorf("delete prefix = %d, want 1", count)
+	}
+
+	_ = m.Delete(ctx, "b:1")
+	if len(m.mItemMap) != 0 {
+		t.Errorf("items = %d, want 0", len(m.mItemMap))
+	}
+}
+
+func TestMemKVStore_SetNX(t *testing.T) {
+	now := time.Now()
+	m := &MemKVStore{mNowFunc: func() time.Time { return now }}
+	_ = m.SetConfigMap(model.ConfigMap{})
+	_ = m.Setup()
+
+	ctx := context.Background()
+	if ok, err := m.SetNX(ctx, "k", []byte("1"), time.Minute); !ok || err != nil {
+		t.Errorf("first set = %v %v", ok, err)
+	}
+
+	if ok, _ := m.SetNX(ctx, "k", []byte("2"), time.Minute); ok {
+		t.Error("existing key is set")
+	}
+
+	// an expired key counts as missing
+	now = now.Add(2 * time.Minute)
+	if ok, _ := m.SetNX(ctx, "k", []byte("3"), time.Minute); !ok {
+		t.Error("expired key is not set")
+	}
+
+	var data []byte
+	if err := m.Get(ctx, "k", &data); err != nil || string(data) != "3" {
+		t.Errorf("get = %q %v", data, err)
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	abeshMetrics "github.com/mkawserm/abesh/metrics"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+var ErrUnknownBackend = errors.New("unknown metrics backend")
+
+// Metrics provides the metrics of the other capabilities, the platform
+// injects them into every capability which implements iface.ISetMetrics
+type Metrics struct {
+	mValues model.ConfigMap
+
+	mBackend        string
+	mMaxCardinality int
+
+	mProvider iface.IMetricsProvider
+}
+
+func (m *Metrics) Name() string {
+	return "abesh_metrics"
+}
+
+func (m *Metrics) Version() string {
+	return constant.Version
+}
+
+func (m *Metrics) Category() string {
+	return string(constant.CategoryGeneral)
+}
+
+func (m *Metrics) ContractId() string {
+	return "abesh:metrics"
+}
+
+func (m *Metrics) GetConfigMap() model.ConfigMap {
+	return m.mValues
+}
+
+// SetConfigMap builds the provider, so that the metrics are
+// available before the setup of the other capabilities
+func (m *Metrics) SetConfigMap(values model.ConfigMap) error {
+	m.mValues = values
+
+	m.mBackend = strings.ToLower(values.String("backend", "prometheus"))
+	m.mMaxCardinality = values.Int("max_label_cardinality", abeshMetrics.DefaultMaxCardinality)
+
+	switch m.mBackend {
+	case "prometheus":
+		// the default registerer is exposed by the metrics path of the http server
+		m.mProvider = abeshMetrics.NewPrometheus(prometheus.DefaultRegisterer, m.mMaxCardinality)
+	case "memory":
+		m.mProvider = abeshMetrics.NewMemory(m.mMaxCardinality)
+	default:
+		return ErrUnknownBackend
+	}
+
+	return nil
+}
+
+func (m *Metrics) New() iface.ICapability {
+	return &Metrics{}
+}
+
+func (m *Metrics) Metrics(namespace string) iface.IMetrics {
+	return m.mProvider.Metrics(namespace)
+}
+
+// Provider returns the backend, *metrics.Memory for the memory backend
+func (m *Metrics) Provider() iface.IMetricsProvider {
+	return m.mProvider
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&Metrics{})
+}
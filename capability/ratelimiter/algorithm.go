@@ -0,0 +1,158 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown rate limit algorithm")
+
+// state is kept per key, the token bucket uses Tokens and Updated, the
+// sliding window uses Start, Count and Previous
+type state struct {
+	Tokens   float64 `json:"tokens,omitempty"`
+	Updated  int64   `json:"updated,omitempty"`
+	Start    int64   `json:"start,omitempty"`
+	Count    int     `json:"count,omitempty"`
+	Previous int     `json:"previous,omitempty"`
+}
+
+type algorithm interface {
+	take(s *state, now time.Time) *model.RateLimit
+
+	// ttl is how long an untouched state matters
+	ttl() time.Duration
+}
+
+func newAlgorithm(name string, limit int, burst int, period time.Duration) (algorithm, error) {
+	switch name {
+	case "token_bucket":
+		return &tokenBucket{
+			limit:  limit,
+			burst:  burst,
+			period: period,
+			rate:   float64(limit) / float64(period),
+		}, nil
+	case "sliding_window":
+		return &slidingWindow{limit: limit, period: period}, nil
+	}
+
+	return nil, ErrUnknownAlgorithm
+}
+
+// tokenBucket refills limit tokens per period up to burst tokens
+type tokenBucket struct {
+	limit  int
+	burst  int
+	period time.Duration
+
+	// tokens per nanosecond
+	rate float64
+}
+
+func (b *tokenBucket) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / b.rate))
+}
+
+func (b *tokenBucket) ttl() time.Duration {
+	return b.duration(float64(b.burst))
+}
+
+func (b *tokenBucket) take(s *state, now time.Time) *model.RateLimit {
+	if s.Updated == 0 {
+		s.Tokens = float64(b.burst)
+	} else if elapsed := now.UnixNano() - s.Updated; elapsed > 0 {
+		s.Tokens = math.Min(float64(b.burst), s.Tokens+float64(elapsed)*b.rate)
+	}
+
+	if now.UnixNano() > s.Updated {
+		s.Updated = now.UnixNano()
+	}
+
+	r := &model.RateLimit{Limit: b.limit, Burst: b.burst, Window: b.period}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = b.duration(1 - s.Tokens)
+	}
+
+	r.Remaining = int(s.Tokens)
+	r.Reset = b.duration(float64(b.burst) - s.Tokens)
+
+	return r
+}
+
+// slidingWindow weights the count of the previous window by its part
+// still inside the sliding window
+type slidingWindow struct {
+	limit  int
+	period time.Duration
+}
+
+func (w *slidingWindow) ttl() time.Duration {
+	return 2 * w.period
+}
+
+func (w *slidingWindow) take(s *state, now time.Time) *model.RateLimit {
+	start := now.Truncate(w.period).UnixNano()
+
+	// a window started by a clock ahead of this one is kept
+	if s.Start > start {
+		start = s.Start
+	}
+
+	switch s.Start {
+	case start:
+	case start - int64(w.period):
+		s.Previous, s.Count = s.Count, 0
+	default:
+		s.Previous, s.Count = 0, 0
+	}
+	s.Start = start
+
+	elapsed := now.UnixNano() - start
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	estimate := float64(s.Previous)*(1-float64(elapsed)/float64(w.period)) + float64(s.Count)
+
+	r := &model.RateLimit{
+		Limit:  w.limit,
+		Window: w.period,
+		Reset:  time.Duration(int64(w.period) - elapsed),
+	}
+
+	if estimate+1 <= float64(w.limit) {
+		s.Count++
+		estimate++
+		r.Allowed = true
+	} else {
+		r.RetryAfter = w.retryAfter(s, elapsed)
+	}
+
+	if remaining := int(float64(w.limit) - estimate); remaining > 0 {
+		r.Remaining = remaining
+	}
+
+	return r
+}
+
+// retryAfter returns the time until the estimate allows one more request
+func (w *slidingWindow) retryAfter(s *state, elapsed int64) time.Duration {
+	period := float64(w.period)
+
+	// the previous window still weighs too much, s.Previous is not zero
+	// as the request would be allowed otherwise
+	if s.Count+1 <= w.limit {
+		return time.Duration(math.Ceil(period*(1-float64(w.limit-s.Count-1)/float64(s.Previous)) - float64(elapsed)))
+	}
+
+	// the current window becomes the previous one
+	next := math.Max(0, period*(1-float64(w.limit-1)/float64(s.Count)))
+	return time.Duration(math.Ceil(period - float64(elapsed) + next))
+}
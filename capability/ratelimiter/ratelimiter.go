@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+var ErrInvalidLimit = errors.New("rate limit must be positive")
+var ErrInvalidPeriod = errors.New("rate limit period must be positive")
+var ErrStoreNotFound = errors.New("rate limit store not found")
+
+// RateLimiter limits the requests per key, it is configured with:
+//
+//	algorithm   token_bucket (default) or sliding_window
+//	limit       requests per period
+//	period      1m by default
+//	burst       token bucket capacity, the limit by default
+//	store       contract id of an iface.IKVStore shared by the instances,
+//	            the states are kept in memory without it
+//	key_prefix  prefix of the store keys, ratelimit: by default
+type RateLimiter struct {
+	mValues             model.ConfigMap
+	mCapabilityRegistry iface.ICapabilityRegistry
+
+	mAlgorithm algorithm
+	mStore     store
+	mKeyPrefix string
+	mNowFunc   func() time.Time
+}
+
+func (r *RateLimiter) Name() string {
+	return "abesh_ratelimiter"
+}
+
+func (r *RateLimiter) Version() string {
+	return constant.Version
+}
+
+func (r *RateLimiter) Category() string {
+	return string(constant.CategoryGeneral)
+}
+
+func (r *RateLimiter) ContractId() string {
+	return "abesh:ratelimiter"
+}
+
+func (r *RateLimiter) GetConfigMap() model.ConfigMap {
+	return r.mValues
+}
+
+func (r *RateLimiter) SetConfigMap(values model.ConfigMap) error {
+	r.mValues = values
+	r.mKeyPrefix = values.String("key_prefix", "ratelimit:")
+
+	return nil
+}
+
+func (r *RateLimiter) SetCapabilityRegistry(capabilityRegistry iface.ICapabilityRegistry) error {
+	r.mCapabilityRegistry = capabilityRegistry
+	return nil
+}
+
+func (r *RateLimiter) Setup() error {
+	if r.mNowFunc == nil {
+		r.mNowFunc = time.Now
+	}
+
+	limit := r.mValues.Int("limit", 0)
+	if limit <= 0 {
+		return ErrInvalidLimit
+	}
+
+	period := r.mValues.Duration("period", time.Minute)
+	if period <= 0 {
+		return ErrInvalidPeriod
+	}
+
+	burst := r.mValues.Int("burst", limit)
+	if burst <= 0 {
+		return ErrInvalidLimit
+	}
+
+	var err error
+	if r.mAlgorithm, err = newAlgorithm(r.mValues.String("algorithm", "token_bucket"), limit, burst, period); err != nil {
+		return err
+	}
+
+	contractId := r.mValues.String("store", "")
+	if len(contractId) == 0 {
+		r.mStore = newMemoryStore(r.mNowFunc)
+		return nil
+	}
+
+	var kv iface.IKVStore
+	if r.mCapabilityRegistry != nil {
+		kv, _ = r.mCapabilityRegistry.Capability(contractId).(iface.IKVStore)
+	}
+
+	if kv == nil {
+		return fmt.Errorf("%w: %s", ErrStoreNotFound, contractId)
+	}
+	r.mStore = &kvStore{store: kv}
+
+	return nil
+}
+
+func (r *RateLimiter) New() iface.ICapability {
+	return &RateLimiter{}
+}
+
+func (r *RateLimiter) Take(ctx context.Context, key string) (*model.RateLimit, error) {
+	var result *model.RateLimit
+	now := r.mNowFunc()
+
+	if err := r.mStore.update(ctx, r.mKeyPrefix+key, r.mAlgorithm.ttl(), func(s *state) {
+		result = r.mAlgorithm.take(s, now)
+	}); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&RateLimiter{})
+}
@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/capability/memkvstore"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+func newTestLimiter(t *testing.T, values model.ConfigMap, now *time.Time, capabilityRegistry iface.ICapabilityRegistry) *RateLimiter {
+	r := &RateLimiter{mNowFunc: func() time.Time { return *now }}
+	_ = r.SetConfigMap(values)
+	_ = r.SetCapabilityRegistry(capabilityRegistry)
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := newTestLimiter(t, model.ConfigMap{"limit": "2", "period": "1s", "burst": "3"}, &now, nil)
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		l, _ := r.Take(ctx, "a")
+		// the limit is per period, the burst is the bucket capacity
+		if !l.Allowed || l.Remaining != i || l.Limit != 2 || l.Burst != 3 {
+			t.Fatalf("take = %+v, want remaining %d", l, i)
+		}
+	}
+
+	l, _ := r.Take(ctx, "a")
+	if l.Allowed || l.RetryAfter != 500*time.Millisecond || l.Reset != 1500*time.Millisecond {
+		t.Errorf("limited = %+v", l)
+	}
+
+	// the keys are limited separately
+	if l, _ = r.Take(ctx, "b"); !l.Allowed {
+		t.Errorf("other key = %+v", l)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if l, _ = r.Take(ctx, "a"); !l.Allowed || l.Remaining != 0 {
+		t.Errorf("refilled = %+v", l)
+	}
+}
+
+func TestRateLimiter_SlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := &memkvstore.MemKVStore{}
+	_ = store.SetConfigMap(model.ConfigMap{})
+	_ = store.Setup()
+
+	capabilityRegistry := registry.NewCapabilityRegistry()
+	capabilityRegistry.RegisterCapability("kv", store)
+
+	r := newTestLimiter(t, model.ConfigMap{
+		"algorithm": "sliding_window",
+		"limit":     "4",
+		"period":    "10s",
+		"store":     "kv",
+	}, &now, capabilityRegistry)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		if l, _ := r.Take(ctx, "a"); !l.Allowed {
+			t.Fatalf("take %d = %+v", i, l)
+		}
+	}
+
+	l, _ := r.Take(ctx, "a")
+	if l.Allowed || l.Remaining != 0 || l.Reset != 10*time.Second {
+		t.Fatalf("limited = %+v", l)
+	}
+
+	// 4 requests of the previous window weigh 3 a quarter into the next
+	if l.RetryAfter != 12500*time.Millisecond {
+		t.Errorf("retry after = %v, want 12.5s", l.RetryAfter)
+	}
+
+	now = now.Add(l.RetryAfter)
+	if l, _ = r.Take(ctx, "a"); !l.Allowed || l.Remaining != 0 {
+		t.Errorf("next window = %+v", l)
+	}
+
+	if l, _ = r.Take(ctx, "a"); l.Allowed {
+		t.Errorf("over the estimate = %+v", l)
+	}
+
+	// the state is shared through the store
+	other := newTestLimiter(t, model.ConfigMap{"algorithm": "sliding_window", "limit": "4", "period": "10s", "store": "kv"}, &now, capabilityRegistry)
+	if l, _ = other.Take(ctx, "a"); l.Allowed {
+		t.Errorf("shared = %+v", l)
+	}
+}
+
+func TestRateLimiter_Setup(t *testing.T) {
+	for _, c := range []struct {
+		values model.ConfigMap
+		want   error
+	}{
+		{model.ConfigMap{}, ErrInvalidLimit},
+		{model.ConfigMap{"limit": "1", "period": "0s"}, ErrInvalidPeriod},
+		{model.ConfigMap{"limit": "1", "algorithm": "unknown"}, ErrUnknownAlgorithm},
+		{model.ConfigMap{"limit": "1", "store": "missing"}, ErrStoreNotFound},
+	} {
+		r := &RateLimiter{}
+		_ = r.SetConfigMap(c.values)
+
+		if err := r.Setup(); !errors.Is(err, c.want) {
+			t.Errorf("%v error = %v, want %v", c.values, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/mkawserm/abesh/iface"
+)
+
+// store changes the state of a key under a lock, a missing or an
+// expired state is zero
+type store interface {
+	update(ctx context.Context, key string, ttl time.Duration, fn func(s *state)) error
+}
+
+type memoryEntry struct {
+	state     state
+	expiresAt time.Time
+}
+
+// memoryStore keeps the states of this instance, the expired states are
+// removed at most once per ttl
+type memoryStore struct {
+	mutex    sync.Mutex
+	entryMap map[string]*memoryEntry
+	sweepAt  time.Time
+	nowFunc  func() time.Time
+}
+
+func newMemoryStore(nowFunc func() time.Time) *memoryStore {
+	return &memoryStore{
+		entryMap: make(map[string]*memoryEntry),
+		nowFunc:  nowFunc,
+	}
+}
+
+func (m *memoryStore) update(_ context.Context, key string, ttl time.Duration, fn func(s *state)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.nowFunc()
+	if now.After(m.sweepAt) {
+		for k, e := range m.entryMap {
+			if now.After(e.expiresAt) {
+				delete(m.entryMap, k)
+			}
+		}
+		m.sweepAt = now.Add(ttl)
+	}
+
+	e, found := m.entryMap[key]
+	if !found || now.After(e.expiresAt) {
+		e = &memoryEntry{}
+		m.entryMap[key] = e
+	}
+
+	fn(&e.state)
+	e.expiresAt = now.Add(ttl)
+
+	return nil
+}
+
+// kvStore shares the states through an iface.IKVStore, the updates of
+// this instance are serialized but the instances read and write the
+// state without a transaction so that a few requests may pass the limit
+type kvStore struct {
+	store    iface.IKVStore
+	lockList [64]sync.Mutex
+}
+
+func (k *kvStore) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return &k.lockList[h.Sum32()%uint32(len(k.lockList))]
+}
+
+func (k *kvStore) update(ctx context.Context, key string, ttl time.Duration, fn func(s *state)) error {
+	l := k.lock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	s := &state{}
+
+	var data []byte
+	if err := k.store.Get(ctx, key, &data); err == nil {
+		if json.Unmarshal(data, s) != nil {
+			s = &state{}
+		}
+	} else if !errors.Is(err, iface.ErrKeyNotfound) {
+		return err
+	}
+
+	fn(s)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return k.store.Set(ctx, key, data, ttl)
+}
@@ -0,0 +1,201 @@
+package reverseproxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrUnknownBalancer = errors.New("unknown balancer")
+var ErrUnknownHashKey = errors.New("unknown hash key")
+
+// upstream is healthy until the active health check says otherwise
+type upstream struct {
+	url         *url.URL
+	unhealthy   int32
+	connections int64
+
+	// only touched by the health checker
+	successCount int
+	failureCount int
+}
+
+func (u *upstream) isHealthy() bool {
+	return atomic.LoadInt32(&u.unhealthy) == 0
+}
+
+func (u *upstream) setHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&u.unhealthy, 0)
+	} else {
+		atomic.StoreInt32(&u.unhealthy, 1)
+	}
+}
+
+func (u *upstream) active() int64 {
+	return atomic.LoadInt64(&u.connections)
+}
+
+func (u *upstream) acquire() {
+	atomic.AddInt64(&u.connections, 1)
+}
+
+func (u *upstream) release() {
+	atomic.AddInt64(&u.connections, -1)
+}
+
+// balancer picks a healthy upstream which is not in the tried set,
+// nil is returned when none is left
+type balancer interface {
+	pick(key string, tried map[*upstream]bool) *upstream
+}
+
+func available(u *upstream, tried map[*upstream]bool) bool {
+	return u.isHealthy() && !tried[u]
+}
+
+type roundRobin struct {
+	upstreamList []*upstream
+	counter      uint64
+}
+
+func (r *roundRobin) pick(_ string, tried map[*upstream]bool) *upstream {
+	n := uint64(len(r.upstreamList))
+	start := atomic.AddUint64(&r.counter, 1) - 1
+
+	for i := uint64(0); i < n; i++ {
+		if u := r.upstreamList[(start+i)%n]; available(u, tried) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+type leastConnections struct {
+	upstreamList []*upstream
+	counter      uint64
+}
+
+// pick rotates the starting point so that ties are spread evenly
+func (l *leastConnections) pick(_ string, tried map[*upstream]bool) *upstream {
+	n := uint64(len(l.upstreamList))
+	start := atomic.AddUint64(&l.counter, 1) - 1
+
+	var selected *upstream
+	for i := uint64(0); i < n; i++ {
+		u := l.upstreamList[(start+i)%n]
+		if !available(u, tried) {
+			continue
+		}
+
+		if selected == nil || u.active() < selected.active() {
+			selected = u
+		}
+	}
+
+	return selected
+}
+
+type ringNode struct {
+	hash     uint32
+	upstream *upstream
+}
+
+// consistentHash places replicas of every upstream on a ring, a key is
+// served by the first available upstream clockwise from its hash
+type consistentHash struct {
+	ring []ringNode
+}
+
+func hashOf(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func newConsistentHash(upstreamList []*upstream, replicas int) *consistentHash {
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	c := &consistentHash{ring: make([]ringNode, 0, len(upstreamList)*replicas)}
+	for _, u := range upstreamList {
+		for i := 0; i < replicas; i++ {
+			c.ring = append(c.ring, ringNode{hash: hashOf(fmt.Sprintf("%s#%d", u.url.String(), i)), upstream: u})
+		}
+	}
+
+	sort.Slice(c.ring, func(i, j int) bool {
+		return c.ring[i].hash < c.ring[j].hash
+	})
+
+	return c
+}
+
+func (c *consistentHash) pick(key string, tried map[*upstream]bool) *upstream {
+	n := len(c.ring)
+	hash := hashOf(key)
+	start := sort.Search(n, func(i int) bool {
+		return c.ring[i].hash >= hash
+	})
+
+	for i := 0; i < n; i++ {
+		if u := c.ring[(start+i)%n].upstream; available(u, tried) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func newBalancer(name string, upstreamList []*upstream, replicas int) (balancer, error) {
+	switch name {
+	case "round_robin":
+		return &roundRobin{upstreamList: upstreamList}, nil
+	case "least_connections":
+		return &leastConnections{upstreamList: upstreamList}, nil
+	case "consistent_hash":
+		return newConsistentHash(upstreamList, replicas), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBalancer, name)
+}
+
+// newKeyFunc returns the consistent hash key of an event, the key is
+// path, header:<name> or query:<name>, the path is used when empty
+func newKeyFunc(hashKey string) (func(metadata *model.Metadata) string, error) {
+	source, name := hashKey, ""
+	if index := strings.Index(hashKey, ":"); index >= 0 {
+		source, name = hashKey[:index], strings.TrimSpace(hashKey[index+1:])
+	}
+
+	switch {
+	case source == "path" && len(name) == 0:
+		return func(metadata *model.Metadata) string {
+			return metadata.GetPath()
+		}, nil
+	case source == "header" && len(name) != 0:
+		return func(metadata *model.Metadata) string {
+			if v := metadata.HeaderValueList(name); len(v) != 0 && len(v[0]) != 0 {
+				return v[0]
+			}
+			return metadata.GetPath()
+		}, nil
+	case source == "query" && len(name) != 0:
+		return func(metadata *model.Metadata) string {
+			if v := metadata.QueryValueList(name); len(v) != 0 && len(v[0]) != 0 {
+				return v[0]
+			}
+			return metadata.GetPath()
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownHashKey, hashKey)
+}
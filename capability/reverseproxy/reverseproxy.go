@@ -0,0 +1,553 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/capability/httpclient"
+	"github.com/mkawserm/abesh/constant"
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+var ErrUpstreamNotDefined = errors.New("upstream not defined")
+var ErrInvalidUpstream = errors.New("invalid upstream")
+var ErrResponseTooLarge = errors.New("upstream response too large")
+
+// hopHeaderList belongs to a single connection and is never forwarded
+var hopHeaderList = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards the events to a set of upstreams, the transport
+// is an httpclient configured from the same values
+type ReverseProxy struct {
+	mValues model.ConfigMap
+
+	mUpstreamList []*upstream
+	mBalancer     balancer
+	mKeyFunc      func(metadata *model.Metadata) string
+	mRetryCount   int
+
+	mMaxResponseSize int64
+
+	mStripPrefix        string
+	mAddPrefix          string
+	mRewritePattern     *regexp.Regexp
+	mRewriteReplacement string
+
+	mHealthCheckPath     string
+	mHealthCheckInterval time.Duration
+	mHealthCheckTimeout  time.Duration
+	mHealthyThreshold    int
+	mUnhealthyThreshold  int
+
+	mHttpClient *httpclient.HTTPClient
+
+	mMutex sync.Mutex
+	mStop  chan struct{}
+}
+
+func (r *ReverseProxy) Name() string {
+	return "abesh_reverseproxy"
+}
+
+func (r *ReverseProxy) Version() string {
+	return constant.Version
+}
+
+func (r *ReverseProxy) Category() string {
+	return string(constant.CategoryService)
+}
+
+func (r *ReverseProxy) ContractId() string {
+	return "abesh:reverseproxy"
+}
+
+func (r *ReverseProxy) GetConfigMap() model.ConfigMap {
+	return r.mValues
+}
+
+func (r *ReverseProxy) SetConfigMap(values model.ConfigMap) error {
+	r.mValues = values
+
+	r.mRetryCount = values.Int("retry_count", 0)
+	r.mMaxResponseSize = values.Int64("max_response_size", 10<<20)
+
+	r.mStripPrefix = values.String("strip_prefix", "")
+	r.mAddPrefix = values.String("add_prefix", "")
+	r.mRewriteReplacement = values.String("rewrite_replacement", "")
+
+	r.mHealthCheckPath = values.String("health_check_path", "")
+	r.mHealthCheckInterval = values.Duration("health_check_interval", 10*time.Second)
+	r.mHealthCheckTimeout = values.Duration("health_check_timeout", 2*time.Second)
+	r.mHealthyThreshold = values.Int("healthy_threshold", 1)
+	r.mUnhealthyThreshold = values.Int("unhealthy_threshold", 1)
+
+	return nil
+}
+
+func (r *ReverseProxy) Setup() error {
+	r.mUpstreamList = nil
+	for _, s := range r.mValues.StringList("upstreams", ",", nil) {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(s)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return ErrInvalidUpstream
+		}
+		r.mUpstreamList = append(r.mUpstreamList, &upstream{url: u})
+	}
+
+	if len(r.mUpstreamList) == 0 {
+		return ErrUpstreamNotDefined
+	}
+
+	var err error
+	if r.mBalancer, err = newBalancer(r.mValues.String("balancer", "round_robin"),
+		r.mUpstreamList,
+		r.mValues.Int("hash_replicas", 100)); err != nil {
+		return err
+	}
+
+	if r.mKeyFunc, err = newKeyFunc(r.mValues.String("hash_key", "path")); err != nil {
+		return err
+	}
+
+	r.mRewritePattern = nil
+	if pattern := r.mValues.String("rewrite_pattern", ""); len(pattern) != 0 {
+		if r.mRewritePattern, err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+
+	// the transport settings are the httpclient keys of the same values
+	r.mHttpClient = &httpclient.HTTPClient{}
+	if err = r.mHttpClient.SetConfigMap(r.mValues); err != nil {
+		return err
+	}
+
+	return r.mHttpClient.Setup()
+}
+
+// Start runs the active health checks when health_check_path is set
+func (r *ReverseProxy) Start(_ context.Context) error {
+	if len(r.mHealthCheckPath) == 0 || r.mHealthCheckInterval <= 0 {
+		return nil
+	}
+
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
+
+	if r.mStop != nil {
+		return nil
+	}
+
+	r.mStop = make(chan struct{})
+	go r.healthCheckLoop(r.mStop)
+
+	return nil
+}
+
+func (r *ReverseProxy) Stop(_ context.Context) error {
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
+
+	if r.mStop != nil {
+		close(r.mStop)
+		r.mStop = nil
+	}
+
+	return nil
+}
+
+func (r *ReverseProxy) New() iface.ICapability {
+	return &ReverseProxy{}
+}
+
+func (r *ReverseProxy) healthCheckLoop(stop chan struct{}) {
+	r.checkAll()
+
+	ticker := time.NewTicker(r.mHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.checkAll()
+		}
+	}
+}
+
+func (r *ReverseProxy) checkAll() {
+	var wg sync.WaitGroup
+	for _, u := range r.mUpstreamList {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			r.check(u)
+		}(u)
+	}
+	wg.Wait()
+}
+
+// check marks the upstream after the configured number of consecutive
+// results, any status below 400 is a success
+func (r *ReverseProxy) check(u *upstream) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.mHealthCheckTimeout)
+	defer cancel()
+
+	target := *u.url
+	target.Path = joinPath(u.url.Path, r.mHealthCheckPath)
+	target.RawQuery = ""
+
+	success := false
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err == nil {
+		var response *http.Response
+		if response, err = r.mHttpClient.DoRequest(request); err == nil {
+			_, _ = io.Copy(ioutil.Discard, response.Body)
+			_ = response.Body.Close()
+			success = response.StatusCode < http.StatusBadRequest
+		}
+	}
+
+	if success {
+		u.failureCount = 0
+		u.successCount++
+		if !u.isHealthy() && u.successCount >= r.mHealthyThreshold {
+			u.setHealthy(true)
+			logger.L(r.ContractId()).Info("upstream is healthy", zap.String("upstream", u.url.String()))
+		}
+		return
+	}
+
+	u.successCount = 0
+	u.failureCount++
+	if u.isHealthy() && u.failureCount >= r.mUnhealthyThreshold {
+		u.setHealthy(false)
+		logger.L(r.ContractId()).Warn("upstream is unhealthy",
+			zap.String("upstream", u.url.String()),
+			zap.Error(err))
+	}
+}
+
+func joinPath(a, b string) string {
+	switch {
+	case len(a) == 0:
+		return b
+	case len(b) == 0:
+		return a
+	case strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/"):
+		return a + b[1:]
+	case !strings.HasSuffix(a, "/") && !strings.HasPrefix(b, "/"):
+		return a + "/" + b
+	}
+
+	return a + b
+}
+
+// rewrite applies strip_prefix, rewrite_pattern and add_prefix in order
+func (r *ReverseProxy) rewrite(path string) string {
+	if len(r.mStripPrefix) != 0 && strings.HasPrefix(path, r.mStripPrefix) {
+		path = path[len(r.mStripPrefix):]
+	}
+
+	if r.mRewritePattern != nil {
+		path = r.mRewritePattern.ReplaceAllString(path, r.mRewriteReplacement)
+	}
+
+	if len(r.mAddPrefix) != 0 {
+		path = joinPath(r.mAddPrefix, path)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+// targetURL rewrites the escaped path of the request, so that an escaped
+// slash stays a part of the segment
+func (r *ReverseProxy) targetURL(u *upstream, metadata *model.Metadata) string {
+	target := *u.url
+	target.RawPath = joinPath(u.url.EscapedPath(), r.rewrite(metadata.GetPath()))
+	if path, err := url.PathUnescape(target.RawPath); err == nil {
+		target.Path = path
+	} else {
+		target.Path = target.RawPath
+		target.RawPath = ""
+	}
+
+	query := url.Values{}
+	for k := range metadata.GetQuery() {
+		query[k] = metadata.QueryValueList(k)
+	}
+	for k := range metadata.GetQueryValues() {
+		query[k] = metadata.QueryValueList(k)
+	}
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+func isHopHeader(key string, connectionList []string) bool {
+	for _, h := range hopHeaderList {
+		if strings.EqualFold(h, key) {
+			return true
+		}
+	}
+
+	// headers listed in Connection are hop by hop too
+	for _, c := range connectionList {
+		for _, h := range strings.Split(c, ",") {
+			if strings.EqualFold(strings.TrimSpace(h), key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *ReverseProxy) newRequest(ctx context.Context, u *upstream, event *model.Event) (*http.Request, error) {
+	metadata := event.Metadata
+
+	var body io.Reader
+	if len(event.Value) != 0 {
+		body = bytes.NewReader(event.Value)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, metadata.GetMethod(), r.targetURL(u, metadata), body)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionList := metadata.HeaderValueList("Connection")
+	keyList := make(map[string]bool)
+	for k := range metadata.GetHeaders() {
+		keyList[k] = true
+	}
+	for k := range metadata.GetHeaderValues() {
+		keyList[k] = true
+	}
+
+	for k := range keyList {
+		if isHopHeader(k, connectionList) || strings.EqualFold(k, "Host") || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+
+		for _, v := range metadata.HeaderValueList(k) {
+			request.Header.Add(k, v)
+		}
+	}
+
+	setForwardedHeaders(request, metadata)
+
+	return request, nil
+}
+
+// setForwardedHeaders appends the client address to X-Forwarded-For and
+// sets the original host and scheme
+func setForwardedHeaders(request *http.Request, metadata *model.Metadata) {
+	if remoteAddr := metadata.GetRemoteAddr(); len(remoteAddr) != 0 {
+		if forwardedFor := request.Header.Values("X-Forwarded-For"); len(forwardedFor) != 0 {
+			remoteAddr = strings.Join(forwardedFor, ", ") + ", " + remoteAddr
+		}
+		request.Header.Set("X-Forwarded-For", remoteAddr)
+	}
+
+	if host := metadata.GetHost(); len(host) != 0 {
+		request.Header.Set("X-Forwarded-Host", host)
+	}
+
+	if scheme := metadata.GetScheme(); len(scheme) != 0 {
+		request.Header.Set("X-Forwarded-Proto", scheme)
+	}
+}
+
+// idempotentMethodMap are retried on another upstream after a failure
+var idempotentMethodMap = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryable reports whether the request can be sent again, a request
+// which failed to connect never reached the upstream
+func retryable(method string, err error) bool {
+	if idempotentMethodMap[strings.ToUpper(method)] {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// upstreamError translates a transport failure, timeouts are reported as
+// timeout and every other failure as bad_response.upstream
+func upstreamError(u *upstream, err error) error {
+	params := map[string]string{"upstream": u.url.Host}
+	if isTimeout(err) {
+		return abeshErrors.Timeout("upstream", "upstream timed out", params)
+	}
+
+	if errors.Is(err, ErrResponseTooLarge) {
+		return abeshErrors.BadResponse("upstream_response_too_large", "upstream response too large", params)
+	}
+
+	return abeshErrors.BadResponse("upstream", "upstream request failed", params)
+}
+
+func (r *ReverseProxy) forward(ctx context.Context, u *upstream, event *model.Event) (*http.Response, []byte, error) {
+	u.acquire()
+	defer u.release()
+
+	request, err := r.newRequest(ctx, u, event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := r.mHttpClient.DoRequest(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	// zero max_response_size disables the limit
+	if r.mMaxResponseSize > 0 && response.ContentLength > r.mMaxResponseSize {
+		return nil, nil, ErrResponseTooLarge
+	}
+
+	var body io.Reader = response.Body
+	if r.mMaxResponseSize > 0 {
+		body = io.LimitReader(response.Body, r.mMaxResponseSize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.mMaxResponseSize > 0 && int64(len(data)) > r.mMaxResponseSize {
+		return nil, nil, ErrResponseTooLarge
+	}
+
+	return response, data, nil
+}
+
+// Serve forwards the event to an upstream, the upstream response is
+// returned as is, including the error statuses
+func (r *ReverseProxy) Serve(ctx context.Context, event *model.Event) (*model.Event, error) {
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = &model.Metadata{}
+		event = &model.Event{Metadata: metadata, TypeUrl: event.TypeUrl, Value: event.Value}
+	}
+
+	key := r.mKeyFunc(metadata)
+	tried := make(map[*upstream]bool)
+
+	var lastErr error
+	for attempt := 0; attempt <= r.mRetryCount; attempt++ {
+		u := r.mBalancer.pick(key, tried)
+		if u == nil {
+			break
+		}
+		tried[u] = true
+
+		response, data, err := r.forward(ctx, u, event)
+		if err != nil {
+			logger.LC(ctx, r.ContractId()).Warn("upstream request failed",
+				zap.String("upstream", u.url.String()),
+				zap.Error(err))
+
+			lastErr = upstreamError(u, err)
+			// a request abandoned by the caller is not retried, neither a
+			// request which may have changed the upstream state
+			if ctx.Err() != nil || isTimeout(err) || errors.Is(err, ErrResponseTooLarge) ||
+				!retryable(metadata.GetMethod(), err) {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		return r.outputEvent(metadata, response, data), nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, abeshErrors.BadResponse("upstream_unavailable", "no healthy upstream", nil)
+}
+
+func (r *ReverseProxy) outputEvent(metadata *model.Metadata, response *http.Response, data []byte) *model.Event {
+	contentType := response.Header.Get("Content-Type")
+	output := model.GenerateOutputEvent(metadata,
+		r.ContractId(),
+		http.StatusText(response.StatusCode),
+		uint32(response.StatusCode),
+		contentType,
+		data)
+
+	// the response carries the upstream headers only
+	output.Metadata.Headers = make(map[string]string)
+	output.Metadata.HeaderValues = nil
+
+	connectionList := response.Header.Values("Connection")
+	for k, v := range response.Header {
+		if isHopHeader(k, connectionList) || k == "Content-Length" {
+			continue
+		}
+		output.Metadata.SetHeaderValueList(k, v)
+	}
+
+	return output
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&ReverseProxy{})
+}
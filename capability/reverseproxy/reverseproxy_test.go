@@ -0,0 +1,333 @@
+package reverseproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	abeshErrors "github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/model"
+)
+
+func newProxy(t *testing.T, values model.ConfigMap) *ReverseProxy {
+	r := &ReverseProxy{}
+	_ = r.SetConfigMap(values)
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func newEvent(method, path string) *model.Event {
+	return &model.Event{Metadata: &model.Metadata{Method: method, Path: path}}
+}
+
+func TestReverseProxy_Forward(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " +
+			strings.Join(r.Header.Values("X-Tag"), ",") + " " + r.Header.Get("Keep-Alive") + " " + string(body)))
+	}))
+	defer upstream.Close()
+
+	r := newProxy(t, model.ConfigMap{
+		"upstreams":           upstream.URL + "/base",
+		"strip_prefix":        "/api",
+		"rewrite_pattern":     "^/v1/",
+		"rewrite_replacement": "/v2/",
+	})
+
+	event := newEvent("POST", "/api/v1/users")
+	event.Value = []byte("hello")
+	event.Metadata.SetQueryValueList("id", []string{"1", "2"})
+	event.Metadata.SetHeaderValueList("X-Tag", []string{"a", "b"})
+	event.Metadata.SetHeaderValueList("Keep-Alive", []string{"timeout=5"})
+
+	output, err := r.Serve(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "POST /base/v2/users?id=1&id=2 a,b  hello"; string(output.Value) != want {
+		t.Errorf("body = %q, want %q", output.Value, want)
+	}
+
+	if output.Metadata.StatusCode != http.StatusCreated {
+		t.Errorf("status = %d, want %d", output.Metadata.StatusCode, http.StatusCreated)
+	}
+
+	if v := output.Metadata.HeaderValueList("Set-Cookie"); len(v) != 2 {
+		t.Errorf("set cookie = %v", v)
+	}
+
+	if v := output.Metadata.HeaderValueList("Connection"); len(v) != 0 {
+		t.Errorf("hop header forwarded = %v", v)
+	}
+
+	if v := output.Metadata.HeaderValueList("X-Tag"); len(v) != 0 {
+		t.Errorf("request header in the response = %v", v)
+	}
+}
+
+func newCountingUpstreams(n int, countList []int64) []*httptest.Server {
+	serverList := make([]*httptest.Server, n)
+	for i := 0; i < n; i++ {
+		index := i
+		serverList[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" {
+				atomic.AddInt64(&countList[index], 1)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	return serverList
+}
+
+func urlList(serverList []*httptest.Server) string {
+	var s []string
+	for _, server := range serverList {
+		s = append(s, server.URL)
+	}
+
+	return strings.Join(s, ",")
+}
+
+func TestReverseProxy_Balancer(t *testing.T) {
+	countList := make([]int64, 3)
+	serverList := newCountingUpstreams(3, countList)
+	for _, server := range serverList {
+		defer server.Close()
+	}
+
+	r := newProxy(t, model.ConfigMap{"upstreams": urlList(serverList)})
+	for i := 0; i < 6; i++ {
+		if _, err := r.Serve(context.Background(), newEvent("GET", "/")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, c := range countList {
+		if c != 2 {
+			t.Errorf("round robin upstream %d = %d, want 2", i, c)
+		}
+	}
+
+	countList[0], countList[1], countList[2] = 0, 0, 0
+	r = newProxy(t, model.ConfigMap{
+		"upstreams": urlList(serverList),
+		"balancer":  "consistent_hash",
+		"hash_key":  "header:X-User",
+	})
+
+	for i := 0; i < 5; i++ {
+		event := newEvent("GET", "/"+string(rune('a'+i)))
+		event.Metadata.SetHeaderValueList("X-User", []string{"alice"})
+		if _, err := r.Serve(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hit := 0
+	for _, c := range countList {
+		if c != 0 {
+			hit++
+		}
+	}
+	if hit != 1 {
+		t.Errorf("consistent hash spread = %v, want a single upstream", countList)
+	}
+
+	l := &leastConnections{upstreamList: r.mUpstreamList}
+	r.mUpstreamList[0].acquire()
+	r.mUpstreamList[2].acquire()
+	if u := l.pick("", nil); u != r.mUpstreamList[1] {
+		t.Errorf("least connections picked %v", u.url)
+	}
+
+	if _, err := newBalancer("random", nil, 0); err == nil {
+		t.Error("unknown balancer accepted")
+	}
+}
+
+func TestReverseProxy_HealthCheck(t *testing.T) {
+	var healthy int32 = 1
+	countList := make([]int64, 1)
+	serverList := newCountingUpstreams(1, countList)
+	defer serverList[0].Close()
+
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer flaky.Close()
+
+	r := newProxy(t, model.ConfigMap{
+		"upstreams":             flaky.URL + "," + serverList[0].URL,
+		"health_check_path":     "/health",
+		"health_check_interval": "1h",
+	})
+
+	atomic.StoreInt32(&healthy, 0)
+	r.checkAll()
+
+	for i := 0; i < 4; i++ {
+		if _, err := r.Serve(context.Background(), newEvent("GET", "/")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if countList[0] != 4 {
+		t.Errorf("healthy upstream served %d, want 4", countList[0])
+	}
+
+	serverList[0].Close()
+	r.mUpstreamList[1].setHealthy(false)
+
+	_, err := r.Serve(context.Background(), newEvent("GET", "/"))
+	if e, ok := err.(*abeshErrors.Error); !ok || e.GetPrefix() != "bad_response.upstream_unavailable" {
+		t.Errorf("error = %v, want upstream unavailable", err)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	r.checkAll()
+	if !r.mUpstreamList[0].isHealthy() {
+		t.Error("upstream did not recover")
+	}
+
+	if err = r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Stop(context.Background())
+}
+
+func TestReverseProxy_UpstreamError(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	r := newProxy(t, model.ConfigMap{"upstreams": closed.URL})
+	_, err := r.Serve(context.Background(), newEvent("GET", "/"))
+	if e, ok := err.(*abeshErrors.Error); !ok || e.GetPrefix() != "bad_response.upstream" {
+		t.Errorf("error = %v, want bad_response.upstream", err)
+	}
+
+	r = newProxy(t, model.ConfigMap{"upstreams": closed.URL + "," + slow.URL, "retry_count": "1"})
+	if _, err = r.Serve(context.Background(), newEvent("GET", "/")); err != nil {
+		t.Errorf("retry failed: %v", err)
+	}
+
+	r = newProxy(t, model.ConfigMap{"upstreams": slow.URL, "request_timeout": "50ms"})
+	_, err = r.Serve(context.Background(), newEvent("GET", "/"))
+	if e, ok := err.(*abeshErrors.Error); !ok || e.GetPrefix() != "timeout.upstream" {
+		t.Errorf("error = %v, want timeout.upstream", err)
+	}
+}
+
+func TestReverseProxy_EscapedPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.EscapedPath() + " " + r.Header.Get("X-Forwarded-For") + " " +
+			r.Header.Get("X-Forwarded-Proto") + " " + r.Header.Get("X-Forwarded-Host")))
+	}))
+	defer upstream.Close()
+
+	r := newProxy(t, model.ConfigMap{"upstreams": upstream.URL + "/base", "strip_prefix": "/api"})
+
+	event := newEvent("GET", "/api/files/a%2Fb")
+	event.Metadata.RemoteAddr = "10.0.0.2"
+	event.Metadata.Scheme = "https"
+	event.Metadata.Host = "example.com"
+	event.Metadata.SetHeaderValueList("X-Forwarded-For", []string{"10.0.0.1"})
+	event.Metadata.SetHeaderValueList("X-Forwarded-Host", []string{"spoofed.com"})
+
+	output, err := r.Serve(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "/base/files/a%2Fb 10.0.0.1, 10.0.0.2 https example.com"; string(output.Value) != want {
+		t.Errorf("body = %q, want %q", output.Value, want)
+	}
+}
+
+func TestReverseProxy_MaxResponseSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte(strings.Repeat("a", 16)))
+	}))
+	defer upstream.Close()
+
+	r := newProxy(t, model.ConfigMap{"upstreams": upstream.URL, "max_response_size": "8"})
+	for _, path := range []string{"/", "/chunked"} {
+		_, err := r.Serve(context.Background(), newEvent("GET", path))
+		if e, ok := err.(*abeshErrors.Error); !ok || e.GetPrefix() != "bad_response.upstream_response_too_large" {
+			t.Errorf("%s error = %v, want upstream_response_too_large", path, err)
+		}
+	}
+
+	r = newProxy(t, model.ConfigMap{"upstreams": upstream.URL, "max_response_size": "16"})
+	if _, err := r.Serve(context.Background(), newEvent("GET", "/")); err != nil {
+		t.Errorf("response at the limit = %v", err)
+	}
+}
+
+func TestReverseProxy_Retry(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	var count int64
+	dropped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+	}))
+	defer dropped.Close()
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+
+	// a refused connection never reached the upstream
+	r := newProxy(t, model.ConfigMap{"upstreams": closed.URL + "," + ok.URL, "retry_count": "1"})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Serve(context.Background(), newEvent("POST", "/")); err != nil {
+			t.Errorf("refused post not retried: %v", err)
+		}
+	}
+
+	// a post which may have reached the upstream is not sent again
+	r = newProxy(t, model.ConfigMap{"upstreams": dropped.URL + "," + ok.URL, "retry_count": "1"})
+	_, err := r.Serve(context.Background(), newEvent("POST", "/"))
+	if err == nil || atomic.LoadInt64(&count) != 1 {
+		t.Errorf("post retried: %v %d", err, count)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = r.Serve(context.Background(), newEvent("PUT", "/")); err != nil {
+			t.Errorf("put not retried: %v", err)
+		}
+	}
+
+	if atomic.LoadInt64(&count) != 2 {
+		t.Errorf("dropped upstream served %d, want 2", count)
+	}
+}
@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+	abeshTracing "github.com/mkawserm/abesh/tracing"
+)
+
+var ErrUnknownExporter = errors.New("unknown exporter")
+var ErrUnknownSampler = errors.New("unknown sampler")
+var ErrEndpointNotDefined = errors.New("endpoint not defined")
+var ErrFilePathNotDefined = errors.New("file path not defined")
+
+// Tracing configures the global tracer, add it to the start list
+// so that the queued spans are exported on shutdown
+type Tracing struct {
+	mValues model.ConfigMap
+
+	mServiceName   string
+	mExporterName  string
+	mSamplerName   string
+	mSampleRatio   float64
+	mFilePath      string
+	mEndpoint      string
+	mHeaders       model.ConfigMap
+	mTimeout       time.Duration
+	mBatchSize     int
+	mQueueSize     int
+	mFlushInterval time.Duration
+
+	mTracer *abeshTracing.Tracer
+}
+
+func (t *Tracing) Name() string {
+	return "abesh_tracing"
+}
+
+func (t *Tracing) Version() string {
+	return constant.Version
+}
+
+func (t *Tracing) Category() string {
+	return string(constant.CategoryGeneral)
+}
+
+func (t *Tracing) ContractId() string {
+	return "abesh:tracing"
+}
+
+func (t *Tracing) GetConfigMap() model.ConfigMap {
+	return t.mValues
+}
+
+func (t *Tracing) SetConfigMap(values model.ConfigMap) error {
+	t.mValues = values
+
+	t.mServiceName = values.String("service_name", constant.Name)
+	t.mExporterName = strings.ToLower(values.String("exporter", "stdout"))
+	t.mSamplerName = strings.ToLower(values.String("sampler", "parent_always"))
+	t.mSampleRatio = values.Float64("sample_ratio", 1)
+	t.mFilePath = values.String("file_path", "")
+	t.mEndpoint = values.String("endpoint", "")
+	t.mHeaders = values.StringMap("headers", model.ConfigMap{})
+	t.mTimeout = values.Duration("timeout", 10*time.Second)
+	t.mBatchSize = values.Int("batch_size", 512)
+	t.mQueueSize = values.Int("queue_size", 2048)
+	t.mFlushInterval = values.Duration("flush_interval", 5*time.Second)
+
+	return nil
+}
+
+func (t *Tracing) New() iface.ICapability {
+	return &Tracing{}
+}
+
+func (t *Tracing) buildSampler() (abeshTracing.Sampler, error) {
+	switch t.mSamplerName {
+	case "always":
+		return abeshTracing.AlwaysSample(), nil
+	case "never":
+		return abeshTracing.NeverSample(), nil
+	case "ratio":
+		return abeshTracing.RatioSample(t.mSampleRatio), nil
+	case "parent_always":
+		return abeshTracing.ParentBased(abeshTracing.AlwaysSample()), nil
+	case "parent_ratio":
+		return abeshTracing.ParentBased(abeshTracing.RatioSample(t.mSampleRatio)), nil
+	default:
+		return nil, ErrUnknownSampler
+	}
+}
+
+func (t *Tracing) buildExporter() (abeshTracing.Exporter, error) {
+	switch t.mExporterName {
+	case "stdout":
+		return abeshTracing.NewStdoutExporter(), nil
+	case "file":
+		if len(t.mFilePath) == 0 {
+			return nil, ErrFilePathNotDefined
+		}
+		return abeshTracing.NewFileExporter(t.mFilePath)
+	case "otlp_http":
+		if len(t.mEndpoint) == 0 {
+			return nil, ErrEndpointNotDefined
+		}
+		return abeshTracing.NewOTLPHTTPExporter(t.mEndpoint, t.mHeaders, t.mTimeout), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, ErrUnknownExporter
+	}
+}
+
+func (t *Tracing) Setup() error {
+	sampler, err := t.buildSampler()
+	if err != nil {
+		return err
+	}
+
+	exporter, err := t.buildExporter()
+	if err != nil {
+		return err
+	}
+
+	t.mTracer = abeshTracing.NewTracer(t.mServiceName, sampler, exporter, t.mBatchSize, t.mQueueSize, t.mFlushInterval)
+	abeshTracing.SetTracer(t.mTracer)
+
+	logger.L(t.ContractId()).Info("tracing setup complete",
+		zap.String("service_name", t.mServiceName),
+		zap.String("exporter", t.mExporterName),
+		zap.String("sampler", t.mSamplerName))
+
+	return nil
+}
+
+func (t *Tracing) Start(_ context.Context) error {
+	return nil
+}
+
+func (t *Tracing) Stop(ctx context.Context) error {
+	if t.mTracer == nil {
+		return nil
+	}
+
+	abeshTracing.SetTracer(nil)
+	return t.mTracer.Shutdown(ctx)
+}
+
+func init() {
+	registry.GlobalRegistry().AddCapability(&Tracing{})
+}
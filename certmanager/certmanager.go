@@ -0,0 +1,390 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/model"
+)
+
+var ErrNoCertificate = errors.New("no certificate configured")
+var ErrUnknownTLSVersion = errors.New("unknown tls version")
+var ErrUnknownCipherSuite = errors.New("unknown cipher suite")
+var ErrUnknownClientAuth = errors.New("unknown client auth")
+var ErrClientCARequired = errors.New("client ca file required")
+var ErrInvalidClientCA = errors.New("no certificate found in the client ca file")
+var ErrInvalidRootCA = errors.New("no certificate found in the root ca file")
+
+const name = "certmanager"
+
+var tlsVersionMap = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthMap = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+type CertificatePair struct {
+	CertFile string
+	KeyFile  string
+}
+
+type Config struct {
+	CertificateList []*CertificatePair
+	MinVersion      uint16
+	CipherSuites    []uint16
+	ReloadInterval  time.Duration
+	ClientCAFile    string
+	ClientAuth      tls.ClientAuthType
+	RootCAFile      string
+}
+
+// Configured reports whether anything differs from the default client
+// tls configuration
+func (c *Config) Configured() bool {
+	return len(c.CertificateList) != 0 || len(c.CipherSuites) != 0 || len(c.RootCAFile) != 0 ||
+		c.MinVersion != tls.VersionTLS12
+}
+
+// ConfigFromValues reads the certificate configuration, every key is
+// prefixed with prefix:
+//
+//	cert_file, key_file       the default certificate
+//	tls_sni_certificates      more certificates (ex: a.pem=a.key;b.pem=b.key)
+//	tls_min_version           1.0, 1.1, 1.2 or 1.3 (default 1.2)
+//	tls_cipher_suites         comma separated cipher suite names
+//	tls_reload_interval       how often the files are checked (default 10s)
+//	client_ca_file            ca bundle verifying the client certificates
+//	client_auth               none, request, require, verify_if_given or
+//	                          require_and_verify (default require_and_verify
+//	                          with client_ca_file, none otherwise)
+//	root_ca_file              ca bundle verifying the server certificates
+//	                          instead of the system roots
+func ConfigFromValues(values model.ConfigMap, prefix string) (*Config, error) {
+	c := &Config{
+		MinVersion:     tls.VersionTLS12,
+		ReloadInterval: values.Duration(prefix+"tls_reload_interval", 10*time.Second),
+	}
+
+	certFile := values.String(prefix+"cert_file", "")
+	keyFile := values.String(prefix+"key_file", "")
+	if len(certFile) != 0 && len(keyFile) != 0 {
+		c.CertificateList = append(c.CertificateList, &CertificatePair{CertFile: certFile, KeyFile: keyFile})
+	}
+
+	sniMap := values.StringMap(prefix+"tls_sni_certificates", nil)
+	sniCertFileList := make([]string, 0, len(sniMap))
+	for k := range sniMap {
+		sniCertFileList = append(sniCertFileList, k)
+	}
+	sort.Strings(sniCertFileList)
+
+	for _, k := range sniCertFileList {
+		c.CertificateList = append(c.CertificateList, &CertificatePair{CertFile: k, KeyFile: sniMap[k]})
+	}
+
+	if v := values.String(prefix+"tls_min_version", ""); len(v) != 0 {
+		version, found := tlsVersionMap[v]
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, v)
+		}
+		c.MinVersion = version
+	}
+
+	suiteMap := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		suiteMap[s.Name] = s.ID
+	}
+
+	for _, s := range values.StringList(prefix+"tls_cipher_suites", ",", nil) {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+
+		id, found := suiteMap[s]
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, s)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+
+	c.RootCAFile = values.String(prefix+"root_ca_file", "")
+	c.ClientCAFile = values.String(prefix+"client_ca_file", "")
+	clientAuth := "none"
+	if len(c.ClientCAFile) != 0 {
+		clientAuth = "require_and_verify"
+	}
+
+	clientAuth = values.String(prefix+"client_auth", clientAuth)
+	authType, found := clientAuthMap[clientAuth]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownClientAuth, clientAuth)
+	}
+	c.ClientAuth = authType
+
+	if (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) && len(c.ClientCAFile) == 0 {
+		return nil, ErrClientCARequired
+	}
+
+	return c, nil
+}
+
+// certificateSet is swapped as a whole on reload
+type certificateSet struct {
+	certificateList []*tls.Certificate
+	nameMap         map[string]*tls.Certificate
+	clientCAs       *x509.CertPool
+	modTimeList     []time.Time
+}
+
+// LoadCertPool reads the pem encoded certificates of the file
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRootCA, path)
+	}
+
+	return pool, nil
+}
+
+// CertManager serves the certificates of the configured files, the files
+// and the client ca file are checked at most once per reload interval
+// during the handshakes and reloaded atomically when changed, a failed
+// reload keeps the old set
+type CertManager struct {
+	mConfig    *Config
+	mRootCAs   *x509.CertPool
+	mSet       atomic.Value
+	mMutex     sync.Mutex
+	mLastCheck time.Time
+	mNowFunc   func() time.Time
+}
+
+func New(config *Config) (*CertManager, error) {
+	if len(config.CertificateList) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	m := &CertManager{mConfig: config, mNowFunc: time.Now}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	if len(config.RootCAFile) != 0 {
+		var err error
+		if m.mRootCAs, err = LoadCertPool(config.RootCAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Reload loads every certificate and swaps the set when all of them are valid
+func (m *CertManager) Reload() error {
+	set := &certificateSet{nameMap: make(map[string]*tls.Certificate)}
+
+	for _, pair := range m.mConfig.CertificateList {
+		set.modTimeList = append(set.modTimeList, modTime(pair.CertFile), modTime(pair.KeyFile))
+
+		certificate, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return err
+		}
+
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return err
+		}
+
+		set.certificateList = append(set.certificateList, &certificate)
+
+		nameList := certificate.Leaf.DNSNames
+		if len(nameList) == 0 && len(certificate.Leaf.Subject.CommonName) != 0 {
+			nameList = []string{certificate.Leaf.Subject.CommonName}
+		}
+
+		// the first certificate wins on a duplicate name
+		for _, n := range nameList {
+			n = strings.ToLower(n)
+			if _, found := set.nameMap[n]; !found {
+				set.nameMap[n] = &certificate
+			}
+		}
+	}
+
+	if len(m.mConfig.ClientCAFile) != 0 {
+		set.modTimeList = append(set.modTimeList, modTime(m.mConfig.ClientCAFile))
+
+		var err error
+		if set.clientCAs, err = LoadCertPool(m.mConfig.ClientCAFile); err != nil {
+			if errors.Is(err, ErrInvalidRootCA) {
+				return fmt.Errorf("%w: %s", ErrInvalidClientCA, m.mConfig.ClientCAFile)
+			}
+			return err
+		}
+	}
+
+	m.mSet.Store(set)
+	return nil
+}
+
+func (m *CertManager) changed(set *certificateSet) bool {
+	index := 0
+	for _, pair := range m.mConfig.CertificateList {
+		if !modTime(pair.CertFile).Equal(set.modTimeList[index]) || !modTime(pair.KeyFile).Equal(set.modTimeList[index+1]) {
+			return true
+		}
+		index += 2
+	}
+
+	if len(m.mConfig.ClientCAFile) != 0 && !modTime(m.mConfig.ClientCAFile).Equal(set.modTimeList[index]) {
+		return true
+	}
+
+	return false
+}
+
+// check reloads the set when the files changed since the last load
+func (m *CertManager) check() *certificateSet {
+	set := m.mSet.Load().(*certificateSet)
+	if m.mConfig.ReloadInterval <= 0 {
+		return set
+	}
+
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	now := m.mNowFunc()
+	if now.Sub(m.mLastCheck) < m.mConfig.ReloadInterval {
+		return set
+	}
+	m.mLastCheck = now
+
+	if !m.changed(set) {
+		return set
+	}
+
+	if err := m.Reload(); err != nil {
+		logger.L(name).Error("certificate reload failed", zap.Error(err))
+		return set
+	}
+
+	logger.L(name).Info("certificates reloaded")
+	return m.mSet.Load().(*certificateSet)
+}
+
+// GetCertificate selects the certificate by the server name, exact names
+// first then wildcards, the first certificate is the default
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := m.check()
+
+	serverName := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if len(serverName) != 0 {
+		if certificate, found := set.nameMap[serverName]; found {
+			return certificate, nil
+		}
+
+		if index := strings.Index(serverName, "."); index > 0 {
+			if certificate, found := set.nameMap["*"+serverName[index:]]; found {
+				return certificate, nil
+			}
+		}
+	}
+
+	return set.certificateList[0], nil
+}
+
+// GetClientCertificate returns the first certificate accepted by the server
+func (m *CertManager) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	set := m.check()
+
+	for _, certificate := range set.certificateList {
+		if info.SupportsCertificate(certificate) == nil {
+			return certificate, nil
+		}
+	}
+
+	return set.certificateList[0], nil
+}
+
+// ServerTLSConfig verifies the client certificates with the current
+// client ca set, so that a reloaded ca file applies to new handshakes
+func (m *CertManager) ServerTLSConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     m.mConfig.MinVersion,
+		CipherSuites:   m.mConfig.CipherSuites,
+		GetCertificate: m.GetCertificate,
+		ClientAuth:     m.mConfig.ClientAuth,
+	}
+
+	if len(m.mConfig.ClientCAFile) == 0 {
+		return config
+	}
+
+	// the config is cloned per handshake, the protocols added by the
+	// http server to the returned config are kept
+	config.ClientCAs = m.mSet.Load().(*certificateSet).clientCAs
+	config.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = m.check().clientCAs
+		if len(c.NextProtos) != 0 && !containsString(c.NextProtos, "http/1.1") {
+			c.NextProtos = append(c.NextProtos, "http/1.1")
+		}
+		return c, nil
+	}
+
+	return config
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *CertManager) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:           m.mConfig.MinVersion,
+		CipherSuites:         m.mConfig.CipherSuites,
+		GetClientCertificate: m.GetClientCertificate,
+		RootCAs:              m.mRootCAs,
+	}
+}
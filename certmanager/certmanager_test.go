@@ -0,0 +1,245 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+func writeCertificate(t *testing.T, dir, fileName, commonName string, dnsNameList ...string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNameList,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, fileName+".pem")
+	keyFile := filepath.Join(dir, fileName+".key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestConfigFromValues(t *testing.T) {
+	c, err := ConfigFromValues(model.ConfigMap{
+		"client_cert_file":            "a.pem",
+		"client_key_file":             "a.key",
+		"client_tls_sni_certificates": "c.pem=c.key;b.pem=b.key",
+		"client_tls_min_version":      "1.3",
+		"client_tls_cipher_suites":    "TLS_AES_128_GCM_SHA256",
+	}, "client_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.CertificateList) != 3 || c.CertificateList[0].CertFile != "a.pem" || c.CertificateList[1].KeyFile != "b.key" {
+		t.Errorf("certificates = %v", c.CertificateList)
+	}
+
+	if c.MinVersion != tls.VersionTLS13 || len(c.CipherSuites) != 1 || c.CipherSuites[0] != tls.TLS_AES_128_GCM_SHA256 {
+		t.Errorf("min version = %x, cipher suites = %v", c.MinVersion, c.CipherSuites)
+	}
+
+	if _, err = ConfigFromValues(model.ConfigMap{"tls_min_version": "2.0"}, ""); !errors.Is(err, ErrUnknownTLSVersion) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownTLSVersion)
+	}
+
+	if _, err = ConfigFromValues(model.ConfigMap{"tls_cipher_suites": "NOPE"}, ""); !errors.Is(err, ErrUnknownCipherSuite) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownCipherSuite)
+	}
+
+	if _, err = New(&Config{}); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("err = %v, want %v", err, ErrNoCertificate)
+	}
+}
+
+func TestCertManager_SNI(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert, defaultKey := writeCertificate(t, dir, "default", "default.local")
+	apiCert, apiKey := writeCertificate(t, dir, "api", "", "api.example.com")
+	wildcardCert, wildcardKey := writeCertificate(t, dir, "wildcard", "", "*.example.org")
+
+	m, err := New(&Config{CertificateList: []*CertificatePair{
+		{CertFile: defaultCert, KeyFile: defaultKey},
+		{CertFile: apiCert, KeyFile: apiKey},
+		{CertFile: wildcardCert, KeyFile: wildcardKey},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for serverName, want := range map[string]string{
+		"API.example.com": "api.example.com",
+		"www.example.org": "*.example.org",
+		"unknown.net":     "",
+		"":                "",
+	} {
+		certificate, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		if len(certificate.Leaf.DNSNames) != 0 {
+			got = certificate.Leaf.DNSNames[0]
+		}
+
+		if got != want {
+			t.Errorf("%q: certificate = %q, want %q", serverName, got, want)
+		}
+	}
+}
+
+func TestCertManager_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "server", "", "old.local")
+
+	m, err := New(&Config{
+		CertificateList: []*CertificatePair{{CertFile: certFile, KeyFile: keyFile}},
+		ReloadInterval:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	m.mNowFunc = func() time.Time { return now }
+
+	writeCertificate(t, dir, "server", "", "new.local")
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(certFile, future, future)
+
+	// within the interval the old certificate is kept
+	m.mLastCheck = now
+	if c, _ := m.GetCertificate(&tls.ClientHelloInfo{}); c.Leaf.DNSNames[0] != "old.local" {
+		t.Errorf("certificate = %s, want old.local", c.Leaf.DNSNames[0])
+	}
+
+	// a broken key keeps the old certificate
+	now = now.Add(2 * time.Minute)
+	_ = os.WriteFile(keyFile, []byte("broken"), 0600)
+	if c, _ := m.GetCertificate(&tls.ClientHelloInfo{}); c.Leaf.DNSNames[0] != "old.local" {
+		t.Errorf("certificate = %s, want old.local", c.Leaf.DNSNames[0])
+	}
+
+	writeCertificate(t, dir, "server", "", "new.local")
+	_ = os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute))
+	now = now.Add(2 * time.Minute)
+	if c, _ := m.GetCertificate(&tls.ClientHelloInfo{}); c.Leaf.DNSNames[0] != "new.local" {
+		t.Errorf("certificate = %s, want new.local", c.Leaf.DNSNames[0])
+	}
+}
+
+func verifies(pool *x509.CertPool, certFile string) bool {
+	data, _ := os.ReadFile(certFile)
+	block, _ := pem.Decode(data)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	_, err = certificate.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err == nil
+}
+
+func TestCertManager_ClientCAReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "server", "", "server.local")
+	oldCA, _ := writeCertificate(t, dir, "old", "old-ca")
+	newCA, _ := writeCertificate(t, dir, "new", "new-ca")
+
+	caFile := filepath.Join(dir, "ca.pem")
+	data, _ := os.ReadFile(oldCA)
+	_ = os.WriteFile(caFile, data, 0600)
+
+	c, err := ConfigFromValues(model.ConfigMap{
+		"cert_file":           certFile,
+		"key_file":            keyFile,
+		"client_ca_file":      caFile,
+		"tls_reload_interval": "1m",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	m.mNowFunc = func() time.Time { return now }
+
+	config := m.ServerTLSConfig()
+	config.NextProtos = []string{"h2"}
+
+	handshake, _ := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if !verifies(handshake.ClientCAs, oldCA) || verifies(handshake.ClientCAs, newCA) {
+		t.Error("old client ca is not used")
+	}
+
+	if len(handshake.NextProtos) != 2 || handshake.NextProtos[1] != "http/1.1" {
+		t.Errorf("next protos = %v", handshake.NextProtos)
+	}
+
+	data, _ = os.ReadFile(newCA)
+	_ = os.WriteFile(caFile, data, 0600)
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(caFile, future, future)
+	now = now.Add(2 * time.Minute)
+
+	handshake, _ = config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if verifies(handshake.ClientCAs, oldCA) || !verifies(handshake.ClientCAs, newCA) {
+		t.Error("client ca is not reloaded")
+	}
+}
+
+func TestConfigFromValues_RootCA(t *testing.T) {
+	c, err := ConfigFromValues(model.ConfigMap{}, "client_")
+	if err != nil || c.Configured() {
+		t.Errorf("default configured = %v, %v", c.Configured(), err)
+	}
+
+	c, _ = ConfigFromValues(model.ConfigMap{"client_root_ca_file": "ca.pem"}, "client_")
+	if c.RootCAFile != "ca.pem" || !c.Configured() {
+		t.Errorf("root ca file = %s", c.RootCAFile)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	_ = os.WriteFile(invalid, []byte("invalid"), 0600)
+	if _, err = LoadCertPool(invalid); !errors.Is(err, ErrInvalidRootCA) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidRootCA)
+	}
+}
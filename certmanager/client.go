@@ -0,0 +1,38 @@
+package certmanager
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+// ClientCertificate converts the verified peer certificate for the metadata
+func ClientCertificate(certificate *x509.Certificate) *model.ClientCertificate {
+	fingerprint := sha256.Sum256(certificate.Raw)
+
+	c := &model.ClientCertificate{
+		Subject:            certificate.Subject.String(),
+		Issuer:             certificate.Issuer.String(),
+		CommonName:         certificate.Subject.CommonName,
+		Organization:       certificate.Subject.Organization,
+		OrganizationalUnit: certificate.Subject.OrganizationalUnit,
+		DnsNames:           certificate.DNSNames,
+		EmailAddresses:     certificate.EmailAddresses,
+		SerialNumber:       certificate.SerialNumber.String(),
+		Fingerprint:        hex.EncodeToString(fingerprint[:]),
+		NotBefore:          certificate.NotBefore.Unix(),
+		NotAfter:           certificate.NotAfter.Unix(),
+	}
+
+	for _, ip := range certificate.IPAddresses {
+		c.IpAddresses = append(c.IpAddresses, ip.String())
+	}
+
+	for _, uri := range certificate.URIs {
+		c.Uris = append(c.Uris, uri.String())
+	}
+
+	return c
+}
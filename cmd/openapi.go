@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mkawserm/abesh/constant"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/openapi"
+)
+
+var openAPIOutput string
+var openAPITitle string
+var openAPIVersion string
+var openAPIServerList []string
+var openAPIAuthorizerExpression bool
+
+var openAPICMD = &cobra.Command{
+	Use:   "openapi",
+	Short: "Generate OpenAPI document",
+	Long:  "Generate OpenAPI 3 document from the http trigger routes of the manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+		manifest, err := model.GetManifestFromFile(manifestFilePath)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		d := openapi.NewDocument(&openapi.Info{Title: openAPITitle, Version: openAPIVersion}, openAPIServerList...)
+		d.AuthorizerExpressionEnabled = openAPIAuthorizerExpression
+		openapi.FromManifestWithDocument(manifest, d)
+
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if len(openAPIOutput) == 0 {
+			fmt.Println(string(data))
+			return
+		}
+
+		if err = os.WriteFile(openAPIOutput, data, 0644); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	openAPICMD.Flags().StringVar(&manifestFilePath, "manifest", "", "Manifest file path (ex: /home/ubuntu/manifest.yaml)")
+	openAPICMD.Flags().StringVar(&openAPIOutput, "output", "", "Output file path, stdout when empty")
+	openAPICMD.Flags().StringVar(&openAPITitle, "title", constant.Name, "Document title")
+	openAPICMD.Flags().StringVar(&openAPIVersion, "version", constant.Version, "Document version")
+	openAPICMD.Flags().StringSliceVar(&openAPIServerList, "server", nil, "Server url, can be repeated")
+	openAPICMD.Flags().BoolVar(&openAPIAuthorizerExpression, "authorizer-expression", false, "Document the authorizer expressions")
+	_ = openAPICMD.MarkFlagRequired("manifest")
+	abeshCMD.AddCommand(openAPICMD)
+}
@@ -5,27 +5,21 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
 	"github.com/mkawserm/abesh/constant"
 	"github.com/mkawserm/abesh/iface"
 	"github.com/mkawserm/abesh/logger"
+	"github.com/mkawserm/abesh/metrics"
 	"github.com/mkawserm/abesh/model"
 	"github.com/mkawserm/abesh/registry"
 	stack2 "github.com/mkawserm/abesh/stack"
 )
 
-var panicCounter = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "abesh_example_err_panic_counter",
-		Help: "Panic Counter",
-	},
-	[]string{"contractid"},
-)
-
 type ExPanic struct {
-	mValues map[string]string
+	mValues       map[string]string
+	mMetrics      iface.IMetrics
+	mPanicCounter iface.Counter
 }
 
 func (e *ExPanic) Name() string {
@@ -48,7 +42,17 @@ func (e *ExPanic) GetConfigMap() model.ConfigMap {
 	return e.mValues
 }
 
+func (e *ExPanic) SetMetrics(metrics iface.IMetrics) error {
+	e.mMetrics = metrics
+	return nil
+}
+
 func (e *ExPanic) Setup() error {
+	if e.mMetrics == nil {
+		e.mMetrics = metrics.Default().Metrics(e.ContractId())
+	}
+
+	e.mPanicCounter = e.mMetrics.Counter("panic_counter", "Panic Counter", "contractid")
 	return nil
 }
 
@@ -79,7 +83,7 @@ func (e *ExPanic) Serve(_ context.Context, input *model.Event) (event *model.Eve
 				zap.String("panic_msg", panicMsg))
 
 			go func() {
-				panicCounter.WithLabelValues(e.ContractId()).Inc()
+				e.mPanicCounter.With(e.ContractId()).Inc()
 			}()
 
 			return
@@ -91,6 +95,5 @@ func (e *ExPanic) Serve(_ context.Context, input *model.Event) (event *model.Eve
 }
 
 func init() {
-	prometheus.MustRegister(panicCounter)
 	registry.GlobalRegistry().AddCapability(&ExPanic{})
 }
@@ -0,0 +1,264 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrInvalidLanguage = errors.New("invalid language")
+
+// DefaultLanguage is used when nothing else matches
+const DefaultLanguage = "en"
+
+var (
+	globalMutex   sync.RWMutex
+	globalCatalog *Catalog
+)
+
+// SetCatalog sets the catalog shared by the default messages, nil
+// removes it
+func SetCatalog(catalog *Catalog) {
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+
+	globalCatalog = catalog
+}
+
+// GetCatalog returns the shared catalog, it may be nil
+func GetCatalog() *Catalog {
+	globalMutex.RLock()
+	defer globalMutex.RUnlock()
+
+	return globalCatalog
+}
+
+// Catalog keeps the messages of every language, a key missing in a
+// language is looked up in its parents (pt-BR, pt) and then in the
+// default language
+type Catalog struct {
+	mDefault  language.Tag
+	mTagList  []language.Tag
+	mMessages map[string]map[string]string
+	mMatcher  language.Matcher
+}
+
+func New(defaultLanguage string) (*Catalog, error) {
+	tag, err := language.Parse(defaultLanguage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLanguage, defaultLanguage)
+	}
+
+	c := &Catalog{
+		mDefault:  tag,
+		mTagList:  []language.Tag{tag},
+		mMessages: make(map[string]map[string]string),
+	}
+	c.mMatcher = language.NewMatcher(c.mTagList)
+
+	return c, nil
+}
+
+// Add merges the messages of the language into the catalog, a shared
+// catalog must not be changed
+func (c *Catalog) Add(lang string, messages map[string]string) error {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidLanguage, lang)
+	}
+
+	key := tag.String()
+	if _, found := c.mMessages[key]; !found {
+		c.mMessages[key] = make(map[string]string)
+		if tag != c.mDefault {
+			c.mTagList = append(c.mTagList, tag)
+			c.mMatcher = language.NewMatcher(c.mTagList)
+		}
+	}
+
+	for k, v := range messages {
+		c.mMessages[key][k] = v
+	}
+
+	return nil
+}
+
+// LoadDir loads the <lang>.yaml, <lang>.yml and <lang>.json files of the
+// directory, nested keys are joined with a dot
+func LoadDir(dir string, defaultLanguage string) (*Catalog, error) {
+	c, err := New(defaultLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	entryList, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entryList {
+		if entry.IsDir() {
+			continue
+		}
+
+		extension := filepath.Ext(entry.Name())
+		if extension != ".yaml" && extension != ".yml" && extension != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]interface{}
+		if extension == ".json" {
+			err = json.Unmarshal(data, &values)
+		} else {
+			err = yaml.Unmarshal(data, &values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		messages := make(map[string]string)
+		flatten("", values, messages)
+
+		if err = c.Add(strings.TrimSuffix(entry.Name(), extension), messages); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func flatten(prefix string, value interface{}, output map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			flatten(joinKey(prefix, k), item, output)
+		}
+	case map[interface{}]interface{}:
+		for k, item := range v {
+			flatten(joinKey(prefix, fmt.Sprint(k)), item, output)
+		}
+	case nil:
+	default:
+		output[prefix] = fmt.Sprint(v)
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if len(prefix) == 0 {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+// Languages returns the languages of the catalog, the default first
+func (c *Catalog) Languages() []string {
+	if c == nil {
+		return nil
+	}
+
+	output := make([]string, 0, len(c.mTagList))
+	for _, tag := range c.mTagList {
+		output = append(output, tag.String())
+	}
+	sort.Strings(output[1:])
+
+	return output
+}
+
+// Language negotiates the Accept-Language header against the catalog
+// languages, without a catalog the base of the preferred language is
+// returned
+func (c *Catalog) Language(acceptLanguage string) string {
+	defaultLanguage := DefaultLanguage
+	if c != nil {
+		defaultLanguage = c.mDefault.String()
+	}
+
+	tagList, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tagList) == 0 {
+		return defaultLanguage
+	}
+
+	if c == nil || len(c.mTagList) < 2 {
+		base, _ := tagList[0].Base()
+		return base.String()
+	}
+
+	_, index, confidence := c.mMatcher.Match(tagList...)
+	if confidence == language.No {
+		return defaultLanguage
+	}
+
+	return c.mTagList[index].String()
+}
+
+// Chain returns the fallback chain of the language, the language itself
+// first (ex: pt-BR, pt)
+func Chain(lang string) []string {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return []string{lang}
+	}
+
+	var output []string
+	for ; !tag.IsRoot(); tag = tag.Parent() {
+		output = append(output, tag.String())
+	}
+
+	return output
+}
+
+// Message looks the key up through the fallback chain of the language
+// and then in the default language
+func (c *Catalog) Message(lang string, key string) (string, bool) {
+	if message, found := c.LanguageMessage(lang, key); found {
+		return message, true
+	}
+
+	return c.DefaultMessage(key)
+}
+
+// LanguageMessage looks the key up through the fallback chain of the
+// language only, the default language is left out
+func (c *Catalog) LanguageMessage(lang string, key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	defaultLanguage := c.mDefault.String()
+	for _, l := range Chain(lang) {
+		if l == defaultLanguage {
+			continue
+		}
+
+		if message, found := c.mMessages[l][key]; found {
+			return message, true
+		}
+	}
+
+	return "", false
+}
+
+// DefaultMessage looks the key up in the default language
+func (c *Catalog) DefaultMessage(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	message, found := c.mMessages[c.mDefault.String()][key]
+	return message, found
+}
@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"en.yaml":    "s404m: Not found\nbad_request:\n  validation: Invalid request\n",
+		"pt.yml":     "s404m: Não encontrado\n",
+		"pt-BR.json": `{"bad_request": {"validation": "Requisição inválida"}}`,
+		"notes.txt":  "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := LoadDir(dir, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.Languages(); !reflect.DeepEqual(v, []string{"en", "pt", "pt-BR"}) {
+		t.Errorf("languages = %v", v)
+	}
+
+	for accept, want := range map[string]string{
+		"":                      "en",
+		"pt-BR,pt;q=0.8":        "pt-BR",
+		"fr;q=0.9, pt-BR;q=0.5": "pt-BR",
+		"fr":                    "en",
+		"de-DE,en-US;q=0.7":     "en",
+		"not a language tag!!":  "en",
+	} {
+		if lang := c.Language(accept); lang != want {
+			t.Errorf("Language(%q) = %q, want %q", accept, lang, want)
+		}
+	}
+
+	for _, test := range []struct {
+		lang, key, want string
+	}{
+		{"pt-BR", "bad_request.validation", "Requisição inválida"},
+		{"pt-BR", "s404m", "Não encontrado"},
+		{"pt", "bad_request.validation", "Invalid request"},
+		{"fr", "s404m", "Not found"},
+	} {
+		if v, _ := c.Message(test.lang, test.key); v != test.want {
+			t.Errorf("Message(%q, %q) = %q, want %q", test.lang, test.key, v, test.want)
+		}
+	}
+
+	if _, found := c.Message("en", "missing"); found {
+		t.Error("missing key found")
+	}
+
+	if _, found := c.LanguageMessage("fr", "s404m"); found {
+		t.Error("default language found for fr")
+	}
+
+	if _, found := c.LanguageMessage("en", "s404m"); found {
+		t.Error("default language found for en")
+	}
+
+	if v, _ := c.DefaultMessage("s404m"); v != "Not found" {
+		t.Errorf("DefaultMessage = %q", v)
+	}
+}
+
+func TestCatalog_Nil(t *testing.T) {
+	var c *Catalog
+
+	if lang := c.Language("bn-BD,en;q=0.5"); lang != "bn" {
+		t.Errorf("language = %q, want bn", lang)
+	}
+
+	if _, found := c.Message("bn", "s404m"); found {
+		t.Error("nil catalog has messages")
+	}
+
+	if _, err := New("!!"); err == nil {
+		t.Error("invalid default language accepted")
+	}
+}
@@ -19,3 +19,8 @@ type IAddService interface {
 type IAddEmbeddedStaticFS interface {
 	AddEmbeddedStaticFS(pattern string, fs embed.FS)
 }
+
+// IAddEmbeddedStaticFSE is IAddEmbeddedStaticFS which returns the error
+type IAddEmbeddedStaticFSE interface {
+	AddEmbeddedStaticFSE(pattern string, fs embed.FS) error
+}
@@ -0,0 +1,15 @@
+package iface
+
+import "github.com/mkawserm/abesh/model"
+
+// IDescribe is implemented by the services which document their request
+// and response, it is used to generate the OpenAPI document
+type IDescribe interface {
+	Describe() *model.ServiceDescription
+}
+
+// IDescribeSecurity is implemented by the authorizers which document their
+// OpenAPI security scheme, http bearer authentication is assumed otherwise
+type IDescribeSecurity interface {
+	DescribeSecurity() map[string]interface{}
+}
@@ -23,3 +23,17 @@ type IKVStore interface {
 	// Delete the key
 	Delete(ctx context.Context, key string) error
 }
+
+// IKVStoreDeletePrefix is implemented by the stores which can delete
+// every key starting with a prefix
+type IKVStoreDeletePrefix interface {
+	// DeletePrefix deletes the keys with the prefix and returns their count
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// IKVStoreSetNX is implemented by the stores which can set a key only
+// when it does not exist
+type IKVStoreSetNX interface {
+	// SetNX sets the key when it is missing and reports whether it was set
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
+}
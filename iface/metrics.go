@@ -20,3 +20,23 @@ type Observer interface {
 	With(levels ...string) Observer
 	Observe(float64)
 }
+
+// IMetrics creates the metrics of a capability, the label values
+// are given to With in the order of the label names
+type IMetrics interface {
+	Counter(name string, help string, labelNames ...string) Counter
+	Gauge(name string, help string, labelNames ...string) Gauge
+	Histogram(name string, help string, buckets []float64, labelNames ...string) Observer
+}
+
+// IMetricsProvider is implemented by the metrics capability,
+// metric names are prefixed with the namespace
+type IMetricsProvider interface {
+	Metrics(namespace string) IMetrics
+}
+
+// ISetMetrics is implemented by the capabilities which record metrics,
+// the metrics are namespaced by the contract id of the capability
+type ISetMetrics interface {
+	SetMetrics(metrics IMetrics) error
+}
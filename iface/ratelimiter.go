@@ -0,0 +1,14 @@
+package iface
+
+import (
+	"context"
+
+	"github.com/mkawserm/abesh/model"
+)
+
+type IRateLimiter interface {
+	ICapability
+
+	// Take consumes one request of the key
+	Take(ctx context.Context, key string) (*model.RateLimit, error)
+}
@@ -2,6 +2,8 @@ package iface
 
 import (
 	"context"
+	"io"
+
 	"github.com/mkawserm/abesh/model"
 )
 
@@ -11,3 +13,9 @@ type IServe interface {
 	// event need to respected as immutable
 	Serve(ctx context.Context, event *model.Event) (*model.Event, error)
 }
+
+// IServeStream is implemented by the services which prefer to consume
+// the request body as a stream, event.Value is empty in that case
+type IServeStream interface {
+	ServeStream(ctx context.Context, event *model.Event, body io.Reader) (*model.Event, error)
+}
@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type requestIdKey struct{}
+
+// WithRequestId returns a copy of ctx carrying the request id
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestId returns the request id carried by ctx, empty when not present
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	if v, ok := ctx.Value(requestIdKey{}).(string); ok {
+		return v
+	}
+
+	return ""
+}
+
+// LC returns zap logger with the request id of ctx attached to every line
+func LC(ctx context.Context, pkgName string) *zap.Logger {
+	l := L(pkgName)
+	if requestId := RequestId(ctx); len(requestId) != 0 {
+		return l.With(zap.String("request_id", requestId))
+	}
+
+	return l
+}
@@ -147,7 +147,8 @@ func CS(pkgName string) *SugaredLogger {
 	return &SugaredLogger{S(pkgName)}
 }
 
-// L returns zap logger
+// L returns zap logger, the lines carry no request id, LC attaches the
+// request id of a context
 func L(pkgName string) *zap.Logger {
 	return loggerIns.L(pkgName)
 }
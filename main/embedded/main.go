@@ -2,9 +2,11 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"github.com/mkawserm/abesh/capability/httpserver"
 	"github.com/mkawserm/abesh/cmd"
 	"github.com/spf13/cobra"
+	"os"
 )
 import _ "github.com/mkawserm/abesh/capability/httpserver"
 import _ "github.com/mkawserm/abesh/capability/httpclient"
@@ -35,7 +37,10 @@ var embeddedRunCMD2 = &cobra.Command{
 		p := cmd.EmbeddedPlatformSetup(manifestFilePathList)
 		t := p.GetTriggersCapability()["abesh:httpserver"]
 		srv := t.(*httpserver.HTTPServer)
-		srv.AddEmbeddedStaticFS("/data/", staticDataFiles)
+		if err := srv.AddEmbeddedStaticFSE("/data/", staticDataFiles); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		p.Run()
 	},
 }
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mkawserm/abesh/iface"
+)
+
+// Memory keeps the metrics in memory, useful for the tests
+type Memory struct {
+	mMaxCardinality int
+
+	mMutex          sync.Mutex
+	mValueMap       map[string]float64
+	mObservationMap map[string][]float64
+	mGuardMap       map[string]*cardinalityGuard
+}
+
+func NewMemory(maxCardinality int) *Memory {
+	return &Memory{
+		mMaxCardinality: maxCardinality,
+		mValueMap:       make(map[string]float64),
+		mObservationMap: make(map[string][]float64),
+		mGuardMap:       make(map[string]*cardinalityGuard),
+	}
+}
+
+func (m *Memory) Metrics(namespace string) iface.IMetrics {
+	return &memoryMetrics{provider: m, namespace: namespace}
+}
+
+func memoryKey(fullName string, values []string) string {
+	return fullName + "{" + strings.Join(values, ",") + "}"
+}
+
+// Value returns the value of the counter or the gauge,
+// fullName includes the namespace (ex: abesh_httpserver_response_status)
+func (m *Memory) Value(fullName string, labelValues ...string) float64 {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	return m.mValueMap[memoryKey(fullName, labelValues)]
+}
+
+// Observations returns the values observed by the histogram
+func (m *Memory) Observations(fullName string, labelValues ...string) []float64 {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	return append([]float64{}, m.mObservationMap[memoryKey(fullName, labelValues)]...)
+}
+
+func (m *Memory) guard(fullName string) *cardinalityGuard {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	g, found := m.mGuardMap[fullName]
+	if !found {
+		g = newCardinalityGuard(m.mMaxCardinality)
+		m.mGuardMap[fullName] = g
+	}
+
+	return g
+}
+
+func (m *Memory) add(key string, delta float64) {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	m.mValueMap[key] += delta
+}
+
+func (m *Memory) set(key string, value float64) {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	m.mValueMap[key] = value
+}
+
+func (m *Memory) observe(key string, value float64) {
+	m.mMutex.Lock()
+	defer m.mMutex.Unlock()
+
+	m.mObservationMap[key] = append(m.mObservationMap[key], value)
+}
+
+type memoryMetrics struct {
+	provider  *Memory
+	namespace string
+}
+
+func (mm *memoryMetrics) newMetric(name string, labelNames []string) *memoryMetric {
+	fullName := FullName(mm.namespace, name)
+
+	return &memoryMetric{
+		provider:   mm.provider,
+		fullName:   fullName,
+		labelNames: labelNames,
+		guard:      mm.provider.guard(fullName),
+	}
+}
+
+func (mm *memoryMetrics) Counter(name string, _ string, labelNames ...string) iface.Counter {
+	return memoryCounter{mm.newMetric(name, labelNames)}
+}
+
+func (mm *memoryMetrics) Gauge(name string, _ string, labelNames ...string) iface.Gauge {
+	return memoryGauge{mm.newMetric(name, labelNames)}
+}
+
+func (mm *memoryMetrics) Histogram(name string, _ string, _ []float64, labelNames ...string) iface.Observer {
+	return memoryObserver{mm.newMetric(name, labelNames)}
+}
+
+// memoryMetric records every metric type
+type memoryMetric struct {
+	provider    *Memory
+	fullName    string
+	labelNames  []string
+	labelValues []string
+	guard       *cardinalityGuard
+}
+
+func (m *memoryMetric) with(values []string) *memoryMetric {
+	return &memoryMetric{
+		provider:    m.provider,
+		fullName:    m.fullName,
+		labelNames:  m.labelNames,
+		labelValues: m.guard.check(labelValues(m.labelNames, values)),
+		guard:       m.guard,
+	}
+}
+
+func (m *memoryMetric) key() string {
+	return memoryKey(m.fullName, labelValues(m.labelNames, m.labelValues))
+}
+
+// the With methods satisfy the Counter, Gauge and Observer interfaces
+type memoryCounter struct{ *memoryMetric }
+type memoryGauge struct{ *memoryMetric }
+type memoryObserver struct{ *memoryMetric }
+
+func (c memoryCounter) With(values ...string) iface.Counter {
+	return memoryCounter{c.with(values)}
+}
+
+func (g memoryGauge) With(values ...string) iface.Gauge {
+	return memoryGauge{g.with(values)}
+}
+
+func (o memoryObserver) With(values ...string) iface.Observer {
+	return memoryObserver{o.with(values)}
+}
+
+func (m *memoryMetric) Inc() {
+	m.provider.add(m.key(), 1)
+}
+
+func (m *memoryMetric) Add(delta float64) {
+	m.provider.add(m.key(), delta)
+}
+
+func (m *memoryMetric) Sub(delta float64) {
+	m.provider.add(m.key(), -delta)
+}
+
+func (m *memoryMetric) Set(value float64) {
+	m.provider.set(m.key(), value)
+}
+
+func (m *memoryMetric) Observe(value float64) {
+	m.provider.observe(m.key(), value)
+}
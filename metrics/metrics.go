@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// OverflowValue replaces the label values once a metric
+// reaches the label cardinality limit
+const OverflowValue = "__overflow__"
+
+const DefaultMaxCardinality = 1000
+
+// FullName joins the namespace and the name, characters which are not
+// allowed in a metric name are replaced with underscore (abesh:httpserver -> abesh_httpserver)
+func FullName(namespace string, name string) string {
+	if len(namespace) == 0 {
+		return sanitize(name)
+	}
+
+	return sanitize(namespace) + "_" + sanitize(name)
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// labelValues pads or truncates the values to the number of label names,
+// mismatched values would make the prometheus client panic
+func labelValues(labelNames []string, values []string) []string {
+	output := make([]string, len(labelNames))
+	copy(output, values)
+
+	return output
+}
+
+// cardinalityGuard limits the number of distinct label value
+// combinations of a metric
+type cardinalityGuard struct {
+	mMutex sync.Mutex
+	mLimit int
+	mSeen  map[string]struct{}
+}
+
+func newCardinalityGuard(limit int) *cardinalityGuard {
+	return &cardinalityGuard{mLimit: limit, mSeen: make(map[string]struct{})}
+}
+
+func (c *cardinalityGuard) check(values []string) []string {
+	if c.mLimit <= 0 || len(values) == 0 {
+		return values
+	}
+
+	key := strings.Join(values, "\xff")
+
+	c.mMutex.Lock()
+	defer c.mMutex.Unlock()
+
+	if _, found := c.mSeen[key]; found {
+		return values
+	}
+
+	if len(c.mSeen) < c.mLimit {
+		c.mSeen[key] = struct{}{}
+		return values
+	}
+
+	output := make([]string, len(values))
+	for index := range output {
+		output[index] = OverflowValue
+	}
+
+	return output
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFullName(t *testing.T) {
+	if name := FullName("abesh:httpserver", "response_status"); name != "abesh_httpserver_response_status" {
+		t.Errorf("FullName() = %s", name)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(2)
+	c := m.Metrics("abesh:test").Counter("requests", "", "path")
+
+	c.With("/a").Inc()
+	c.With("/a").Add(2)
+	c.With("/b").Inc()
+	c.With("/c").Inc()
+	c.With("/d").Inc()
+
+	if v := m.Value("abesh_test_requests", "/a"); v != 3 {
+		t.Errorf("Value(/a) = %v, want 3", v)
+	}
+
+	if v := m.Value("abesh_test_requests", "/c"); v != 0 {
+		t.Errorf("Value(/c) = %v, want 0", v)
+	}
+
+	if v := m.Value("abesh_test_requests", OverflowValue); v != 2 {
+		t.Errorf("Value(overflow) = %v, want 2", v)
+	}
+
+	o := m.Metrics("abesh:test").Histogram("duration", "", nil, "path")
+	o.With("/a").Observe(0.5)
+	if v := m.Observations("abesh_test_duration", "/a"); len(v) != 1 || v[0] != 0.5 {
+		t.Errorf("Observations() = %v", v)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := NewPrometheus(registry, 1)
+
+	c := p.Metrics("abesh:test").Counter("requests", "help", "path", "status")
+	c.With("/a", "200").Inc()
+	c.With("/b", "200").Inc()
+
+	// the same collector is shared by the second instance
+	p.Metrics("abesh:test").Counter("requests", "help", "path", "status").With("/a", "200").Inc()
+
+	if n, _ := testutil.GatherAndCount(registry, "abesh_test_requests"); n != 2 {
+		t.Errorf("series = %d, want 2", n)
+	}
+
+	// missing label values do not panic
+	p.Metrics("abesh:test").Gauge("in_flight", "help", "path").With().Set(1)
+}
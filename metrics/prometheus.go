@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mkawserm/abesh/iface"
+)
+
+var (
+	defaultOnce       sync.Once
+	defaultPrometheus *Prometheus
+)
+
+// Default returns the prometheus provider of the default registerer,
+// used by the capabilities when the metrics capability is not configured
+func Default() *Prometheus {
+	defaultOnce.Do(func() {
+		defaultPrometheus = NewPrometheus(prometheus.DefaultRegisterer, DefaultMaxCardinality)
+	})
+
+	return defaultPrometheus
+}
+
+// Prometheus registers the metrics to the prometheus registerer
+type Prometheus struct {
+	mRegisterer     prometheus.Registerer
+	mMaxCardinality int
+
+	mMutex    sync.Mutex
+	mGuardMap map[string]*cardinalityGuard
+}
+
+func NewPrometheus(registerer prometheus.Registerer, maxCardinality int) *Prometheus {
+	return &Prometheus{
+		mRegisterer:     registerer,
+		mMaxCardinality: maxCardinality,
+		mGuardMap:       make(map[string]*cardinalityGuard),
+	}
+}
+
+func (p *Prometheus) Metrics(namespace string) iface.IMetrics {
+	return &prometheusMetrics{provider: p, namespace: namespace}
+}
+
+// guard is shared by every collector of the same name
+func (p *Prometheus) guard(name string) *cardinalityGuard {
+	p.mMutex.Lock()
+	defer p.mMutex.Unlock()
+
+	g, found := p.mGuardMap[name]
+	if !found {
+		g = newCardinalityGuard(p.mMaxCardinality)
+		p.mGuardMap[name] = g
+	}
+
+	return g
+}
+
+// register returns the already registered collector of the same
+// name, so that several instances of a capability share it
+func (p *Prometheus) register(collector prometheus.Collector) prometheus.Collector {
+	if err := p.mRegisterer.Register(collector); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			return already.ExistingCollector
+		}
+		panic(err)
+	}
+
+	return collector
+}
+
+type prometheusMetrics struct {
+	provider  *Prometheus
+	namespace string
+}
+
+func (m *prometheusMetrics) Counter(name string, help string, labelNames ...string) iface.Counter {
+	fullName := FullName(m.namespace, name)
+	vec := m.provider.register(prometheus.NewCounterVec(prometheus.CounterOpts{Name: fullName, Help: help}, labelNames))
+
+	return &prometheusCounter{
+		vec:        vec.(*prometheus.CounterVec),
+		labelNames: labelNames,
+		guard:      m.provider.guard(fullName),
+	}
+}
+
+func (m *prometheusMetrics) Gauge(name string, help string, labelNames ...string) iface.Gauge {
+	fullName := FullName(m.namespace, name)
+	vec := m.provider.register(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: fullName, Help: help}, labelNames))
+
+	return &prometheusGauge{
+		vec:        vec.(*prometheus.GaugeVec),
+		labelNames: labelNames,
+		guard:      m.provider.guard(fullName),
+	}
+}
+
+func (m *prometheusMetrics) Histogram(name string, help string, buckets []float64, labelNames ...string) iface.Observer {
+	fullName := FullName(m.namespace, name)
+	vec := m.provider.register(prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: fullName, Help: help, Buckets: buckets}, labelNames))
+
+	return &prometheusObserver{
+		vec:        vec.(*prometheus.HistogramVec),
+		labelNames: labelNames,
+		guard:      m.provider.guard(fullName),
+	}
+}
+
+type prometheusCounter struct {
+	vec         *prometheus.CounterVec
+	labelNames  []string
+	labelValues []string
+	guard       *cardinalityGuard
+}
+
+func (c *prometheusCounter) With(values ...string) iface.Counter {
+	return &prometheusCounter{
+		vec:         c.vec,
+		labelNames:  c.labelNames,
+		labelValues: c.guard.check(labelValues(c.labelNames, values)),
+		guard:       c.guard,
+	}
+}
+
+func (c *prometheusCounter) Inc() {
+	c.vec.WithLabelValues(labelValues(c.labelNames, c.labelValues)...).Inc()
+}
+
+func (c *prometheusCounter) Add(delta float64) {
+	c.vec.WithLabelValues(labelValues(c.labelNames, c.labelValues)...).Add(delta)
+}
+
+type prometheusGauge struct {
+	vec         *prometheus.GaugeVec
+	labelNames  []string
+	labelValues []string
+	guard       *cardinalityGuard
+}
+
+func (g *prometheusGauge) With(values ...string) iface.Gauge {
+	return &prometheusGauge{
+		vec:         g.vec,
+		labelNames:  g.labelNames,
+		labelValues: g.guard.check(labelValues(g.labelNames, values)),
+		guard:       g.guard,
+	}
+}
+
+func (g *prometheusGauge) Set(value float64) {
+	g.vec.WithLabelValues(labelValues(g.labelNames, g.labelValues)...).Set(value)
+}
+
+func (g *prometheusGauge) Add(delta float64) {
+	g.vec.WithLabelValues(labelValues(g.labelNames, g.labelValues)...).Add(delta)
+}
+
+func (g *prometheusGauge) Sub(delta float64) {
+	g.vec.WithLabelValues(labelValues(g.labelNames, g.labelValues)...).Sub(delta)
+}
+
+type prometheusObserver struct {
+	vec         *prometheus.HistogramVec
+	labelNames  []string
+	labelValues []string
+	guard       *cardinalityGuard
+}
+
+func (o *prometheusObserver) With(values ...string) iface.Observer {
+	return &prometheusObserver{
+		vec:         o.vec,
+		labelNames:  o.labelNames,
+		labelValues: o.guard.check(labelValues(o.labelNames, values)),
+		guard:       o.guard,
+	}
+}
+
+func (o *prometheusObserver) Observe(value float64) {
+	o.vec.WithLabelValues(labelValues(o.labelNames, o.labelValues)...).Observe(value)
+}
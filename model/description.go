@@ -0,0 +1,31 @@
+package model
+
+// ServiceDescription documents the request and the responses of a service,
+// schemas are plain JSON Schema objects
+type ServiceDescription struct {
+	Summary     string                         `json:"summary,omitempty"`
+	Description string                         `json:"description,omitempty"`
+	Tags        []string                       `json:"tags,omitempty"`
+	Deprecated  bool                           `json:"deprecated,omitempty"`
+	Parameters  []*ParameterDescription        `json:"parameters,omitempty"`
+	Request     *ContentDescription            `json:"request,omitempty"`
+	Responses   map[string]*ContentDescription `json:"responses,omitempty"`
+}
+
+// ParameterDescription documents a query, header or path parameter
+type ParameterDescription struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Example     interface{}            `json:"example,omitempty"`
+}
+
+// ContentDescription documents a request or a response body
+type ContentDescription struct {
+	Description string                 `json:"description,omitempty"`
+	ContentType string                 `json:"content_type,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Example     interface{}            `json:"example,omitempty"`
+}
@@ -0,0 +1,83 @@
+package model
+
+import "net/textproto"
+
+func valueList(multi map[string]*StringList, single map[string]string, key string) []string {
+	if v, ok := multi[key]; ok {
+		return v.GetValues()
+	}
+
+	if v, ok := single[key]; ok {
+		return []string{v}
+	}
+
+	return nil
+}
+
+// HeaderValueList returns every value of the header key,
+// falls back to the single valued headers when the key is not present
+func (x *Metadata) HeaderValueList(key string) []string {
+	if x == nil {
+		return nil
+	}
+
+	if v := valueList(x.HeaderValues, x.Headers, key); v != nil {
+		return v
+	}
+
+	return valueList(x.HeaderValues, x.Headers, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// SetHeaderValueList replaces all values of the header key,
+// the first value is kept in the single valued headers for compatibility
+func (x *Metadata) SetHeaderValueList(key string, values []string) {
+	if x.HeaderValues == nil {
+		x.HeaderValues = make(map[string]*StringList)
+	}
+
+	if x.Headers == nil {
+		x.Headers = make(map[string]string)
+	}
+
+	x.HeaderValues[key] = &StringList{Values: append([]string{}, values...)}
+	if len(values) > 0 {
+		x.Headers[key] = values[0]
+	}
+}
+
+// AddHeaderValue appends the value to the header key
+func (x *Metadata) AddHeaderValue(key string, value string) {
+	x.SetHeaderValueList(key, append(x.HeaderValueList(key), value))
+}
+
+// QueryValueList returns every value of the query key,
+// falls back to the single valued query when the key is not present
+func (x *Metadata) QueryValueList(key string) []string {
+	if x == nil {
+		return nil
+	}
+
+	return valueList(x.QueryValues, x.Query, key)
+}
+
+// SetQueryValueList replaces all values of the query key,
+// the first value is kept in the single valued query for compatibility
+func (x *Metadata) SetQueryValueList(key string, values []string) {
+	if x.QueryValues == nil {
+		x.QueryValues = make(map[string]*StringList)
+	}
+
+	if x.Query == nil {
+		x.Query = make(map[string]string)
+	}
+
+	x.QueryValues[key] = &StringList{Values: append([]string{}, values...)}
+	if len(values) > 0 {
+		x.Query[key] = values[0]
+	}
+}
+
+// AddQueryValue appends the value to the query key
+func (x *Metadata) AddQueryValue(key string, value string) {
+	x.SetQueryValueList(key, append(x.QueryValueList(key), value))
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadata_HeaderValueList(t *testing.T) {
+	m := &Metadata{}
+	m.SetHeaderValueList("Accept", []string{"text/html", "application/json"})
+	m.AddHeaderValue("Set-Cookie", "a=1")
+	m.AddHeaderValue("Set-Cookie", "b=2")
+
+	if !reflect.DeepEqual(m.HeaderValueList("Accept"), []string{"text/html", "application/json"}) {
+		t.Errorf("HeaderValueList() = %v", m.HeaderValueList("Accept"))
+	}
+
+	if !reflect.DeepEqual(m.HeaderValueList("set-cookie"), []string{"a=1", "b=2"}) {
+		t.Errorf("HeaderValueList() = %v", m.HeaderValueList("set-cookie"))
+	}
+
+	if m.Headers["Accept"] != "text/html" {
+		t.Errorf("Headers[Accept] = %s, want %s", m.Headers["Accept"], "text/html")
+	}
+
+	legacy := &Metadata{Headers: map[string]string{"X-Id": "1"}}
+	if !reflect.DeepEqual(legacy.HeaderValueList("X-Id"), []string{"1"}) {
+		t.Errorf("HeaderValueList() = %v", legacy.HeaderValueList("X-Id"))
+	}
+
+	if legacy.HeaderValueList("X-Missing") != nil {
+		t.Errorf("HeaderValueList() = %v, want nil", legacy.HeaderValueList("X-Missing"))
+	}
+}
+
+func TestMetadata_QueryValueList(t *testing.T) {
+	m := &Metadata{}
+	m.AddQueryValue("tag", "a")
+	m.AddQueryValue("tag", "b")
+
+	if !reflect.DeepEqual(m.QueryValueList("tag"), []string{"a", "b"}) {
+		t.Errorf("QueryValueList() = %v", m.QueryValueList("tag"))
+	}
+
+	if m.Query["tag"] != "a" {
+		t.Errorf("Query[tag] = %s, want %s", m.Query["tag"], "a")
+	}
+}
+
+func TestGenerateOutputEvent_HeaderValues(t *testing.T) {
+	m := &Metadata{}
+	m.SetHeaderValueList("Content-Type", []string{"text/plain"})
+
+	e := GenerateOutputEvent(m, "test", "OK", 200, "application/json", nil)
+	if e.Metadata.HeaderValues != nil {
+		t.Errorf("HeaderValues = %v, want nil", e.Metadata.HeaderValues)
+	}
+
+	if !reflect.DeepEqual(e.Metadata.HeaderValueList("Content-Type"), []string{"application/json"}) {
+		t.Errorf("HeaderValueList() = %v", e.Metadata.HeaderValueList("Content-Type"))
+	}
+}
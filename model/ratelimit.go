@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// RateLimit is the result of taking a request from a rate limit, Reset is
+// the time until the limit is fully available again, Burst is the
+// capacity of a token bucket which may exceed the limit of a window
+type RateLimit struct {
+	Allowed    bool
+	Limit      int
+	Burst      int
+	Remaining  int
+	Window     time.Duration
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
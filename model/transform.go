@@ -20,6 +20,11 @@ func GenerateOutputEvent(inputMetadata *Metadata,
 
 	if inputMetadata != nil {
 		oE.Metadata = CloneMetadata(inputMetadata)
+		if oE.Metadata != nil {
+			// repeated header values of the input belong to the request only
+			oE.Metadata.HeaderValues = nil
+		}
+
 		if oE.Metadata != nil && oE.Metadata.Headers != nil {
 			oE.Metadata.Headers["Content-Type"] = typeUrl
 
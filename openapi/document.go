@@ -0,0 +1,74 @@
+package openapi
+
+// Version is the OpenAPI specification version of the generated documents
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       *Info               `json:"info"`
+	Servers    []*Server           `json:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components *Components         `json:"components,omitempty"`
+
+	// AuthorizerExpressionEnabled documents the authorizer expressions of
+	// the routes as x-abesh-authorizer-expression, they may reveal the
+	// access rules so they are left out by default
+	AuthorizerExpressionEnabled bool `json:"-"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps the lower case http method to the operation
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId          string                `json:"operationId"`
+	Summary              string                `json:"summary,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Tags                 []string              `json:"tags,omitempty"`
+	Deprecated           bool                  `json:"deprecated,omitempty"`
+	Parameters           []*Parameter          `json:"parameters,omitempty"`
+	RequestBody          *RequestBody          `json:"requestBody,omitempty"`
+	Responses            map[string]*Response  `json:"responses"`
+	Security             []map[string][]string `json:"security,omitempty"`
+	Service              string                `json:"x-abesh-service,omitempty"`
+	AuthorizerExpression string                `json:"x-abesh-authorizer-expression,omitempty"`
+}
+
+type Parameter struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Example     interface{}            `json:"example,omitempty"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema  map[string]interface{} `json:"schema,omitempty"`
+	Example interface{}            `json:"example,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]map[string]interface{} `json:"schemas,omitempty"`
+	SecuritySchemes map[string]map[string]interface{} `json:"securitySchemes,omitempty"`
+}
@@ -0,0 +1,331 @@
+package openapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+// HTTPServerContractId is the trigger whose routes are documented
+const HTTPServerContractId = "abesh:httpserver"
+
+const errorSchemaName = "HTTPResponseModel"
+
+// Route is a single service route of the http trigger
+type Route struct {
+	MethodList           []string
+	Path                 string
+	ServiceContractId    string
+	Service              iface.IService
+	Authorizer           string
+	AuthorizerExpression string
+	SecurityScheme       map[string]interface{}
+}
+
+// NewRoute builds a route from the trigger values the same way the
+// http trigger does
+func NewRoute(triggerValues model.ConfigMap, serviceContractId string, service iface.IService) *Route {
+	var methodList []string
+	for _, m := range strings.Split(triggerValues.String("method", ""), ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); len(m) != 0 {
+			methodList = append(methodList, m)
+		}
+	}
+
+	return &Route{
+		MethodList:        methodList,
+		Path:              triggerValues.String("path", ""),
+		ServiceContractId: serviceContractId,
+		Service:           service,
+	}
+}
+
+// SetAuthorizer documents the authorizer of the route, the security scheme
+// comes from iface.IDescribeSecurity when implemented
+func (r *Route) SetAuthorizer(contractId, expression string, authorizer iface.IAuthorizer) {
+	r.Authorizer = contractId
+	r.AuthorizerExpression = expression
+
+	if v, ok := authorizer.(iface.IDescribeSecurity); ok {
+		r.SecurityScheme = v.DescribeSecurity()
+	}
+}
+
+// RoutesFromManifest walks the manifest triggers of every capability
+// assigned from abesh:httpserver, services and authorizers are looked up
+// in the global registry
+func RoutesFromManifest(manifest *model.Manifest) []*Route {
+	// assigned contract id to the registered contract id
+	contractIdMap := make(map[string]string)
+	for _, c := range manifest.Capabilities {
+		if len(c.NewContractId) != 0 {
+			contractIdMap[c.NewContractId] = c.ContractId
+		} else {
+			contractIdMap[c.ContractId] = c.ContractId
+		}
+	}
+
+	lookup := func(contractId string) iface.ICapability {
+		if v, ok := contractIdMap[contractId]; ok {
+			contractId = v
+		}
+		return registry.GlobalRegistry().GetCapability(contractId)
+	}
+
+	var routeList []*Route
+	for _, t := range manifest.Triggers {
+		if contractId, ok := contractIdMap[t.Trigger]; !ok || contractId != HTTPServerContractId {
+			continue
+		}
+
+		service, _ := lookup(t.Service).(iface.IService)
+		route := NewRoute(t.TriggerValues, t.Service, service)
+
+		if len(t.Authorizer) != 0 {
+			authorizer, _ := lookup(t.Authorizer).(iface.IAuthorizer)
+			route.SetAuthorizer(t.Authorizer, t.AuthorizerExpression, authorizer)
+		}
+
+		routeList = append(routeList, route)
+	}
+
+	return routeList
+}
+
+// FromManifest generates the document of all http trigger routes in the manifest
+func FromManifest(manifest *model.Manifest, info *Info, serverList ...string) *Document {
+	return FromManifestWithDocument(manifest, NewDocument(info, serverList...))
+}
+
+// FromManifestWithDocument adds all http trigger routes in the manifest
+// to the document
+func FromManifestWithDocument(manifest *model.Manifest, d *Document) *Document {
+	for _, r := range RoutesFromManifest(manifest) {
+		d.AddRoute(r)
+	}
+
+	return d
+}
+
+func NewDocument(info *Info, serverList ...string) *Document {
+	d := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: &Components{
+			Schemas: map[string]map[string]interface{}{
+				errorSchemaName: {
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code":    map[string]interface{}{"type": "string"},
+						"message": map[string]interface{}{"type": "string"},
+						"lang":    map[string]interface{}{"type": "string"},
+						"data":    map[string]interface{}{},
+					},
+				},
+			},
+		},
+	}
+
+	for _, s := range serverList {
+		d.Servers = append(d.Servers, &Server{URL: s})
+	}
+
+	return d
+}
+
+// AddRoute adds an operation per method of the route, routes without
+// path or method are ignored
+func (d *Document) AddRoute(route *Route) {
+	if len(route.Path) == 0 || len(route.MethodList) == 0 {
+		return
+	}
+
+	path, parameterList := convertPath(route.Path)
+
+	var description *model.ServiceDescription
+	if v, ok := route.Service.(iface.IDescribe); ok {
+		description = v.Describe()
+	}
+
+	item := d.Paths[path]
+	if item == nil {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+
+	for _, method := range route.MethodList {
+		operation := &Operation{
+			OperationId: d.operationId(route.ServiceContractId, method),
+			Parameters:  append([]*Parameter{}, parameterList...),
+			Responses:   make(map[string]*Response),
+			Service:     route.ServiceContractId,
+		}
+
+		if d.AuthorizerExpressionEnabled {
+			operation.AuthorizerExpression = route.AuthorizerExpression
+		}
+
+		if description != nil {
+			applyDescription(operation, description, method)
+		}
+
+		if len(operation.Responses) == 0 {
+			operation.Responses["200"] = &Response{Description: "OK"}
+		}
+
+		if _, ok := operation.Responses["default"]; !ok {
+			operation.Responses["default"] = &Response{
+				Description: "Error",
+				Content: map[string]*MediaType{
+					"application/json": {Schema: map[string]interface{}{"$ref": "#/components/schemas/" + errorSchemaName}},
+				},
+			}
+		}
+
+		if len(route.Authorizer) != 0 {
+			d.addSecurityScheme(route.Authorizer, route.SecurityScheme)
+			operation.Security = []map[string][]string{{route.Authorizer: {}}}
+		}
+
+		item[strings.ToLower(method)] = operation
+	}
+}
+
+func (d *Document) addSecurityScheme(name string, scheme map[string]interface{}) {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = make(map[string]map[string]interface{})
+	}
+
+	if _, ok := d.Components.SecuritySchemes[name]; ok {
+		return
+	}
+
+	if scheme == nil {
+		scheme = map[string]interface{}{"type": "http", "scheme": "bearer"}
+	}
+
+	d.Components.SecuritySchemes[name] = scheme
+}
+
+// operationId is the service contract id and the method, made unique
+// with a numeric suffix
+func (d *Document) operationId(serviceContractId, method string) string {
+	base := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, serviceContractId) + "_" + strings.ToLower(method)
+
+	used := make(map[string]bool)
+	for _, item := range d.Paths {
+		for _, o := range item {
+			used[o.OperationId] = true
+		}
+	}
+
+	id := base
+	for index := 2; used[id]; index++ {
+		id = fmt.Sprintf("%s_%d", base, index)
+	}
+
+	return id
+}
+
+func applyDescription(operation *Operation, description *model.ServiceDescription, method string) {
+	operation.Summary = description.Summary
+	operation.Description = description.Description
+	operation.Tags = description.Tags
+	operation.Deprecated = description.Deprecated
+
+	// described parameters replace the ones derived from the path
+	for _, p := range description.Parameters {
+		parameter := &Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Schema:      p.Schema,
+			Example:     p.Example,
+		}
+
+		replaced := false
+		for index, existing := range operation.Parameters {
+			if existing.Name == p.Name && existing.In == p.In {
+				if parameter.Schema == nil {
+					parameter.Schema = existing.Schema
+				}
+				operation.Parameters[index] = parameter
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			operation.Parameters = append(operation.Parameters, parameter)
+		}
+	}
+
+	if description.Request != nil && method != "GET" && method != "HEAD" && method != "DELETE" {
+		operation.RequestBody = &RequestBody{
+			Description: description.Request.Description,
+			Required:    true,
+			Content:     content(description.Request),
+		}
+	}
+
+	for status, r := range description.Responses {
+		response := &Response{Description: r.Description, Content: content(r)}
+		if len(response.Description) == 0 {
+			response.Description = status
+		}
+		operation.Responses[status] = response
+	}
+}
+
+func content(c *model.ContentDescription) map[string]*MediaType {
+	if c.Schema == nil && c.Example == nil {
+		return nil
+	}
+
+	contentType := c.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
+
+	return map[string]*MediaType{contentType: {Schema: c.Schema, Example: c.Example}}
+}
+
+// convertPath turns the router pattern into an OpenAPI path, {name:regex}
+// becomes {name} with a pattern and wildcards become a path parameter
+func convertPath(pattern string) (string, []*Parameter) {
+	var parameterList []*Parameter
+
+	partList := strings.Split(pattern, "/")
+	for index, part := range partList {
+		var name string
+		schema := map[string]interface{}{"type": "string"}
+
+		switch {
+		case part == "*":
+			name = "wildcard"
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			inner := strings.TrimSuffix(part[1:len(part)-1], "...")
+			if i := strings.Index(inner, ":"); i >= 0 {
+				schema["pattern"] = inner[i+1:]
+				inner = inner[:i]
+			}
+			name = inner
+		default:
+			continue
+		}
+
+		partList[index] = "{" + name + "}"
+		parameterList = append(parameterList, &Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+
+	return strings.Join(partList, "/"), parameterList
+}
@@ -0,0 +1,157 @@
+package openapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mkawserm/abesh/iface"
+	"github.com/mkawserm/abesh/model"
+	"github.com/mkawserm/abesh/registry"
+)
+
+type testHTTPServer struct {
+	iface.ITrigger
+}
+
+func (t *testHTTPServer) ContractId() string {
+	return HTTPServerContractId
+}
+
+type testUserService struct {
+	iface.IService
+}
+
+func (s *testUserService) ContractId() string {
+	return "test:user"
+}
+
+func (s *testUserService) Serve(_ context.Context, event *model.Event) (*model.Event, error) {
+	return event, nil
+}
+
+func (s *testUserService) Describe() *model.ServiceDescription {
+	return &model.ServiceDescription{
+		Summary: "user",
+		Parameters: []*model.ParameterDescription{
+			{Name: "id", In: "path", Description: "user id"},
+		},
+		Request: &model.ContentDescription{Schema: map[string]interface{}{"type": "object"}},
+		Responses: map[string]*model.ContentDescription{
+			"200": {Description: "the user", Example: map[string]interface{}{"id": 1}},
+		},
+	}
+}
+
+type testAuthorizer struct {
+	iface.IAuthorizer
+}
+
+func (a *testAuthorizer) ContractId() string {
+	return "test:authorizer"
+}
+
+func (a *testAuthorizer) DescribeSecurity() map[string]interface{} {
+	return map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Api-Key"}
+}
+
+func TestConvertPath(t *testing.T) {
+	path, parameterList := convertPath("/user/{id:[0-9]+}/files/{rest...}")
+	if path != "/user/{id}/files/{rest}" {
+		t.Errorf("path = %s", path)
+	}
+
+	if len(parameterList) != 2 || parameterList[0].Schema["pattern"] != "[0-9]+" || parameterList[1].Name != "rest" {
+		t.Errorf("parameters = %v", parameterList)
+	}
+}
+
+func TestFromManifest(t *testing.T) {
+	registry.GlobalRegistry().AddCapability(&testHTTPServer{})
+	registry.GlobalRegistry().AddCapability(&testUserService{})
+	registry.GlobalRegistry().AddCapability(&testAuthorizer{})
+
+	manifest := &model.Manifest{
+		Capabilities: []*model.CapabilityManifest{
+			{ContractId: HTTPServerContractId, NewContractId: "public"},
+			{ContractId: "test:user"},
+			{ContractId: "test:authorizer"},
+		},
+		Triggers: []*model.TriggerManifest{
+			{
+				Trigger:              "public",
+				TriggerValues:        model.ConfigMap{"method": "GET,PUT", "path": "/user/{id:[0-9]+}"},
+				Service:              "test:user",
+				Authorizer:           "test:authorizer",
+				AuthorizerExpression: "role=admin",
+			},
+			{Trigger: "other", TriggerValues: model.ConfigMap{"method": "GET", "path": "/other"}, Service: "test:user"},
+		},
+	}
+
+	d := FromManifest(manifest, &Info{Title: "test", Version: "1"})
+	if len(d.Paths) != 1 {
+		t.Fatalf("paths = %v", d.Paths)
+	}
+
+	item := d.Paths["/user/{id}"]
+	if item == nil || item["get"] == nil || item["put"] == nil {
+		t.Fatalf("path item = %v", item)
+	}
+
+	get, put := item["get"], item["put"]
+	if get.OperationId != "test_user_get" || put.OperationId != "test_user_put" {
+		t.Errorf("operation ids = %s, %s", get.OperationId, put.OperationId)
+	}
+
+	if get.RequestBody != nil || put.RequestBody == nil {
+		t.Errorf("request body get = %v, put = %v", get.RequestBody, put.RequestBody)
+	}
+
+	if len(get.Parameters) != 1 || get.Parameters[0].Description != "user id" || get.Parameters[0].Schema["pattern"] != "[0-9]+" {
+		t.Errorf("parameters = %v", get.Parameters)
+	}
+
+	if get.Responses["200"].Content["application/json"] == nil || get.Responses["default"] == nil {
+		t.Errorf("responses = %v", get.Responses)
+	}
+
+	if len(get.Security) != 1 || d.Components.SecuritySchemes["test:authorizer"]["type"] != "apiKey" {
+		t.Errorf("security = %v, schemes = %v", get.Security, d.Components.SecuritySchemes)
+	}
+
+	// the authorizer expression is documented only when enabled
+	if len(get.AuthorizerExpression) != 0 {
+		t.Errorf("authorizer expression = %s", get.AuthorizerExpression)
+	}
+
+	d = NewDocument(&Info{Title: "test", Version: "1"})
+	d.AuthorizerExpressionEnabled = true
+	FromManifestWithDocument(manifest, d)
+	if v := d.Paths["/user/{id}"]["get"].AuthorizerExpression; v != "role=admin" {
+		t.Errorf("enabled authorizer expression = %s", v)
+	}
+}
+
+func TestUIHandler(t *testing.T) {
+	handler := UIHandler("test", "/openapi.json")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	page := recorder.Body.String()
+	if strings.Contains(page, "unpkg.com") || !strings.Contains(page, `src="/docs/swagger-ui-bundle.js"`) {
+		t.Errorf("page = %s", page)
+	}
+
+	for _, name := range []string{"swagger-ui.css", "swagger-ui-bundle.js"} {
+		recorder = httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/docs/"+name, nil))
+
+		if recorder.Code != http.StatusOK || recorder.Body.Len() == 0 {
+			t.Errorf("%s = %d", name, recorder.Code)
+		}
+	}
+}
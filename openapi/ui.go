@@ -0,0 +1,79 @@
+package openapi
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// swaggerUIAssetMap lists the swagger ui files served next to the page,
+// they are embedded so that the page works without a CDN
+var swaggerUIAssetMap = map[string]bool{
+	"swagger-ui.css":       true,
+	"swagger-ui-bundle.js": true,
+	"favicon-32x32.png":    true,
+	"favicon-16x16.png":    true,
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.AssetPath}}swagger-ui.css"/>
+  <link rel="icon" type="image/png" href="{{.AssetPath}}favicon-32x32.png" sizes="32x32"/>
+  <link rel="icon" type="image/png" href="{{.AssetPath}}favicon-16x16.png" sizes="16x16"/>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.AssetPath}}swagger-ui-bundle.js"></script>
+<script>
+  window.onload = function () {
+    window.ui = SwaggerUIBundle({url: "{{.SpecURL}}", dom_id: "#swagger-ui"});
+  };
+</script>
+</body>
+</html>
+`))
+
+// DocumentHandler serves the document returned by build as json
+func DocumentHandler(build func() *Document) http.HandlerFunc {
+	return func(writer http.ResponseWriter, _ *http.Request) {
+		data, err := json.Marshal(build())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write(data)
+	}
+}
+
+// UIHandler serves the swagger ui page for the document at specURL along
+// with the embedded assets below the page path, the handler is registered
+// for the page path and its subtree
+func UIHandler(title, specURL string) http.HandlerFunc {
+	fileServer := http.FileServer(http.FS(swaggerFiles.FS))
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := path.Base(request.URL.Path)
+		if swaggerUIAssetMap[name] {
+			r := request.Clone(request.Context())
+			r.URL.Path = "/" + name
+			fileServer.ServeHTTP(writer, r)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = uiTemplate.Execute(writer, map[string]string{
+			"Title":     title,
+			"AssetPath": strings.TrimSuffix(request.URL.Path, "/") + "/",
+			"SpecURL":   specURL,
+		})
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/mkawserm/abesh/logger"
 	"github.com/mkawserm/abesh/model"
 	"github.com/mkawserm/abesh/registry"
+	"github.com/mkawserm/abesh/tracing"
 )
 
 var ErrCapabilityNotFound = errors.New("capability is not found in the global registry")
@@ -156,6 +157,63 @@ func (o *One) callSetup(capability iface.ICapability) error {
 	return nil
 }
 
+func (o *One) callSetMetrics(contractId string, capability iface.ICapability, provider iface.IMetricsProvider) error {
+	v, ok := capability.(iface.ISetMetrics)
+	logger.L(constant.Name).Debug("callSetMetrics info",
+		zap.String("contract_id", contractId),
+		zap.Bool("ok", ok))
+
+	if ok {
+		return v.SetMetrics(provider.Metrics(contractId))
+	}
+	return nil
+}
+
+// injectMetrics gives every capability its own metrics namespaced by the
+// assigned contract id, nothing is injected without a metrics provider
+func (o *One) injectMetrics() error {
+	var provider iface.IMetricsProvider
+	for _, c := range o.capabilityRegistry.Iterator() {
+		if p, ok := c.(iface.IMetricsProvider); ok {
+			provider = p
+			break
+		}
+	}
+
+	if provider == nil {
+		return nil
+	}
+
+	capabilityMap := make(map[string]iface.ICapability)
+	for k, c := range o.triggersCapability {
+		capabilityMap[k] = c
+	}
+	for k, c := range o.rpcsCapability {
+		capabilityMap[k] = c
+	}
+	for k, c := range o.servicesCapability {
+		capabilityMap[k] = c
+	}
+	for k, c := range o.authorizersCapability {
+		capabilityMap[k] = c
+	}
+	for k, c := range o.consumersCapability {
+		capabilityMap[k] = c
+	}
+
+	for k, c := range o.capabilityRegistry.Iterator() {
+		capabilityMap[k] = c
+	}
+
+	for k, c := range capabilityMap {
+		if err := o.callSetMetrics(k, c, provider); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (o *One) configureCapabilities(manifest *model.Manifest) error {
 	var err error
 
@@ -209,6 +267,10 @@ func (o *One) configureCapabilities(manifest *model.Manifest) error {
 		}
 	}
 
+	if err = o.injectMetrics(); err != nil {
+		return err
+	}
+
 	// for triggers
 	for _, c := range o.triggersCapability {
 		if errLocal := o.callSetCapabilityRegistry(c); errLocal != nil {
@@ -338,7 +400,14 @@ func (o *One) configureTriggers(manifest *model.Manifest) error {
 func (o *One) configureRPCS(manifest *model.Manifest) error {
 	// configuring RPCS
 	for _, s := range manifest.RPCS {
-		rpc := o.rpcsCapability[s.RPC]
+		var rpc iface.IAddAuthorizer
+		if v := o.rpcsCapability[s.RPC]; v != nil {
+			rpc = v
+		} else if v, ok := o.triggersCapability[s.RPC].(iface.IAddAuthorizer); ok {
+			// triggers like jsonrpc support per method authorizer
+			rpc = v
+		}
+
 		if rpc == nil {
 			return ErrRPCNotRegistered
 		}
@@ -351,9 +420,9 @@ func (o *One) configureRPCS(manifest *model.Manifest) error {
 			if errLocal := rpc.AddAuthorizer(authorizer, s.AuthorizerExpression, s.Method); errLocal != nil {
 				return errLocal
 			}
-			logger.L(constant.Name).Debug("authorizers setup complete", zap.String("contract_id", rpc.ContractId()))
+			logger.L(constant.Name).Debug("authorizers setup complete", zap.String("contract_id", s.RPC))
 		} else {
-			logger.L(constant.Name).Debug("no authorizer defined", zap.String("contract_id", rpc.ContractId()))
+			logger.L(constant.Name).Debug("no authorizer defined", zap.String("contract_id", s.RPC))
 		}
 	}
 
@@ -464,7 +533,14 @@ func (o *One) eventDispatcher() {
 					if consumer == nil {
 						return
 					}
+
+					_, span := tracing.StartSpan(
+						tracing.ContextWithTraceParent(context.Background(), edc.Event.GetMetadata().GetTraceParent()),
+						"consume input "+consumer.ContractId(), tracing.SpanKindConsumer)
+					defer span.End()
+
 					err := consumer.ConsumeInputEvent(edc.ContractId, edc.Event)
+					span.SetError(err)
 					if err != nil {
 						logger.L(constant.Name).Error("error while sending input event data to consumer",
 							zap.String("source", edc.ContractId))
@@ -478,7 +554,14 @@ func (o *One) eventDispatcher() {
 					if consumer == nil {
 						return
 					}
+
+					_, span := tracing.StartSpan(
+						tracing.ContextWithTraceParent(context.Background(), edc.Event.GetMetadata().GetTraceParent()),
+						"consume output "+consumer.ContractId(), tracing.SpanKindConsumer)
+					defer span.End()
+
 					err := consumer.ConsumeOutputEvent(edc.ContractId, edc.Event)
+					span.SetError(err)
 					if err != nil {
 						logger.L(constant.Name).Error("error while sending output event data to consumer",
 							zap.String("source", edc.ContractId))
@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const TraceParentHeader = "traceparent"
+const TraceStateHeader = "tracestate"
+
+const flagSampled = 0x01
+
+// SpanContext is the W3C trace context of a span
+type SpanContext struct {
+	TraceId string
+	SpanId  string
+	Flags   byte
+	State   string
+}
+
+// IsValid reports whether both trace id and span id are set
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceId) == 32 && len(sc.SpanId) == 16
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled == flagSampled
+}
+
+// TraceParent formats the span context as the traceparent header value
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceId, sc.SpanId, sc.Flags)
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length || s == strings.Repeat("0", length) {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ParseTraceParent parses the traceparent header value
+// (version-traceid-parentid-flags)
+func ParseTraceParent(traceParent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	// version 00 has exactly 4 parts, future versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	return SpanContext{
+		TraceId: strings.ToLower(parts[1]),
+		SpanId:  strings.ToLower(parts[2]),
+		Flags:   flags[0],
+	}, true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", n*2)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of ctx, nil when not present
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of ctx carrying the span context
+// received from another process, used as the parent of the next span
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ContextWithTraceParent is ContextWithRemoteParent for a traceparent value,
+// ctx is returned as is when it already carries a span or the value is invalid
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if SpanFromContext(ctx) != nil {
+		return ctx
+	}
+
+	sc, ok := ParseTraceParent(traceParent)
+	if !ok {
+		return ctx
+	}
+
+	return ContextWithRemoteParent(ctx, sc)
+}
+
+// parentSpanContext returns the span context of the current or remote parent
+func parentSpanContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract returns a copy of ctx carrying the trace context of the headers
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceParent(header.Get(TraceParentHeader))
+	if !ok {
+		return ctx
+	}
+
+	sc.State = header.Get(TraceStateHeader)
+
+	return ContextWithRemoteParent(ctx, sc)
+}
+
+// Inject writes the trace context of the current span into the headers
+func Inject(ctx context.Context, header http.Header) {
+	sc, ok := parentSpanContext(ctx)
+	if !ok || !sc.IsValid() {
+		return
+	}
+
+	header.Set(TraceParentHeader, sc.TraceParent())
+	if len(sc.State) != 0 {
+		header.Set(TraceStateHeader, sc.State)
+	}
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter sends the ended spans to a backend
+type Exporter interface {
+	Export(spanList []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// WriterExporter writes every span as a JSON line
+type WriterExporter struct {
+	mMutex  sync.Mutex
+	mWriter io.Writer
+	mCloser io.Closer
+}
+
+func NewWriterExporter(writer io.Writer) *WriterExporter {
+	return &WriterExporter{mWriter: writer}
+}
+
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// NewFileExporter appends the spans to the file, the file is created when missing
+func NewFileExporter(filePath string) (*WriterExporter, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriterExporter{mWriter: file, mCloser: file}, nil
+}
+
+func (w *WriterExporter) Export(spanList []*SpanData) error {
+	w.mMutex.Lock()
+	defer w.mMutex.Unlock()
+
+	encoder := json.NewEncoder(w.mWriter)
+	for _, s := range spanList {
+		if err := encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *WriterExporter) Shutdown(_ context.Context) error {
+	w.mMutex.Lock()
+	defer w.mMutex.Unlock()
+
+	if w.mCloser != nil {
+		return w.mCloser.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLP/HTTP JSON encoding of the trace export request
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func keyValueList(values map[string]string) []otlpKeyValue {
+	keyList := make([]string, 0, len(values))
+	for k := range values {
+		keyList = append(keyList, k)
+	}
+	sort.Strings(keyList)
+
+	output := make([]otlpKeyValue, 0, len(values))
+	for _, k := range keyList {
+		kv := otlpKeyValue{Key: k}
+		kv.Value.StringValue = values[k]
+		output = append(output, kv)
+	}
+
+	return output
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// OTLPHTTPExporter posts the spans to an OTLP/HTTP collector using
+// the JSON encoding (ex: http://localhost:4318/v1/traces)
+type OTLPHTTPExporter struct {
+	mEndpoint   string
+	mHeaders    map[string]string
+	mHttpClient *http.Client
+}
+
+func NewOTLPHTTPExporter(endpoint string, headers map[string]string, timeout time.Duration) *OTLPHTTPExporter {
+	return &OTLPHTTPExporter{
+		mEndpoint:   endpoint,
+		mHeaders:    headers,
+		mHttpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (o *OTLPHTTPExporter) encode(spanList []*SpanData) ([]byte, error) {
+	// spans are grouped by the service name
+	groupMap := make(map[string][]otlpSpan)
+	serviceList := make([]string, 0)
+
+	for _, s := range spanList {
+		if _, found := groupMap[s.ServiceName]; !found {
+			serviceList = append(serviceList, s.ServiceName)
+		}
+
+		groupMap[s.ServiceName] = append(groupMap[s.ServiceName], otlpSpan{
+			TraceId:           s.TraceId,
+			SpanId:            s.SpanId,
+			ParentSpanId:      s.ParentSpanId,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: unixNano(s.StartTime),
+			EndTimeUnixNano:   unixNano(s.EndTime),
+			Attributes:        keyValueList(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		})
+	}
+
+	request := &otlpRequest{}
+	for _, serviceName := range serviceList {
+		rs := otlpResourceSpans{}
+		rs.Resource.Attributes = keyValueList(map[string]string{"service.name": serviceName})
+
+		ss := otlpScopeSpans{Spans: groupMap[serviceName]}
+		ss.Scope.Name = "github.com/mkawserm/abesh/tracing"
+		rs.ScopeSpans = []otlpScopeSpans{ss}
+
+		request.ResourceSpans = append(request.ResourceSpans, rs)
+	}
+
+	return json.Marshal(request)
+}
+
+func (o *OTLPHTTPExporter) Export(spanList []*SpanData) error {
+	data, err := o.encode(spanList)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, o.mEndpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range o.mHeaders {
+		request.Header.Set(k, v)
+	}
+
+	response, err := o.mHttpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("otlp export failed with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+func (o *OTLPHTTPExporter) Shutdown(_ context.Context) error {
+	o.mHttpClient.CloseIdleConnections()
+	return nil
+}
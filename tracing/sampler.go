@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+)
+
+// Sampler decides whether a new span is recorded,
+// parent is nil for the root span of a trace
+type Sampler interface {
+	ShouldSample(traceId string, parent *SpanContext) bool
+}
+
+type alwaysSampler struct{}
+
+func (alwaysSampler) ShouldSample(string, *SpanContext) bool {
+	return true
+}
+
+type neverSampler struct{}
+
+func (neverSampler) ShouldSample(string, *SpanContext) bool {
+	return false
+}
+
+type ratioSampler struct {
+	bound uint64
+}
+
+// sampling is deterministic per trace id, so every process
+// of the trace makes the same decision
+func (r ratioSampler) ShouldSample(traceId string, _ *SpanContext) bool {
+	b, err := hex.DecodeString(traceId)
+	if err != nil || len(b) < 16 {
+		return false
+	}
+
+	return binary.BigEndian.Uint64(b[8:16]) < r.bound
+}
+
+type parentBasedSampler struct {
+	root Sampler
+}
+
+func (p parentBasedSampler) ShouldSample(traceId string, parent *SpanContext) bool {
+	if parent != nil {
+		return parent.IsSampled()
+	}
+
+	return p.root.ShouldSample(traceId, parent)
+}
+
+func AlwaysSample() Sampler {
+	return alwaysSampler{}
+}
+
+func NeverSample() Sampler {
+	return neverSampler{}
+}
+
+// RatioSample samples the given fraction of the traces, ratio is between 0 and 1
+func RatioSample(ratio float64) Sampler {
+	if ratio >= 1 {
+		return AlwaysSample()
+	}
+
+	if ratio <= 0 {
+		return NeverSample()
+	}
+
+	return ratioSampler{bound: uint64(ratio * math.MaxUint64)}
+}
+
+// ParentBased follows the decision of the parent span,
+// root decides for the spans without parent
+func ParentBased(root Sampler) Sampler {
+	return parentBasedSampler{root: root}
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// values follow the OTLP span kind
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+type StatusCode int
+
+// values follow the OTLP status code
+const (
+	StatusUnset StatusCode = 0
+	StatusOk    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// SpanData is the immutable snapshot of an ended span given to the exporters
+type SpanData struct {
+	ServiceName   string            `json:"service_name"`
+	Name          string            `json:"name"`
+	Kind          SpanKind          `json:"kind"`
+	TraceId       string            `json:"trace_id"`
+	SpanId        string            `json:"span_id"`
+	ParentSpanId  string            `json:"parent_span_id,omitempty"`
+	StartTime     time.Time         `json:"start_time"`
+	EndTime       time.Time         `json:"end_time"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	Status        StatusCode        `json:"status"`
+	StatusMessage string            `json:"status_message,omitempty"`
+}
+
+// Span is safe to use from multiple goroutines, a span which is not
+// sampled only propagates the trace context and records nothing
+type Span struct {
+	mMutex sync.Mutex
+
+	mTracer      *Tracer
+	mSpanContext SpanContext
+	mData        *SpanData
+	mEnded       bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.mSpanContext
+}
+
+// IsRecording reports whether the span will be exported
+func (s *Span) IsRecording() bool {
+	return s != nil && s.mData != nil
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mMutex.Lock()
+	defer s.mMutex.Unlock()
+
+	if s.mData.Attributes == nil {
+		s.mData.Attributes = make(map[string]string)
+	}
+	s.mData.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mMutex.Lock()
+	defer s.mMutex.Unlock()
+
+	s.mData.Status = code
+	s.mData.StatusMessage = message
+}
+
+// SetError marks the span as failed, nil error is ignored
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End records the end time and hands the span to the exporter,
+// calling End more than once has no effect
+func (s *Span) End() {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mMutex.Lock()
+	if s.mEnded {
+		s.mMutex.Unlock()
+		return
+	}
+	s.mEnded = true
+	s.mData.EndTime = time.Now()
+	data := *s.mData
+
+	// the exporter never shares the map with the span
+	if s.mData.Attributes != nil {
+		data.Attributes = make(map[string]string, len(s.mData.Attributes))
+		for k, v := range s.mData.Attributes {
+			data.Attributes[k] = v
+		}
+	}
+	s.mMutex.Unlock()
+
+	s.mTracer.enqueue(&data)
+}
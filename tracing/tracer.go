@@ -0,0 +1,249 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	globalMutex  sync.RWMutex
+	globalTracer *Tracer
+)
+
+// SetTracer sets the tracer used by StartSpan, nil disables tracing
+func SetTracer(tracer *Tracer) {
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+
+	globalTracer = tracer
+}
+
+// GetTracer returns the tracer used by StartSpan, it may be nil
+func GetTracer() *Tracer {
+	globalMutex.RLock()
+	defer globalMutex.RUnlock()
+
+	return globalTracer
+}
+
+// StartSpan starts a child span of the span carried by ctx with the global tracer,
+// the span must be ended by the caller
+//
+//	ctx, span := tracing.StartSpan(ctx, "load user", tracing.SpanKindInternal)
+//	defer span.End()
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return GetTracer().Start(ctx, name, kind)
+}
+
+// Tracer creates spans and exports the ended ones in batches
+type Tracer struct {
+	mServiceName   string
+	mSampler       Sampler
+	mExporter      Exporter
+	mBatchSize     int
+	mFlushInterval time.Duration
+
+	mQueue     chan *SpanData
+	mFlush     chan chan struct{}
+	mDone      chan struct{}
+	mWaitGroup sync.WaitGroup
+	mStopOnce  sync.Once
+}
+
+// NewTracer starts the export loop, spans are dropped when
+// more than queueSize spans are waiting for the export
+func NewTracer(serviceName string, sampler Sampler, exporter Exporter, batchSize int, queueSize int, flushInterval time.Duration) *Tracer {
+	if sampler == nil {
+		sampler = ParentBased(AlwaysSample())
+	}
+
+	if batchSize <= 0 {
+		batchSize = 512
+	}
+
+	if queueSize < batchSize {
+		queueSize = batchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+
+	t := &Tracer{
+		mServiceName:   serviceName,
+		mSampler:       sampler,
+		mExporter:      exporter,
+		mBatchSize:     batchSize,
+		mFlushInterval: flushInterval,
+		mQueue:         make(chan *SpanData, queueSize),
+		mFlush:         make(chan chan struct{}),
+		mDone:          make(chan struct{}),
+	}
+
+	t.mWaitGroup.Add(1)
+	go t.loop()
+
+	return t
+}
+
+// Start starts a child span of the span carried by ctx, nil tracer
+// returns a span which only propagates the trace context
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	parent, hasParent := parentSpanContext(ctx)
+
+	sc := SpanContext{SpanId: randomHex(8)}
+	if hasParent && parent.IsValid() {
+		sc.TraceId = parent.TraceId
+		sc.State = parent.State
+	} else {
+		hasParent = false
+		sc.TraceId = randomHex(16)
+	}
+
+	span := &Span{mTracer: t}
+
+	var parentPointer *SpanContext
+	if hasParent {
+		parentPointer = &parent
+	}
+
+	if t != nil && t.mExporter != nil && t.mSampler.ShouldSample(sc.TraceId, parentPointer) {
+		sc.Flags |= flagSampled
+
+		span.mData = &SpanData{
+			ServiceName: t.mServiceName,
+			Name:        name,
+			Kind:        kind,
+			TraceId:     sc.TraceId,
+			SpanId:      sc.SpanId,
+			StartTime:   time.Now(),
+		}
+
+		if hasParent {
+			span.mData.ParentSpanId = parent.SpanId
+		}
+	} else if t == nil && hasParent {
+		// keep the decision of the caller when tracing is disabled here
+		sc.Flags = parent.Flags
+	}
+
+	span.mSpanContext = sc
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) enqueue(data *SpanData) {
+	select {
+	case t.mQueue <- data:
+	default:
+		// queue is full, the span is dropped
+	}
+}
+
+func (t *Tracer) export(batch []*SpanData) []*SpanData {
+	if len(batch) != 0 && t.mExporter != nil {
+		// export errors are not fatal for the traced requests
+		_ = t.mExporter.Export(batch)
+	}
+
+	return batch[:0]
+}
+
+func (t *Tracer) drain(batch []*SpanData) []*SpanData {
+	for {
+		select {
+		case data := <-t.mQueue:
+			batch = append(batch, data)
+			if len(batch) >= t.mBatchSize {
+				batch = t.export(batch)
+			}
+		default:
+			return t.export(batch)
+		}
+	}
+}
+
+func (t *Tracer) loop() {
+	defer t.mWaitGroup.Done()
+
+	ticker := time.NewTicker(t.mFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, t.mBatchSize)
+
+	for {
+		select {
+		case data := <-t.mQueue:
+			batch = append(batch, data)
+			if len(batch) >= t.mBatchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case done := <-t.mFlush:
+			batch = t.drain(batch)
+			close(done)
+		case <-t.mDone:
+			t.drain(batch)
+			return
+		}
+	}
+}
+
+// ForceFlush exports every queued span
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+
+	select {
+	case t.mFlush <- done:
+	case <-t.mDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the queued spans and closes the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mStopOnce.Do(func() {
+		close(t.mDone)
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		t.mWaitGroup.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if t.mExporter == nil {
+		return nil
+	}
+
+	return t.mExporter.Shutdown(ctx)
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	sc, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || sc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId != "00f067aa0ba902b7" || !sc.IsSampled() {
+		t.Fatalf("ParseTraceParent() = %+v, %v", sc, ok)
+	}
+
+	if sc.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("TraceParent() = %s", sc.TraceParent())
+	}
+
+	for _, v := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok = ParseTraceParent(v); ok {
+			t.Errorf("ParseTraceParent(%s) is valid", v)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	parent := &SpanContext{Flags: 0}
+	if ParentBased(AlwaysSample()).ShouldSample("4bf92f3577b34da6a3ce929d0e0e4736", parent) {
+		t.Error("ParentBased() ignores the parent decision")
+	}
+
+	if !RatioSample(0.5).ShouldSample("4bf92f3577b34da60000000000000000", nil) {
+		t.Error("RatioSample(0.5) rejects the low trace id")
+	}
+
+	if RatioSample(0.5).ShouldSample("4bf92f3577b34da6ffffffffffffffff", nil) {
+		t.Error("RatioSample(0.5) accepts the high trace id")
+	}
+}
+
+type memoryExporter struct {
+	mutex    sync.Mutex
+	spanList []*SpanData
+}
+
+func (m *memoryExporter) Export(spanList []*SpanData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.spanList = append(m.spanList, spanList...)
+	return nil
+}
+
+func (m *memoryExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", AlwaysSample(), exporter, 10, 100, time.Hour)
+
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, server := tracer.Start(Extract(context.Background(), header), "server", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetError(errors.New("failed"))
+	child.SetAttribute("a", "1")
+	child.End()
+	server.End()
+
+	// the exported attributes are not changed by the ended span
+	child.SetAttribute("a", "2")
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if outgoing.Get(TraceParentHeader) != server.SpanContext().TraceParent() {
+		t.Errorf("Inject() = %s, want %s", outgoing.Get(TraceParentHeader), server.SpanContext().TraceParent())
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.spanList) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exporter.spanList))
+	}
+
+	c, s := exporter.spanList[0], exporter.spanList[1]
+	if s.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanId != "00f067aa0ba902b7" {
+		t.Errorf("server span = %+v", s)
+	}
+
+	if c.TraceId != s.TraceId || c.ParentSpanId != s.SpanId || c.Status != StatusError || c.Attributes["a"] != "1" {
+		t.Errorf("child span = %+v", c)
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	received := make(chan *otlpRequest, 1)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		data, _ := ioutil.ReadAll(request.Body)
+		r := &otlpRequest{}
+		if request.URL.Path != "/v1/traces" || request.Header.Get("Authorization") != "token" || json.Unmarshal(data, r) != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received <- r
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPHTTPExporter(collector.URL+"/v1/traces", map[string]string{"Authorization": "token"}, time.Second)
+	tracer := NewTracer("orders", AlwaysSample(), exporter, 10, 100, time.Hour)
+
+	_, span := tracer.Start(context.Background(), "GET /orders", SpanKindServer)
+	span.SetAttribute("http.method", "GET")
+	span.End()
+
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-received:
+		rs := r.ResourceSpans[0]
+		if rs.Resource.Attributes[0].Value.StringValue != "orders" {
+			t.Errorf("service.name = %s", rs.Resource.Attributes[0].Value.StringValue)
+		}
+
+		s := rs.ScopeSpans[0].Spans[0]
+		if s.Name != "GET /orders" || s.Kind != SpanKindServer || s.Attributes[0].Key != "http.method" {
+			t.Errorf("span = %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("collector received nothing")
+	}
+
+	_ = tracer.Shutdown(context.Background())
+}
@@ -3,32 +3,20 @@ package utility
 import (
 	"fmt"
 	"github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/i18n"
+	"github.com/mkawserm/abesh/iface"
 	"github.com/mkawserm/abesh/model"
-	"golang.org/x/text/language"
 )
 
+// GetLanguage negotiates the Accept-Language header against the
+// languages of the shared i18n catalog
 func GetLanguage(headers map[string]string) string {
 	lang, found := headers["accept-language"]
 	if !found {
-		lang, found = headers["Accept-Language"]
-		if !found {
-			lang = "en"
-		}
-	}
-
-	tag, _, err := language.ParseAcceptLanguage(lang)
-	if err != nil {
-		lang = "en"
-	}
-
-	for i := range tag {
-		if len(tag[i].String()) != 2 {
-			lang = tag[i].String()[0:2]
-		}
-		break
+		lang = headers["Accept-Language"]
 	}
 
-	return lang
+	return i18n.GetCatalog().Language(lang)
 }
 
 func GetErrorResponseCode(err *errors.Error) string {
@@ -39,20 +27,40 @@ func GetSuccessResponseCode(status *model.Status) string {
 	return fmt.Sprintf("%s_%d", status.GetPrefix(), status.GetCode())
 }
 
-func GetErrorMessage(err *errors.Error, lang string) string {
-	message, found := err.GetParams()[lang]
-	if found {
-		return message
+// localizedMessage looks the language and its parents up in the params,
+// then the prefix in the shared i18n catalog, the message itself takes
+// precedence over the default language of the catalog
+func localizedMessage(params map[string]string, prefix string, message string, lang string) string {
+	for _, l := range i18n.Chain(lang) {
+		if v, found := params[l]; found {
+			return v
+		}
 	}
 
-	return err.GetMessage()
-}
+	if v, found := i18n.GetCatalog().LanguageMessage(lang, prefix); found {
+		return v
+	}
 
-func GetSuccessMessage(status *model.Status, lang string) string {
-	message, found := status.GetParams()[lang]
-	if found {
+	if len(message) != 0 {
 		return message
 	}
 
-	return status.GetMessage()
+	if v, found := i18n.GetCatalog().DefaultMessage(prefix); found {
+		return v
+	}
+
+	return message
+}
+
+func GetErrorMessage(err *errors.Error, lang string) string {
+	return localizedMessage(err.GetParams(), err.GetPrefix(), err.GetMessage(), lang)
+}
+
+// GetError2Message localizes any iface.IError2 like GetErrorMessage
+func GetError2Message(err iface.IError2, lang string) string {
+	return localizedMessage(err.GetParams(), err.GetPrefix(), err.GetMessage(), lang)
+}
+
+func GetSuccessMessage(status *model.Status, lang string) string {
+	return localizedMessage(status.GetParams(), status.GetPrefix(), status.GetMessage(), lang)
 }
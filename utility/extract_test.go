@@ -1,6 +1,11 @@
 package utility
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/mkawserm/abesh/errors"
+	"github.com/mkawserm/abesh/i18n"
+)
 
 func TestGetLanguage(t *testing.T) {
 	headers := map[string]string{"accept-language": "en-US,en;q=0.5"}
@@ -28,3 +33,37 @@ func TestGetLanguage(t *testing.T) {
 		t.Errorf("expected language en but got %s", lang)
 	}
 }
+
+func TestGetErrorMessage_Catalog(t *testing.T) {
+	catalog, _ := i18n.New("en")
+	_ = catalog.Add("en", map[string]string{"bad_request.validation": "Invalid request", "bad_request.empty": "Empty request"})
+	_ = catalog.Add("bn", map[string]string{"bad_request.validation": "অবৈধ অনুরোধ"})
+	i18n.SetCatalog(catalog)
+	defer i18n.SetCatalog(nil)
+
+	lang := GetLanguage(map[string]string{"Accept-Language": "bn-BD,en;q=0.5"})
+	if lang != "bn" {
+		t.Fatalf("expected language bn but got %s", lang)
+	}
+
+	err := errors.BadRequest("validation", "invalid request", nil)
+	if message := GetErrorMessage(err, lang); message != "অবৈধ অনুরোধ" {
+		t.Errorf("unexpected message %s", message)
+	}
+
+	err = errors.BadRequest("validation", "invalid request", map[string]string{"bn": "override"})
+	if message := GetErrorMessage(err, lang); message != "override" {
+		t.Errorf("unexpected message %s", message)
+	}
+
+	// the default language of the catalog is used without a message only
+	err = errors.BadRequest("validation", "invalid input", nil)
+	if message := GetErrorMessage(err, "en"); message != "invalid input" {
+		t.Errorf("unexpected message %s", message)
+	}
+
+	err = errors.BadRequest("empty", "", nil)
+	if message := GetErrorMessage(err, "fr"); message != "Empty request" {
+		t.Errorf("unexpected message %s", message)
+	}
+}
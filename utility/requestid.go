@@ -0,0 +1,67 @@
+package utility
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const RequestIdHeader = "X-Request-ID"
+const TraceParentHeader = "traceparent"
+
+const maxRequestIdLength = 128
+
+// NewRequestId generates a random 32 character hex request id,
+// same format as the W3C trace id
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func isValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TraceIdFromTraceParent returns the trace id of a W3C traceparent
+// (version-traceid-parentid-flags), empty when it is not valid
+func TraceIdFromTraceParent(traceParent string) string {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+
+	return strings.ToLower(parts[1])
+}
+
+// RequestIdFromHeader accepts the incoming X-Request-ID or the trace id of
+// the traceparent header, a new request id is generated otherwise
+func RequestIdFromHeader(header http.Header) string {
+	if id := strings.TrimSpace(header.Get(RequestIdHeader)); isValidRequestId(id) {
+		return id
+	}
+
+	if id := TraceIdFromTraceParent(header.Get(TraceParentHeader)); len(id) != 0 {
+		return id
+	}
+
+	return NewRequestId()
+}
@@ -0,0 +1,27 @@
+package utility
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequestIdFromHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Request-ID", "abc-123")
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	if id := RequestIdFromHeader(header); id != "abc-123" {
+		t.Errorf("RequestIdFromHeader() = %s, want %s", id, "abc-123")
+	}
+
+	header.Del("X-Request-ID")
+	if id := RequestIdFromHeader(header); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("RequestIdFromHeader() = %s, want trace id", id)
+	}
+
+	header.Set("traceparent", "invalid")
+	header.Set("X-Request-ID", "bad id\n")
+	if id := RequestIdFromHeader(header); len(id) != 32 {
+		t.Errorf("RequestIdFromHeader() = %s, want generated id", id)
+	}
+}